@@ -7,8 +7,10 @@ import (
 
 	"github.com/lucialv/ryo.cat/internal/auth"
 	"github.com/lucialv/ryo.cat/pkg/env"
+	"github.com/lucialv/ryo.cat/pkg/gc"
 	"github.com/lucialv/ryo.cat/pkg/storage"
 	store "github.com/lucialv/ryo.cat/pkg/store"
+	"github.com/lucialv/ryo.cat/pkg/trash"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -19,8 +21,13 @@ import (
 type APIServer struct {
 	Config        Config
 	Store         *store.Storage
-	R2Storage     *storage.R2Storage
+	BlobStore     storage.BlobStore
+	LocalStorage  *storage.LocalStorage
 	Authenticator auth.Authenticator
+	GC            *gc.Collector
+	Trash         *trash.Bin
+	MediaURLs     *storage.URLBuilder
+	SignedURLs    *storage.SignedURLCache
 }
 
 type Config struct {
@@ -30,6 +37,55 @@ type Config struct {
 	FrontendURL string
 	Auth        AuthConfig
 	R2          R2Config
+	Storage     StorageConfig
+	Media       MediaConfig
+	Quota       QuotaConfig
+	GC          GCConfig
+	Trash       TrashConfig
+}
+
+// QuotaConfig sets how many bytes each user may store. Zero means no limit.
+// Admins are not limited unless they have a quota of their own.
+type QuotaConfig struct {
+	DefaultBytes int64
+}
+
+// MediaConfig controls the public URLs returned for stored objects.
+// LocalBaseURL replaces BaseURL when the local storage driver is in use, and
+// SignedURLTTL is how long the signed URLs handed out for media of unlisted
+// and private posts stay valid.
+type MediaConfig struct {
+	BaseURL      string
+	PathPrefix   string
+	LocalBaseURL string
+	SignedURLTTL time.Duration
+}
+
+type GCConfig struct {
+	Enabled     bool
+	DryRun      bool
+	Interval    time.Duration
+	GracePeriod time.Duration
+}
+
+// TrashConfig sets how long deleted files stay in the trash before they are
+// purged, and how often purges run. A zero PurgeInterval disables purging.
+type TrashConfig struct {
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+// StorageConfig picks the storage driver. The timeout, retry and breaker
+// settings apply to R2; see storage.ResilientConfig.
+type StorageConfig struct {
+	Driver           string
+	LocalDir         string
+	LocalSecret      string
+	Timeout          time.Duration
+	TransferTimeout  time.Duration
+	MaxRetries       int
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
 }
 
 type R2Config struct {
@@ -55,13 +111,32 @@ type Token struct {
 	Exp    time.Duration
 }
 
-func NewAPIServer(config Config, store *store.Storage, r2Storage *storage.R2Storage, authenticator auth.Authenticator) *APIServer {
-	return &APIServer{
+func NewAPIServer(config Config, store *store.Storage, blobStore storage.BlobStore, authenticator auth.Authenticator) *APIServer {
+	server := &APIServer{
 		Config:        config,
 		Store:         store,
-		R2Storage:     r2Storage,
+		BlobStore:     blobStore,
 		Authenticator: authenticator,
+		GC:            gc.NewCollector(store, blobStore, config.GC.GracePeriod),
+		Trash:         trash.NewBin(store, blobStore, config.Trash.Retention),
+	}
+	mediaBaseURL := config.Media.BaseURL
+	if local, ok := blobStore.(*storage.LocalStorage); ok {
+		server.LocalStorage = local
+		if config.Media.LocalBaseURL != "" {
+			mediaBaseURL = config.Media.LocalBaseURL
+		}
+	}
+	server.MediaURLs = storage.NewURLBuilder(storage.URLConfig{
+		BaseURL:    mediaBaseURL,
+		PathPrefix: config.Media.PathPrefix,
+	})
+	signedURLTTL := config.Media.SignedURLTTL
+	if signedURLTTL <= 0 {
+		signedURLTTL = time.Hour
 	}
+	server.SignedURLs = storage.NewSignedURLCache(blobStore, signedURLTTL)
+	return server
 }
 
 func (s *APIServer) Run() {
@@ -76,6 +151,7 @@ func (s *APIServer) Run() {
 			AllowedOrigins:   []string{env.GetString("CORS_ALLOWED_ORIGIN", "http://localhost:5173")},
 			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+			ExposedHeaders:   []string{"ETag"},
 			AllowCredentials: true,
 			MaxAge:           300,
 		}),
@@ -83,6 +159,16 @@ func (s *APIServer) Run() {
 
 	router.Use(middleware.Timeout(60 * time.Second))
 
+	if s.Config.GC.Enabled && s.Config.GC.Interval > 0 {
+		stop := s.GC.Start(s.Config.GC.Interval, s.Config.GC.DryRun)
+		defer stop()
+	}
+
+	if s.Config.Trash.PurgeInterval > 0 {
+		stop := s.Trash.Start(s.Config.Trash.PurgeInterval)
+		defer stop()
+	}
+
 	router.Route("/api", func(r chi.Router) {
 		r.Mount("/v1", s.Routes())
 	})
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lucialv/ryo.cat/pkg/media"
+)
+
+// BackfillMediaDimensions records the width, height and BlurHash of post
+// images uploaded before those columns existed. Images that can't be read
+// are logged and skipped, so it is safe to run again.
+func (s *APIServer) BackfillMediaDimensions() error {
+	keys, err := s.Store.Media.ListMissingDimensions()
+	if err != nil {
+		return fmt.Errorf("failed to list media without dimensions: %w", err)
+	}
+
+	updated := 0
+	for i, key := range keys {
+		body, _, err := s.BlobStore.OpenFile(key)
+		if err != nil {
+			log.Printf("[%d/%d] failed to open %s: %v", i+1, len(keys), key, err)
+			continue
+		}
+		img, err := media.Decode(body)
+		body.Close()
+		if err != nil {
+			log.Printf("[%d/%d] failed to decode %s: %v", i+1, len(keys), key, err)
+			continue
+		}
+
+		info, err := media.Analyze(img)
+		if err != nil {
+			log.Printf("[%d/%d] failed to analyze %s: %v", i+1, len(keys), key, err)
+			continue
+		}
+		if err := s.Store.Media.SetDimensions(key, info.Width, info.Height, info.BlurHash); err != nil {
+			return fmt.Errorf("failed to record dimensions of %s: %w", key, err)
+		}
+
+		updated++
+		log.Printf("[%d/%d] %s: %dx%d", i+1, len(keys), key, info.Width, info.Height)
+	}
+
+	log.Printf("backfilled %d of %d images", updated, len(keys))
+	return nil
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lucialv/ryo.cat/pkg/gc"
+	"github.com/lucialv/ryo.cat/pkg/storage"
+	u "github.com/lucialv/ryo.cat/pkg/utils"
+)
+
+// These handlers stand in for the bucket when the local storage driver is
+// used, serving the pre-signed URLs generated by storage.LocalStorage.
+
+func localBlobKey(r *http.Request) (string, error) {
+	key, err := url.PathUnescape(chi.URLParam(r, "*"))
+	if err != nil || key == "" {
+		return "", fmt.Errorf("file key is required")
+	}
+	return key, nil
+}
+
+func (s *APIServer) downloadLocalBlobHandler(w http.ResponseWriter, r *http.Request) error {
+	key, err := localBlobKey(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.LocalStorage.VerifySignature(http.MethodGet, key, r.URL.Query()); err != nil {
+		return u.WriteJSON(w, http.StatusForbidden, ApiError{Error: err.Error()})
+	}
+
+	if err := serveBlob(w, r, s.LocalStorage, key); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return u.WriteJSON(w, http.StatusNotFound, ApiError{Error: "file not found"})
+		}
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	return nil
+}
+
+// downloadLocalMediaHandler plays the part of the CDN in local development:
+// public post media and profile pictures need no signature, and everything
+// else is left to the signed URLs of downloadLocalBlobHandler.
+func (s *APIServer) downloadLocalMediaHandler(w http.ResponseWriter, r *http.Request) error {
+	key, err := localBlobKey(r)
+	if err != nil {
+		return err
+	}
+
+	public := false
+	for _, prefix := range gc.PublicPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			public = true
+			break
+		}
+	}
+	if !public {
+		return u.WriteJSON(w, http.StatusNotFound, ApiError{Error: "file not found"})
+	}
+
+	if err := serveBlob(w, r, s.LocalStorage, key); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return u.WriteJSON(w, http.StatusNotFound, ApiError{Error: "file not found"})
+		}
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	return nil
+}
+
+// uploadLocalBlobPartHandler accepts the parts of a multipart upload sent to
+// URLs from GeneratePreSignedPartURL.
+func (s *APIServer) uploadLocalBlobPartHandler(w http.ResponseWriter, r *http.Request) error {
+	key, err := localBlobKey(r)
+	if err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	if !query.Has("uploadId") {
+		return u.WriteJSON(w, http.StatusForbidden, ApiError{Error: storage.ErrInvalidSignature.Error()})
+	}
+	if err := s.LocalStorage.VerifySignature(http.MethodPut, key, query); err != nil {
+		return u.WriteJSON(w, http.StatusForbidden, ApiError{Error: err.Error()})
+	}
+
+	partNumber, err := strconv.ParseInt(query.Get("partNumber"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid part number")
+	}
+
+	body := http.MaxBytesReader(w, r.Body, multipartPartSize)
+	etag, err := s.LocalStorage.UploadPart(key, query.Get("uploadId"), partNumber, body)
+	if err != nil {
+		return fmt.Errorf("failed to upload part to storage: %w", err)
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf("%q", etag))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// postLocalBlobHandler accepts form uploads made with a pre-signed POST. As
+// with S3, the policy fields have to come before the file.
+func (s *APIServer) postLocalBlobHandler(w http.ResponseWriter, r *http.Request) error {
+	// the policy holds the real limit; this only has to fit the largest one
+	r.Body = http.MaxBytesReader(w, r.Body, max(maxFileSize, maxPostMediaSize)+localPostFormOverhead)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("failed to parse multipart form: %w", err)
+	}
+
+	fields := make(map[string]string)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return fmt.Errorf("failed to get file from form: %w", http.ErrMissingFile)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse multipart form: %w", err)
+		}
+
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, localPostFormOverhead))
+			part.Close()
+			if err != nil {
+				return fmt.Errorf("failed to parse multipart form: %w", err)
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		conditions, err := s.LocalStorage.VerifyPost(fields)
+		if err != nil {
+			part.Close()
+			return u.WriteJSON(w, http.StatusForbidden, ApiError{Error: err.Error()})
+		}
+
+		body := &sizeRangeReader{r: part, min: conditions.MinSize, max: conditions.MaxSize}
+		err = s.LocalStorage.UploadStream(conditions.Key, body, conditions.ContentType, nil)
+		part.Close()
+		if errors.Is(err, errSizeOutOfRange) {
+			return u.WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upload file to storage: %w", err)
+		}
+
+		if info, err := s.LocalStorage.GetFileInfo(conditions.Key); err == nil {
+			w.Header().Set("ETag", fmt.Sprintf("%q", info.ETag))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// localPostFormOverhead is how much room the policy fields and multipart
+// boundaries get on top of the file itself.
+const localPostFormOverhead = 64 << 10
+
+var errSizeOutOfRange = errors.New("file size is outside the range allowed by the policy")
+
+// sizeRangeReader fails the upload once more than max bytes have been read,
+// or at EOF if fewer than min were.
+type sizeRangeReader struct {
+	r        io.Reader
+	min, max int64
+	n        int64
+}
+
+func (s *sizeRangeReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	if s.n > s.max {
+		return n, errSizeOutOfRange
+	}
+	if err == io.EOF && s.n < s.min {
+		return n, errSizeOutOfRange
+	}
+	return n, err
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucialv/ryo.cat/pkg/storage"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// serveBlob writes the object at key to w. It answers conditional requests
+// from the object's ETag and modification time and serves a single byte
+// range as 206 Partial Content, asking the store for just that range.
+// Multi-range requests get the whole object. Headers already set on w, like
+// Content-Type or Cache-Control, are kept.
+func serveBlob(w http.ResponseWriter, r *http.Request, blobs storage.BlobStore, key string) error {
+	info, err := blobs.GetFileInfoWithContext(r.Context(), key)
+	if err != nil {
+		return err
+	}
+
+	h := w.Header()
+	if h.Get("Content-Type") == "" && info.ContentType != "" {
+		h.Set("Content-Type", info.ContentType)
+	}
+	h.Set("Accept-Ranges", "bytes")
+
+	etag := ""
+	if info.ETag != "" {
+		etag = fmt.Sprintf("%q", info.ETag)
+		h.Set("ETag", etag)
+	}
+	if !info.LastModified.IsZero() {
+		h.Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, info.LastModified) {
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader != "" && !ifRangeMatches(r.Header.Get("If-Range"), etag, info.LastModified) {
+		rangeHeader = ""
+	}
+
+	start, length, ok, err := parseRange(rangeHeader, info.Size)
+	if err != nil {
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return nil
+	}
+
+	// the body is streamed for as long as the client takes, so it isn't tied
+	// to the request context and its timeout
+	var body io.ReadCloser
+	if ok {
+		body, err = blobs.OpenFileRange(key, start, length)
+	} else {
+		body, _, err = blobs.OpenFile(key)
+		length = info.Size
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	if ok {
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	// the status line is already out, so a failed copy can only be logged
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("failed to stream %s: %v", key, err)
+	}
+	return nil
+}
+
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
+
+func ifRangeMatches(ifRange, etag string, lastModified time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return etag != "" && ifRange == etag
+	}
+	if strings.HasPrefix(ifRange, "W/") {
+		return false
+	}
+
+	t, err := http.ParseTime(ifRange)
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	return lastModified.Truncate(time.Second).Equal(t)
+}
+
+// parseRange handles a single "bytes=" range. ok is false when the whole
+// object should be sent instead, which is also how malformed and multi-range
+// headers are treated.
+func parseRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	if first == "" {
+		// suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end - start + 1, true, nil
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lucialv/ryo.cat/pkg/storage"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		size        int64
+		start       int64
+		length      int64
+		ok          bool
+		unsatisfied bool
+	}{
+		{"no header", "", 100, 0, 0, false, false},
+		{"closed range", "bytes=0-9", 100, 0, 10, true, false},
+		{"open range", "bytes=90-", 100, 90, 10, true, false},
+		{"end past size", "bytes=50-500", 100, 50, 50, true, false},
+		{"suffix", "bytes=-10", 100, 90, 10, true, false},
+		{"suffix longer than object", "bytes=-500", 100, 0, 100, true, false},
+		{"spaces around spec", "bytes= 5-6 ", 100, 5, 2, true, false},
+		{"start past end", "bytes=100-", 100, 0, 0, false, true},
+		{"empty suffix", "bytes=-0", 100, 0, 0, false, true},
+		{"suffix of empty object", "bytes=-5", 0, 0, 0, false, true},
+		{"multiple ranges", "bytes=0-1,5-6", 100, 0, 0, false, false},
+		{"other unit", "items=0-1", 100, 0, 0, false, false},
+		{"end before start", "bytes=9-1", 100, 0, 0, false, false},
+		{"not a number", "bytes=a-b", 100, 0, 0, false, false},
+		{"negative start", "bytes=-1-5", 100, 0, 0, false, false},
+		{"no dash", "bytes=5", 100, 0, 0, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, length, ok, err := parseRange(tt.header, tt.size)
+			if tt.unsatisfied {
+				if err != errRangeNotSatisfiable {
+					t.Fatalf("got error %v, want errRangeNotSatisfiable", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tt.ok || start != tt.start || length != tt.length {
+				t.Fatalf("got (%d, %d, %v), want (%d, %d, %v)", start, length, ok, tt.start, tt.length, tt.ok)
+			}
+		})
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	httpDate := modified.Format(http.TimeFormat)
+
+	tests := []struct {
+		name         string
+		ifRange      string
+		etag         string
+		lastModified time.Time
+		want         bool
+	}{
+		{"no If-Range", "", `"abc"`, modified, true},
+		{"same etag", `"abc"`, `"abc"`, modified, true},
+		{"other etag", `"xyz"`, `"abc"`, modified, false},
+		{"etag without one to compare", `"abc"`, "", modified, false},
+		{"weak etag", `W/"abc"`, `"abc"`, modified, false},
+		{"same date", httpDate, `"abc"`, modified, true},
+		{"older date", modified.Add(-time.Hour).Format(http.TimeFormat), `"abc"`, modified, false},
+		{"date without modification time", httpDate, `"abc"`, time.Time{}, false},
+		{"garbage", "yesterday", `"abc"`, modified, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ifRangeMatches(tt.ifRange, tt.etag, tt.lastModified); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		etag    string
+		want    bool
+	}{
+		{"unconditional", http.MethodGet, nil, `"abc"`, false},
+		{"matching etag", http.MethodGet, map[string]string{"If-None-Match": `"abc"`}, `"abc"`, true},
+		{"matching weak etag", http.MethodGet, map[string]string{"If-None-Match": `W/"abc"`}, `"abc"`, true},
+		{"etag in a list", http.MethodGet, map[string]string{"If-None-Match": `"x", "abc"`}, `"abc"`, true},
+		{"wildcard", http.MethodGet, map[string]string{"If-None-Match": "*"}, `"abc"`, true},
+		{"other etag", http.MethodGet, map[string]string{"If-None-Match": `"x"`}, `"abc"`, false},
+		{"etag wins over date", http.MethodGet, map[string]string{
+			"If-None-Match":     `"x"`,
+			"If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat),
+		}, `"abc"`, false},
+		{"not modified since", http.MethodHead, map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, `"abc"`, true},
+		{"modified since", http.MethodGet, map[string]string{"If-Modified-Since": modified.Add(-time.Hour).Format(http.TimeFormat)}, `"abc"`, false},
+		{"not a read", http.MethodPost, map[string]string{"If-None-Match": `"abc"`}, `"abc"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := notModified(r, tt.etag, modified); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeBlob(t *testing.T) {
+	blobs := storage.NewMemoryStorage()
+	if err := blobs.UploadFile("files/a.txt", []byte("0123456789"), "text/plain"); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	info, err := blobs.GetFileInfo("files/a.txt")
+	if err != nil {
+		t.Fatalf("failed to get info: %v", err)
+	}
+	etag := `"` + info.ETag + `"`
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"whole object", nil, http.StatusOK, "0123456789", ""},
+		{"range", map[string]string{"Range": "bytes=2-4"}, http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"suffix range", map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"unsatisfiable", map[string]string{"Range": "bytes=20-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"stale If-Range", map[string]string{"Range": "bytes=2-4", "If-Range": `"old"`}, http.StatusOK, "0123456789", ""},
+		{"current If-Range", map[string]string{"Range": "bytes=2-4", "If-Range": etag}, http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"not modified", map[string]string{"If-None-Match": etag}, http.StatusNotModified, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			if err := serveBlob(w, r, blobs, "files/a.txt"); err != nil {
+				t.Fatalf("serveBlob: %v", err)
+			}
+
+			res := w.Result()
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != tt.status {
+				t.Fatalf("got status %d, want %d", res.StatusCode, tt.status)
+			}
+			if string(body) != tt.body {
+				t.Fatalf("got body %q, want %q", body, tt.body)
+			}
+			if got := res.Header.Get("Content-Range"); got != tt.contentRange {
+				t.Fatalf("got Content-Range %q, want %q", got, tt.contentRange)
+			}
+		})
+	}
+}
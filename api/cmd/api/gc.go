@@ -0,0 +1,29 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	u "github.com/lucialv/ryo.cat/pkg/utils"
+)
+
+// runGCHandler runs a storage collection right away. It only reports what it
+// would delete unless dryRun=false is passed.
+func (s *APIServer) runGCHandler(w http.ResponseWriter, r *http.Request) error {
+	dryRun := true
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid dryRun value: %s", v)
+		}
+		dryRun = parsed
+	}
+
+	report, err := s.GC.Run(dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to run storage gc: %w", err)
+	}
+
+	return u.WriteJSON(w, http.StatusOK, report)
+}
@@ -38,7 +38,7 @@ func (s *APIServer) AuthTokenMiddleware(next http.Handler) http.Handler {
 		ctx := r.Context()
 
 		user, err := s.Store.Users.GetBySub(userSub)
-		if err != nil {
+		if err != nil || user == nil {
 			log.Printf("User not found for sub: %s", userSub)
 			u.WriteJSON(w, http.StatusUnauthorized, fmt.Errorf("token invalid"))
 			return
@@ -82,7 +82,7 @@ func (s *APIServer) OptionalAuthTokenMiddleware(next http.Handler) http.Handler
 
 		ctx := r.Context()
 		user, err := s.Store.Users.GetBySub(userSub)
-		if err != nil {
+		if err != nil || user == nil {
 			log.Printf("User not found for sub in optional auth: %s", userSub)
 			next.ServeHTTP(w, r)
 			return
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/lucialv/ryo.cat/pkg/storage"
+	"github.com/lucialv/ryo.cat/pkg/store"
+	"github.com/lucialv/ryo.cat/pkg/utils"
+	u "github.com/lucialv/ryo.cat/pkg/utils"
+)
+
+const (
+	multipartPartSize      = 16 << 20
+	maxMultipartMediaSize  = 2 << 30
+	multipartURLExpiration = 6 * 60 * 60
+)
+
+type InitiateMultipartUploadRequest struct {
+	ContentType string `json:"contentType"`
+	FileSize    int64  `json:"fileSize"`
+}
+
+type MultipartPartURL struct {
+	PartNumber int64  `json:"partNumber"`
+	URL        string `json:"url"`
+}
+
+type InitiateMultipartUploadResponse struct {
+	UploadID   string             `json:"uploadId"`
+	FileKey    string             `json:"fileKey"`
+	PartSize   int64              `json:"partSize"`
+	Parts      []MultipartPartURL `json:"parts"`
+	Expiration int64              `json:"expiration"`
+}
+
+type CompleteMultipartUploadRequest struct {
+	FileKey string                  `json:"fileKey"`
+	Parts   []storage.CompletedPart `json:"parts"`
+}
+
+func (s *APIServer) initiateMultipartUploadHandler(w http.ResponseWriter, r *http.Request) error {
+	var req InitiateMultipartUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+
+	if _, err := postMediaType(req.ContentType); err != nil {
+		return err
+	}
+
+	if req.FileSize <= 0 {
+		return fmt.Errorf("file size is required")
+	}
+	if req.FileSize > maxMultipartMediaSize {
+		return fmt.Errorf("file is too large. Maximum size is %d bytes", maxMultipartMediaSize)
+	}
+
+	user := r.Context().Value(userCtx).(*store.User)
+	if err := s.checkQuota(user, req.FileSize, ""); err != nil {
+		return quotaError(w, err)
+	}
+
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("failed to create a new uuid")
+	}
+
+	key := fmt.Sprintf("%s%s%s", postMediaKeyPrefix, uuid, utils.ConvertFileType(req.ContentType))
+
+	uploadID, err := s.BlobStore.CreateMultipartUploadWithContext(r.Context(), key, req.ContentType)
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	partCount := (req.FileSize + multipartPartSize - 1) / multipartPartSize
+	parts := make([]MultipartPartURL, 0, partCount)
+	for n := int64(1); n <= partCount; n++ {
+		url, err := s.BlobStore.GeneratePreSignedPartURL(key, uploadID, n, multipartURLExpiration)
+		if err != nil {
+			s.BlobStore.AbortMultipartUpload(key, uploadID)
+			return fmt.Errorf("failed to generate part upload URL: %w", err)
+		}
+		parts = append(parts, MultipartPartURL{PartNumber: n, URL: url})
+	}
+
+	response := InitiateMultipartUploadResponse{
+		UploadID:   uploadID,
+		FileKey:    key,
+		PartSize:   multipartPartSize,
+		Parts:      parts,
+		Expiration: multipartURLExpiration,
+	}
+
+	return u.WriteJSON(w, http.StatusCreated, response)
+}
+
+func (s *APIServer) completeMultipartUploadHandler(w http.ResponseWriter, r *http.Request) error {
+	uploadID := chi.URLParam(r, "uploadId")
+	if uploadID == "" {
+		return fmt.Errorf("upload ID is required")
+	}
+
+	var req CompleteMultipartUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+
+	if !strings.HasPrefix(req.FileKey, postMediaKeyPrefix) {
+		return fmt.Errorf("invalid file key: %s", req.FileKey)
+	}
+	if len(req.Parts) == 0 {
+		return fmt.Errorf("at least one part is required")
+	}
+
+	sort.Slice(req.Parts, func(i, j int) bool {
+		return req.Parts[i].PartNumber < req.Parts[j].PartNumber
+	})
+
+	if err := s.BlobStore.CompleteMultipartUploadWithContext(r.Context(), req.FileKey, uploadID, req.Parts); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	// the client only declared a content type when starting the upload, so
+	// check what actually landed in the bucket before handing it back
+	response, err := s.verifyUploadedMedia(req.FileKey, maxMultipartMediaSize, "")
+	if err != nil {
+		return err
+	}
+
+	user := r.Context().Value(userCtx).(*store.User)
+	s.recordFile(user.ID, response.FileKey, path.Base(response.FileKey), response.MimeType, response.FileSize, store.FileCategoryPostMedia)
+
+	return u.WriteJSON(w, http.StatusCreated, response)
+}
+
+func (s *APIServer) abortMultipartUploadHandler(w http.ResponseWriter, r *http.Request) error {
+	uploadID := chi.URLParam(r, "uploadId")
+	if uploadID == "" {
+		return fmt.Errorf("upload ID is required")
+	}
+
+	key := r.URL.Query().Get("fileKey")
+	if !strings.HasPrefix(key, postMediaKeyPrefix) {
+		return fmt.Errorf("invalid file key: %s", key)
+	}
+
+	if err := s.BlobStore.AbortMultipartUploadWithContext(r.Context(), key, uploadID); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+
+	return u.WriteJSON(w, http.StatusOK, map[string]string{"message": "upload aborted successfully"})
+}
@@ -1,25 +1,37 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gofrs/uuid"
+	"github.com/lucialv/ryo.cat/pkg/media"
 	"github.com/lucialv/ryo.cat/pkg/store"
 	"github.com/lucialv/ryo.cat/pkg/utils"
 	u "github.com/lucialv/ryo.cat/pkg/utils"
 )
 
+const (
+	postMediaKeyPrefix = "posts/media/"
+	// media of posts that aren't public; the CDN must not serve this prefix
+	privatePostMediaKeyPrefix = "private/posts/media/"
+)
+
 type CreatePostRequest struct {
-	Body      string               `json:"body"`
-	MediaKeys []string             `json:"mediaKeys,omitempty"`
-	Media     []CreateMediaRequest `json:"media,omitempty"`
+	Body       string               `json:"body"`
+	Visibility string               `json:"visibility,omitempty"`
+	MediaKeys  []string             `json:"mediaKeys,omitempty"`
+	Media      []CreateMediaRequest `json:"media,omitempty"`
 }
 
 type CreateMediaRequest struct {
@@ -33,12 +45,14 @@ type PostResponse struct {
 	ID          string              `json:"id"`
 	UserID      string              `json:"userId"`
 	Body        string              `json:"body"`
+	Visibility  string              `json:"visibility"`
 	CreatedAt   time.Time           `json:"createdAt"`
 	UpdatedAt   time.Time           `json:"updatedAt"`
 	User        *UserResponse       `json:"user"`
 	Media       []PostMediaResponse `json:"media"`
 	LikeCount   int                 `json:"likeCount"`
 	IsLikedByMe bool                `json:"isLikedByMe"`
+	DeletedAt   *time.Time          `json:"deletedAt,omitempty"`
 }
 
 type UserResponse struct {
@@ -50,19 +64,37 @@ type UserResponse struct {
 }
 
 type PostMediaResponse struct {
-	ID        string    `json:"id"`
-	MediaURL  string    `json:"mediaUrl"`
-	MediaType string    `json:"mediaType"`
-	MimeType  string    `json:"mimeType"`
-	FileSize  int64     `json:"fileSize"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID        string                 `json:"id"`
+	MediaURL  string                 `json:"mediaUrl"`
+	ExpiresAt *time.Time             `json:"expiresAt,omitempty"`
+	MediaType string                 `json:"mediaType"`
+	MimeType  string                 `json:"mimeType"`
+	FileSize  int64                  `json:"fileSize"`
+	Width     int                    `json:"width,omitempty"`
+	Height    int                    `json:"height,omitempty"`
+	BlurHash  string                 `json:"blurhash,omitempty"`
+	Duration  float64                `json:"duration,omitempty"`
+	HasAudio  *bool                  `json:"hasAudio,omitempty"`
+	Codec     string                 `json:"codec,omitempty"`
+	Variants  []MediaVariantResponse `json:"variants"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+type MediaVariantResponse struct {
+	URL      string `json:"url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	MimeType string `json:"mimeType"`
 }
 
 type PostsListResponse struct {
-	Posts   []PostResponse `json:"posts"`
-	Page    int            `json:"page"`
-	Limit   int            `json:"limit"`
-	HasMore bool           `json:"hasMore"`
+	Posts []PostResponse `json:"posts"`
+	// Deprecated: only set when the page parameter is used; pass
+	// NextCursor back as cursor instead.
+	Page       int    `json:"page,omitempty"`
+	Limit      int    `json:"limit"`
+	HasMore    bool   `json:"hasMore"`
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 func (s *APIServer) createPostHandler(w http.ResponseWriter, r *http.Request) error {
@@ -75,49 +107,68 @@ func (s *APIServer) createPostHandler(w http.ResponseWriter, r *http.Request) er
 		return fmt.Errorf("post body cannot be empty")
 	}
 
+	visibility := store.VisibilityPublic
+	switch req.Visibility {
+	case "", store.VisibilityPublic:
+	case store.VisibilityUnlisted, store.VisibilityPrivate:
+		visibility = req.Visibility
+	default:
+		return fmt.Errorf("invalid visibility: %s. Must be 'public', 'unlisted' or 'private'", req.Visibility)
+	}
+
 	user := r.Context().Value(userCtx).(*store.User)
 
-	post := store.NewPost(user.ID, req.Body)
-	if err := s.Store.Posts.CreatePost(post); err != nil {
-		return fmt.Errorf("failed to create post: %w", err)
-	}
+	// only media the API has inspected has a media object, so the type and
+	// size come from there rather than from the request
+	media := make([]store.PostMedia, 0, len(req.Media))
+	keys := make([]string, 0, len(req.Media))
+	for _, m := range req.Media {
+		mediaObject, err := s.Store.Media.GetByKey(m.FileKey)
+		if err != nil {
+			return fmt.Errorf("failed to look up media: %w", err)
+		}
+		if mediaObject == nil {
+			return fmt.Errorf("media has not been uploaded or finalized: %s", m.FileKey)
+		}
 
-	var media []store.PostMedia
-	if len(req.Media) > 0 {
-		for _, m := range req.Media {
-			if m.MediaType != "image" && m.MediaType != "video" {
-				return fmt.Errorf("invalid media type: %s. Must be 'image' or 'video'", m.MediaType)
-			}
+		mediaType, err := postMediaType(mediaObject.MimeType)
+		if err != nil {
+			return err
+		}
 
-			exists, err := s.R2Storage.FileExists(m.FileKey)
+		if visibility != store.VisibilityPublic {
+			mediaObject, err = s.privateMediaObject(r.Context(), mediaObject, user.ID)
 			if err != nil {
-				return fmt.Errorf("failed to check if media file exists: %w", err)
-			}
-			if !exists {
-				return fmt.Errorf("media file not found: %s", m.FileKey)
+				return err
 			}
+		} else if mediaObject.Private {
+			return fmt.Errorf("media of a post that isn't public can't be used in a public one: %s", m.FileKey)
+		}
 
-			fileInfo, err := s.R2Storage.GetFileInfo(m.FileKey)
-			if err != nil {
-				return fmt.Errorf("failed to check media info: %w", err)
-			}
+		postMedia := store.NewPostMedia(
+			"",
+			mediaType,
+			mediaObject.FileKey,
+			mediaObject.MimeType,
+			mediaObject.FileSize,
+		)
+		postMedia.ContentHash = mediaObject.ContentHash
+		postMedia.Width = mediaObject.Width
+		postMedia.Height = mediaObject.Height
+		postMedia.BlurHash = mediaObject.BlurHash
+		postMedia.VideoMetadata = mediaObject.VideoMetadata
+		media = append(media, *postMedia)
+		keys = append(keys, mediaObject.FileKey)
+	}
 
-			mediaURL := fmt.Sprintf("https://cdn.ryo.cat/%s", fileInfo.Key)
-
-			postMedia := store.NewPostMedia(
-				post.ID,
-				mediaURL,
-				m.MediaType,
-				m.FileKey,
-				m.MimeType,
-				m.FileSize,
-			)
-			media = append(media, *postMedia)
-		}
+	if err := s.checkMediaExists(r.Context(), keys); err != nil {
+		return err
+	}
 
-		if err := s.Store.Posts.AddMediaToPost(post.ID, media); err != nil {
-			return fmt.Errorf("failed to add media to post: %w", err)
-		}
+	post := store.NewPost(user.ID, req.Body)
+	post.Visibility = visibility
+	if err := s.Store.CreatePostWithMedia(post, media); err != nil {
+		return err
 	}
 
 	createdPost, err := s.Store.Posts.GetPostByID(post.ID)
@@ -125,7 +176,10 @@ func (s *APIServer) createPostHandler(w http.ResponseWriter, r *http.Request) er
 		return fmt.Errorf("failed to retrieve created post: %w", err)
 	}
 
-	response := convertPostToResponse(createdPost)
+	response, err := s.convertPostToResponse(createdPost)
+	if err != nil {
+		return err
+	}
 	return u.WriteJSON(w, http.StatusCreated, response)
 }
 
@@ -153,70 +207,19 @@ func (s *APIServer) getPostHandler(w http.ResponseWriter, r *http.Request) error
 		return fmt.Errorf("failed to get post: %w", err)
 	}
 
-	if post == nil {
+	if post == nil || !canViewPost(post, currentUserID) {
 		return fmt.Errorf("post not found")
 	}
 
-	response := convertPostToResponse(post)
+	response, err := s.convertPostToResponse(post)
+	if err != nil {
+		return err
+	}
 	return u.WriteJSON(w, http.StatusOK, response)
 }
 
 func (s *APIServer) listPostsHandler(w http.ResponseWriter, r *http.Request) error {
-	limitStr := r.URL.Query().Get("limit")
-	pageStr := r.URL.Query().Get("page")
-
-	limit := 10
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
-			limit = l
-		}
-	}
-
-	page := 1
-	if pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
-		}
-	}
-
-	offset := (page - 1) * limit
-
-	currentUserID := ""
-	if user, ok := r.Context().Value(userCtx).(*store.User); ok && user != nil {
-		currentUserID = user.ID
-	}
-
-	var posts []store.Post
-	var err error
-
-	if currentUserID != "" {
-		posts, err = s.Store.Posts.GetAllPostsWithUserContext(limit+1, offset, currentUserID)
-	} else {
-		posts, err = s.Store.Posts.GetAllPosts(limit+1, offset)
-	}
-
-	if err != nil {
-		return fmt.Errorf("failed to get posts: %w", err)
-	}
-
-	hasMore := len(posts) > limit
-	if hasMore {
-		posts = posts[:limit]
-	}
-
-	var responses []PostResponse
-	for _, post := range posts {
-		responses = append(responses, convertPostToResponse(&post))
-	}
-
-	response := PostsListResponse{
-		Posts:   responses,
-		Page:    page,
-		Limit:   limit,
-		HasMore: hasMore,
-	}
-
-	return u.WriteJSON(w, http.StatusOK, response)
+	return s.writeFeed(w, r, "")
 }
 
 func (s *APIServer) getUserPostsHandler(w http.ResponseWriter, r *http.Request) error {
@@ -225,8 +228,16 @@ func (s *APIServer) getUserPostsHandler(w http.ResponseWriter, r *http.Request)
 		return fmt.Errorf("user ID is required")
 	}
 
+	return s.writeFeed(w, r, userID)
+}
+
+// writeFeed writes a page of posts, only those by userID when it is set.
+// Pages are continued with the cursor parameter; the page parameter is
+// still accepted but skips or repeats posts when new ones arrive.
+func (s *APIServer) writeFeed(w http.ResponseWriter, r *http.Request, userID string) error {
 	limitStr := r.URL.Query().Get("limit")
 	pageStr := r.URL.Query().Get("page")
+	cursorStr := r.URL.Query().Get("cursor")
 
 	limit := 10
 	if limitStr != "" {
@@ -235,31 +246,33 @@ func (s *APIServer) getUserPostsHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	page := 1
-	if pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
+	query := store.FeedQuery{UserID: userID}
+
+	page := 0
+	if cursorStr != "" {
+		cursor, err := store.ParseCursor(cursorStr)
+		if err != nil {
+			return err
 		}
+		query.After = cursor
+	} else {
+		page = 1
+		if pageStr != "" {
+			if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+				page = p
+			}
+		}
+		query.Offset = (page - 1) * limit
 	}
 
-	offset := (page - 1) * limit
-
-	currentUserID := ""
 	if user, ok := r.Context().Value(userCtx).(*store.User); ok && user != nil {
-		currentUserID = user.ID
-	}
-
-	var posts []store.Post
-	var err error
-
-	if currentUserID != "" {
-		posts, err = s.Store.Posts.GetPostsByUserIDWithUserContext(userID, limit+1, offset, currentUserID)
-	} else {
-		posts, err = s.Store.Posts.GetPostsByUserID(userID, limit+1, offset)
+		query.CurrentUserID = user.ID
 	}
 
+	query.Limit = limit + 1
+	posts, err := s.Store.Posts.ListFeed(query)
 	if err != nil {
-		return fmt.Errorf("failed to get user posts: %w", err)
+		return fmt.Errorf("failed to get posts: %w", err)
 	}
 
 	hasMore := len(posts) > limit
@@ -269,7 +282,11 @@ func (s *APIServer) getUserPostsHandler(w http.ResponseWriter, r *http.Request)
 
 	var responses []PostResponse
 	for _, post := range posts {
-		responses = append(responses, convertPostToResponse(&post))
+		response, err := s.convertPostToResponse(&post)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, response)
 	}
 
 	response := PostsListResponse{
@@ -278,6 +295,9 @@ func (s *APIServer) getUserPostsHandler(w http.ResponseWriter, r *http.Request)
 		Limit:   limit,
 		HasMore: hasMore,
 	}
+	if hasMore {
+		response.NextCursor = store.CursorFor(&posts[len(posts)-1]).Encode()
+	}
 
 	return u.WriteJSON(w, http.StatusOK, response)
 }
@@ -303,12 +323,8 @@ func (s *APIServer) deletePostHandler(w http.ResponseWriter, r *http.Request) er
 		return fmt.Errorf("you can only delete your own posts")
 	}
 
-	for _, media := range post.Media {
-		if err := s.R2Storage.DeleteFile(media.FileKey); err != nil {
-			fmt.Printf("failed to delete media file %s: %v", media.FileKey, err)
-		}
-	}
-
+	// the post keeps its likes and media until it is purged, so an admin
+	// can restore it in the meantime
 	if err := s.Store.Posts.DeletePost(postID); err != nil {
 		return fmt.Errorf("failed to delete post: %w", err)
 	}
@@ -328,8 +344,8 @@ func (s *APIServer) toggleLikeHandler(w http.ResponseWriter, r *http.Request) er
 	if err != nil {
 		return fmt.Errorf("failed to get post: %w", err)
 	}
-	if post == nil {
-		return fmt.Errorf("post not found")
+	if post == nil || !canViewPost(post, user.ID) {
+		return u.WriteJSON(w, http.StatusNotFound, ApiError{Error: "post not found"})
 	}
 
 	isLiked, err := s.Store.Posts.ToggleLike(postID, user.ID)
@@ -351,55 +367,281 @@ func (s *APIServer) toggleLikeHandler(w http.ResponseWriter, r *http.Request) er
 }
 
 func (s *APIServer) uploadPostMediaHandler(w http.ResponseWriter, r *http.Request) error {
-	// 50MB limit :c
-	err := r.ParseMultipartForm(50 << 20)
+	file, err := formFileStream(w, r, "file", maxPostMediaSize)
 	if err != nil {
-		return fmt.Errorf("failed to parse multipart form: %w", err)
-	}
-
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		return fmt.Errorf("failed to get file from form: %w", err)
+		return err
 	}
 	defer file.Close()
 
-	data, err := io.ReadAll(file)
+	contentType, body, err := u.SniffContentType(file)
 	if err != nil {
 		return fmt.Errorf("failed to read file data: %w", err)
 	}
 
-	contentType := http.DetectContentType(data)
-
-	var mediaType string
-	if strings.HasPrefix(contentType, "image/") {
-		mediaType = "image"
-	} else if strings.HasPrefix(contentType, "video/") {
-		mediaType = "video"
-	} else {
-		return fmt.Errorf("unsupported file type: %s. Only images and videos are allowed", contentType)
+	mediaType, err := postMediaType(contentType)
+	if err != nil {
+		return err
 	}
 
-	uuid, err := uuid.NewV4()
+	spooled, err := spoolImage(body, contentType)
 	if err != nil {
-		return fmt.Errorf("failed to create a new uuid")
+		return err
 	}
+	defer spooled.Cleanup()
 
-	key := fmt.Sprintf("posts/media/%s%s", uuid, utils.ConvertFileType(contentType))
+	user := r.Context().Value(userCtx).(*store.User)
+	if err := s.checkQuota(user, spooled.Size, ""); err != nil {
+		return quotaError(w, err)
+	}
+
+	var video *media.VideoInfo
+	if mediaType == "video" {
+		video, err = probePostVideo(spooled, spooled.Size)
+		if err != nil {
+			return err
+		}
+		contentType = video.ContentType
+	}
 
-	if err := s.R2Storage.UploadFile(key, data, contentType); err != nil {
-		return fmt.Errorf("failed to upload media to R2: %w", err)
+	key, err := s.storePostMedia(spooled, contentType, video)
+	if err != nil {
+		return err
 	}
+	s.recordFile(user.ID, key, path.Base(file.FileName()), contentType, spooled.Size, store.FileCategoryPostMedia)
 
 	response := CreateMediaRequest{
 		FileKey:   key,
 		MediaType: mediaType,
 		MimeType:  contentType,
-		FileSize:  int64(len(data)),
+		FileSize:  spooled.Size,
 	}
 
 	return u.WriteJSON(w, http.StatusCreated, response)
 }
 
+// checkMediaExists makes sure every key is still in the bucket, checking
+// them all at once rather than one after another.
+func (s *APIServer) checkMediaExists(ctx context.Context, keys []string) error {
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exists, err := s.BlobStore.FileExistsWithContext(ctx, key)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to check if media file exists: %w", err)
+			} else if !exists {
+				errs[i] = fmt.Errorf("media file not found: %s", key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storePostMedia uploads a spooled post media file and returns its key. If a
+// file with the same SHA-256 is already stored, that object's key is returned
+// instead and nothing new is written to the bucket. video carries what was
+// probed from a video upload.
+func (s *APIServer) storePostMedia(file *spooledUpload, contentType string, video *media.VideoInfo) (string, error) {
+	existing, err := s.Store.Media.GetByHash(file.SHA256, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up media: %w", err)
+	}
+
+	metadata := map[string]*string{"sha256": &file.SHA256}
+
+	if existing != nil {
+		exists, err := s.BlobStore.FileExists(existing.FileKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to check if media file exists: %w", err)
+		}
+		if !exists {
+			// the row outlived its object, so put the file back where the row says it is
+			if err := s.BlobStore.UploadStream(existing.FileKey, file, contentType, metadata); err != nil {
+				return "", fmt.Errorf("failed to upload media to storage: %w", err)
+			}
+		}
+		return existing.FileKey, nil
+	}
+
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("failed to create a new uuid")
+	}
+
+	key := fmt.Sprintf("%s%s%s", postMediaKeyPrefix, uuid, utils.ConvertFileType(contentType))
+
+	if err := s.BlobStore.UploadStream(key, file, contentType, metadata); err != nil {
+		return "", fmt.Errorf("failed to upload media to storage: %w", err)
+	}
+
+	obj := store.NewMediaObject(key, file.SHA256, contentType, file.Size)
+	if video != nil {
+		applyVideoInfo(obj, video)
+	}
+
+	registered, err := s.registerPostMedia(obj)
+	if err != nil || registered != key {
+		return registered, err
+	}
+
+	if strings.HasPrefix(contentType, "image/") {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to read file data: %w", err)
+		}
+		s.processPostImage(key, file, contentType)
+	}
+
+	return key, nil
+}
+
+// processPostImage records the dimensions and BlurHash of an uploaded image
+// and stores resized copies of it next to the original. All of this is an
+// optimisation for the feed, so failures are logged and the post falls back
+// to the original.
+func (s *APIServer) processPostImage(sourceKey string, r io.Reader, contentType string) {
+	img, err := media.Decode(r)
+	if err != nil {
+		log.Printf("failed to decode %s: %v", sourceKey, err)
+		return
+	}
+
+	if info, err := media.Analyze(img); err != nil {
+		log.Printf("failed to analyze %s: %v", sourceKey, err)
+	} else if err := s.Store.Media.SetDimensions(sourceKey, info.Width, info.Height, info.BlurHash); err != nil {
+		log.Printf("failed to record dimensions of %s: %v", sourceKey, err)
+	}
+
+	variants, err := media.GenerateVariants(img, contentType)
+	if err != nil {
+		log.Printf("failed to resize %s: %v", sourceKey, err)
+		return
+	}
+
+	base := strings.TrimSuffix(sourceKey, path.Ext(sourceKey))
+
+	var records []store.MediaVariant
+	for _, v := range variants {
+		key := fmt.Sprintf("%s_w%d%s", base, v.Width, utils.ConvertFileType(v.ContentType))
+		if err := s.BlobStore.UploadFile(key, v.Data, v.ContentType); err != nil {
+			log.Printf("failed to upload variant %s: %v", key, err)
+			continue
+		}
+		records = append(records, store.MediaVariant{
+			FileKey:  key,
+			Width:    v.Width,
+			Height:   v.Height,
+			MimeType: v.ContentType,
+			FileSize: int64(len(v.Data)),
+		})
+	}
+
+	if len(records) == 0 {
+		return
+	}
+	if err := s.Store.Media.AddVariants(sourceKey, records); err != nil {
+		log.Printf("failed to record variants of %s: %v", sourceKey, err)
+	}
+}
+
+// privateMediaObject returns the private copy of obj for a post that isn't
+// public, copying the object and its variants under
+// privatePostMediaKeyPrefix the first time. The copy gets a key of its own, so
+// the signed URLs handed out for it say nothing about where the public object
+// is. The uploaded object is left to the collector once nothing uses it.
+func (s *APIServer) privateMediaObject(ctx context.Context, obj *store.MediaObject, userID string) (*store.MediaObject, error) {
+	if obj.Private {
+		return obj, nil
+	}
+
+	existing, err := s.Store.Media.GetByHash(obj.ContentHash, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up media: %w", err)
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a new uuid")
+	}
+	key := fmt.Sprintf("%s%s%s", privatePostMediaKeyPrefix, uuid, path.Ext(obj.FileKey))
+
+	if err := s.BlobStore.CopyFileWithContext(ctx, obj.FileKey, key); err != nil {
+		return nil, fmt.Errorf("failed to copy media: %w", err)
+	}
+
+	private := *obj
+	private.FileKey = key
+	private.Private = true
+	private.RefCount = 0
+	private.CreatedAt = time.Now().UTC()
+
+	registered, err := s.registerPostMedia(&private)
+	if err != nil {
+		return nil, err
+	}
+	if registered != key {
+		return s.Store.Media.GetByKey(registered)
+	}
+	s.recordFile(userID, key, path.Base(key), private.MimeType, private.FileSize, store.FileCategoryPostMedia)
+
+	// variants only spare bandwidth, so a failed copy just leaves one out
+	variants, err := s.Store.Media.GetVariants(obj.FileKey)
+	if err != nil {
+		log.Printf("failed to get variants of %s: %v", obj.FileKey, err)
+		return &private, nil
+	}
+
+	base := strings.TrimSuffix(key, path.Ext(key))
+	var records []store.MediaVariant
+	for _, v := range variants {
+		variantKey := fmt.Sprintf("%s_w%d%s", base, v.Width, path.Ext(v.FileKey))
+		if err := s.BlobStore.CopyFileWithContext(ctx, v.FileKey, variantKey); err != nil {
+			log.Printf("failed to copy variant %s: %v", v.FileKey, err)
+			continue
+		}
+		v.FileKey = variantKey
+		records = append(records, v)
+	}
+	if len(records) > 0 {
+		if err := s.Store.Media.AddVariants(key, records); err != nil {
+			log.Printf("failed to record variants of %s: %v", key, err)
+		}
+	}
+
+	return &private, nil
+}
+
+// registerPostMedia records an uploaded object for deduplication. When an
+// identical upload finished first, the new object is deleted and the key of
+// the one already recorded is returned.
+func (s *APIServer) registerPostMedia(obj *store.MediaObject) (string, error) {
+	createErr := s.Store.Media.Create(obj)
+	if createErr == nil {
+		return obj.FileKey, nil
+	}
+
+	existing, err := s.Store.Media.GetByHash(obj.ContentHash, obj.Private)
+	if err != nil || existing == nil {
+		return "", fmt.Errorf("failed to record media: %w", createErr)
+	}
+
+	if err := s.BlobStore.DeleteFile(obj.FileKey); err != nil {
+		fmt.Printf("failed to delete duplicate media file %s: %v", obj.FileKey, err)
+	}
+	return existing.FileKey, nil
+}
+
 func (s *APIServer) downloadPostMediaHandler(w http.ResponseWriter, r *http.Request) error {
 	mediaID := chi.URLParam(r, "mediaId")
 	if mediaID == "" {
@@ -415,14 +657,21 @@ func (s *APIServer) downloadPostMediaHandler(w http.ResponseWriter, r *http.Requ
 		return fmt.Errorf("media not found")
 	}
 
-	data, err := s.R2Storage.DownloadFile(media.FileKey)
+	post, err := s.Store.Posts.GetPostByID(media.PostID)
 	if err != nil {
-		return fmt.Errorf("failed to download media file: %w", err)
+		return fmt.Errorf("failed to get post: %w", err)
+	}
+	currentUserID := ""
+	if user, ok := r.Context().Value(userCtx).(*store.User); ok && user != nil {
+		currentUserID = user.ID
+	}
+	if post == nil || !canViewPost(post, currentUserID) {
+		return fmt.Errorf("media not found")
 	}
 
 	filename := fmt.Sprintf("ryo-media-%s", mediaID)
 	if media.MediaType == "video" {
-		filename += ".mp4"
+		filename += utils.ConvertFileType(media.MimeType)
 	} else {
 		switch media.MimeType {
 		case "image/png":
@@ -438,28 +687,68 @@ func (s *APIServer) downloadPostMediaHandler(w http.ResponseWriter, r *http.Requ
 
 	w.Header().Set("Content-Type", media.MimeType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	// media keys are never reused, so the object behind an ID can't change
+	if post.Visibility == store.VisibilityPublic {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	}
+
+	if err := serveBlob(w, r, s.BlobStore, media.FileKey); err != nil {
+		return fmt.Errorf("failed to download media file: %w", err)
+	}
+
+	return nil
+}
+
+func postMediaType(contentType string) (string, error) {
+	if strings.HasPrefix(contentType, "image/") {
+		return "image", nil
+	}
+	if strings.HasPrefix(contentType, "video/") {
+		return "video", nil
+	}
+	return "", fmt.Errorf("unsupported file type: %s. Only images and videos are allowed", contentType)
+}
+
+// canViewPost reports whether the user with ID currentUserID, which may be
+// empty, can see post. Unlisted posts are readable by anyone with the link.
+func canViewPost(post *store.Post, currentUserID string) bool {
+	return post.Visibility != store.VisibilityPrivate || (currentUserID != "" && post.UserID == currentUserID)
+}
+
+// mediaURL returns the URL a client should load key from for post. Media of
+// posts that aren't public gets a short-lived signed URL instead of the
+// permanent CDN one, along with its expiry.
+func (s *APIServer) mediaURL(post *store.Post, key string) (string, *time.Time, error) {
+	if post.Visibility == store.VisibilityPublic {
+		return s.MediaURLs.URL(key), nil, nil
+	}
 
-	_, err = w.Write(data)
-	return err
+	url, expires, err := s.SignedURLs.URL(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign media URL: %w", err)
+	}
+	return url, &expires, nil
 }
 
-func convertPostToResponse(post *store.Post) PostResponse {
+func (s *APIServer) convertPostToResponse(post *store.Post) (PostResponse, error) {
 	response := PostResponse{
 		ID:          post.ID,
 		UserID:      post.UserID,
 		Body:        post.Body,
+		Visibility:  post.Visibility,
 		CreatedAt:   post.CreatedAt,
 		UpdatedAt:   post.UpdatedAt,
 		LikeCount:   post.LikeCount,
 		IsLikedByMe: post.IsLikedByMe,
+		DeletedAt:   post.DeletedAt,
 	}
 
 	if post.User != nil {
 		profilePictureURL := ""
-		if post.User.ProfilePictureURL != nil {
-			profilePictureURL = *post.User.ProfilePictureURL
+		if post.User.ProfilePictureKey != nil {
+			profilePictureURL = s.MediaURLs.URL(*post.User.ProfilePictureKey)
 		}
 
 		response.User = &UserResponse{
@@ -472,15 +761,46 @@ func convertPostToResponse(post *store.Post) PostResponse {
 	}
 
 	for _, media := range post.Media {
-		response.Media = append(response.Media, PostMediaResponse{
+		variants := []MediaVariantResponse{}
+		for _, v := range media.Variants {
+			url, _, err := s.mediaURL(post, v.FileKey)
+			if err != nil {
+				return PostResponse{}, err
+			}
+			variants = append(variants, MediaVariantResponse{
+				URL:      url,
+				Width:    v.Width,
+				Height:   v.Height,
+				MimeType: v.MimeType,
+			})
+		}
+
+		mediaURL, expiresAt, err := s.mediaURL(post, media.FileKey)
+		if err != nil {
+			return PostResponse{}, err
+		}
+
+		mediaResponse := PostMediaResponse{
 			ID:        media.ID,
-			MediaURL:  media.MediaURL,
+			MediaURL:  mediaURL,
+			ExpiresAt: expiresAt,
 			MediaType: media.MediaType,
 			MimeType:  media.MimeType,
 			FileSize:  media.FileSize,
+			Width:     media.Width,
+			Height:    media.Height,
+			BlurHash:  media.BlurHash,
+			Variants:  variants,
 			CreatedAt: media.CreatedAt,
-		})
+		}
+		if media.MediaType == "video" && media.VideoCodec != "" {
+			hasAudio := media.HasAudio
+			mediaResponse.Duration = float64(media.DurationMs) / 1000
+			mediaResponse.HasAudio = &hasAudio
+			mediaResponse.Codec = media.VideoCodec
+		}
+		response.Media = append(response.Media, mediaResponse)
 	}
 
-	return response
+	return response, nil
 }
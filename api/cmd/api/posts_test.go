@@ -0,0 +1,238 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lucialv/ryo.cat/cmd/api"
+	"github.com/lucialv/ryo.cat/internal/apitest"
+	"github.com/lucialv/ryo.cat/pkg/store"
+)
+
+func testPNG(t *testing.T, c color.Color) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decode reads a JSON response into v after checking its status.
+func decode(t *testing.T, res *http.Response, status int, v any) {
+	t.Helper()
+	defer res.Body.Close()
+
+	if res.StatusCode != status {
+		body, _ := io.ReadAll(res.Body)
+		t.Fatalf("%s %s: got status %d, want %d: %s", res.Request.Method, res.Request.URL.Path, res.StatusCode, status, body)
+	}
+	if v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+}
+
+func uploadPostMedia(t *testing.T, h *apitest.Harness, user *store.User, data []byte) api.CreateMediaRequest {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "image.png")
+	if err != nil {
+		t.Fatalf("failed to create form: %v", err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := h.NewRequest(t, http.MethodPost, "/posts/media/upload", &body, user)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var media api.CreateMediaRequest
+	decode(t, h.Do(t, req), http.StatusCreated, &media)
+	return media
+}
+
+func createPost(t *testing.T, h *apitest.Harness, user *store.User, visibility string, media ...api.CreateMediaRequest) *http.Response {
+	t.Helper()
+
+	body, _ := json.Marshal(api.CreatePostRequest{Body: "hello", Visibility: visibility, Media: media})
+	return h.Do(t, h.NewRequest(t, http.MethodPost, "/posts/", bytes.NewReader(body), user))
+}
+
+func TestPostMediaVisibility(t *testing.T) {
+	h := apitest.New(t)
+	admin := h.CreateUser(t, "admin", true)
+	upload := uploadPostMedia(t, h, admin, testPNG(t, color.White))
+	publicID := strings.TrimSuffix(strings.TrimPrefix(upload.FileKey, "posts/media/"), ".png")
+
+	tests := []struct {
+		visibility string
+		urlPrefix  string
+		signed     bool
+	}{
+		{store.VisibilityPublic, "https://cdn.example.com/posts/media/", false},
+		{store.VisibilityUnlisted, "memory:///private/posts/media/", true},
+		{store.VisibilityPrivate, "memory:///private/posts/media/", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.visibility, func(t *testing.T) {
+			var post api.PostResponse
+			decode(t, createPost(t, h, admin, tt.visibility, upload), http.StatusCreated, &post)
+
+			if len(post.Media) != 1 {
+				t.Fatalf("got %d media, want 1", len(post.Media))
+			}
+			media := post.Media[0]
+			if !strings.HasPrefix(media.MediaURL, tt.urlPrefix) {
+				t.Fatalf("got URL %q, want it under %q", media.MediaURL, tt.urlPrefix)
+			}
+			if (media.ExpiresAt != nil) != tt.signed {
+				t.Fatalf("got expiry %v, want signed=%v", media.ExpiresAt, tt.signed)
+			}
+			if !tt.signed {
+				return
+			}
+
+			// the private copy mustn't lead back to the object the CDN serves
+			urls := []string{media.MediaURL}
+			for _, v := range media.Variants {
+				urls = append(urls, v.URL)
+			}
+			for _, url := range urls {
+				if strings.Contains(url, publicID) {
+					t.Fatalf("signed URL %q names the public object", url)
+				}
+				if !strings.HasPrefix(url, tt.urlPrefix) {
+					t.Fatalf("got URL %q, want it under %q", url, tt.urlPrefix)
+				}
+			}
+		})
+	}
+
+	// unlisted and private posts share one private copy
+	private, err := h.Store.Posts.ListFeed(store.FeedQuery{CurrentUserID: admin.ID, Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to list posts: %v", err)
+	}
+	keys := make(map[string]bool)
+	for _, post := range private {
+		keys[post.Media[0].FileKey] = true
+	}
+	if len(keys) != 2 {
+		t.Fatalf("got media keys %v, want one public and one private", keys)
+	}
+}
+
+func TestPublicPostRefusesPrivateMedia(t *testing.T) {
+	h := apitest.New(t)
+	admin := h.CreateUser(t, "admin", true)
+	upload := uploadPostMedia(t, h, admin, testPNG(t, color.White))
+
+	var post api.PostResponse
+	decode(t, createPost(t, h, admin, store.VisibilityPrivate, upload), http.StatusCreated, &post)
+
+	stored, err := h.Store.Posts.GetPostByID(post.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("failed to get post: %v", err)
+	}
+	upload.FileKey = stored.Media[0].FileKey
+
+	decode(t, createPost(t, h, admin, store.VisibilityPublic, upload), http.StatusBadRequest, nil)
+}
+
+func TestToggleLikeVisibility(t *testing.T) {
+	h := apitest.New(t)
+	author := h.CreateUser(t, "author", true)
+	other := h.CreateUser(t, "other", false)
+
+	postIDs := make(map[string]string)
+	for _, visibility := range []string{store.VisibilityPublic, store.VisibilityUnlisted, store.VisibilityPrivate} {
+		var post api.PostResponse
+		decode(t, createPost(t, h, author, visibility), http.StatusCreated, &post)
+		postIDs[visibility] = post.ID
+	}
+	postIDs["missing"] = "no-such-post"
+
+	tests := []struct {
+		name string
+		post string
+		user *store.User
+		want int
+	}{
+		{"public post", store.VisibilityPublic, other, http.StatusOK},
+		{"unlisted post", store.VisibilityUnlisted, other, http.StatusOK},
+		{"someone else's private post", store.VisibilityPrivate, other, http.StatusNotFound},
+		{"own private post", store.VisibilityPrivate, author, http.StatusOK},
+		{"missing post", "missing", other, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := h.Do(t, h.NewRequest(t, http.MethodPost, "/posts/"+postIDs[tt.post]+"/like", nil, tt.user))
+			decode(t, res, tt.want, nil)
+		})
+	}
+
+	count, err := h.Store.Posts.GetLikeCount(postIDs[store.VisibilityPrivate])
+	if err != nil {
+		t.Fatalf("failed to count likes: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("private post has %d likes, want only its author's", count)
+	}
+}
+
+func TestFeedCursor(t *testing.T) {
+	h := apitest.New(t)
+	author := h.CreateUser(t, "author", true)
+
+	want := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		var post api.PostResponse
+		decode(t, createPost(t, h, author, store.VisibilityPublic), http.StatusCreated, &post)
+		want[post.ID] = true
+	}
+
+	seen := make(map[string]bool)
+	path := "/posts/?limit=2"
+	for pages := 1; ; pages++ {
+		var res api.PostsListResponse
+		decode(t, h.Do(t, h.NewRequest(t, http.MethodGet, path, nil, nil)), http.StatusOK, &res)
+		for _, post := range res.Posts {
+			if seen[post.ID] {
+				t.Fatalf("post %s was on two pages", post.ID)
+			}
+			seen[post.ID] = true
+		}
+		if !res.HasMore {
+			if res.NextCursor != "" || pages != 3 {
+				t.Fatalf("last page %d has cursor %q, want page 3 without one", pages, res.NextCursor)
+			}
+			break
+		}
+		path = "/posts/?limit=2&cursor=" + res.NextCursor
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("saw %d posts, want %d", len(seen), len(want))
+	}
+
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodGet, "/posts/?cursor=garbage!", nil, nil)), http.StatusBadRequest, nil)
+}
@@ -3,8 +3,9 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
+	"path"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
@@ -14,6 +15,8 @@ import (
 	u "github.com/lucialv/ryo.cat/pkg/utils"
 )
 
+const profilePictureKeyPrefix = "profile-pictures/"
+
 type UpdateProfilePictureRequest struct {
 	ProfilePictureURL *string `json:"profilePictureUrl"`
 }
@@ -42,7 +45,7 @@ func (s *APIServer) getUserProfileHandler(w http.ResponseWriter, r *http.Request
 		Name:              user.Name,
 		Email:             user.Email,
 		IsAdmin:           user.IsAdmin,
-		ProfilePictureURL: user.ProfilePictureURL,
+		ProfilePictureURL: s.profilePictureURL(user),
 		CreatedAt:         user.CreatedAt,
 		UpdatedAt:         user.UpdatedAt,
 	}
@@ -64,11 +67,7 @@ func (s *APIServer) getProfilePictureHandler(w http.ResponseWriter, r *http.Requ
 	response := struct {
 		ProfilePictureURL *string `json:"profilePictureUrl"`
 	}{
-		ProfilePictureURL: user.ProfilePictureURL,
-	}
-
-	if user.ProfilePictureURL == nil {
-		response.ProfilePictureURL = nil
+		ProfilePictureURL: s.profilePictureURL(user),
 	}
 
 	return u.WriteJSON(w, http.StatusOK, response)
@@ -82,7 +81,16 @@ func (s *APIServer) updateProfilePictureHandler(w http.ResponseWriter, r *http.R
 		return fmt.Errorf("failed to decode request body: %w", err)
 	}
 
-	if err := s.Store.Users.UpdateProfilePicture(user.ID, req.ProfilePictureURL); err != nil {
+	var key *string
+	if req.ProfilePictureURL != nil && *req.ProfilePictureURL != "" {
+		k, err := s.profilePictureKey(*req.ProfilePictureURL)
+		if err != nil {
+			return err
+		}
+		key = &k
+	}
+
+	if err := s.Store.Users.UpdateProfilePicture(user.ID, key); err != nil {
 		return fmt.Errorf("failed to update profile picture: %w", err)
 	}
 
@@ -97,7 +105,7 @@ func (s *APIServer) updateProfilePictureHandler(w http.ResponseWriter, r *http.R
 		Name:              updatedUser.Name,
 		Email:             updatedUser.Email,
 		IsAdmin:           updatedUser.IsAdmin,
-		ProfilePictureURL: updatedUser.ProfilePictureURL,
+		ProfilePictureURL: s.profilePictureURL(updatedUser),
 		CreatedAt:         updatedUser.CreatedAt,
 		UpdatedAt:         updatedUser.UpdatedAt,
 	}
@@ -139,7 +147,7 @@ func (s *APIServer) updateUserNameHandler(w http.ResponseWriter, r *http.Request
 		Name:              updatedUser.Name,
 		Email:             updatedUser.Email,
 		IsAdmin:           updatedUser.IsAdmin,
-		ProfilePictureURL: updatedUser.ProfilePictureURL,
+		ProfilePictureURL: s.profilePictureURL(updatedUser),
 		CreatedAt:         updatedUser.CreatedAt,
 		UpdatedAt:         updatedUser.UpdatedAt,
 	}
@@ -151,24 +159,17 @@ func (s *APIServer) uploadProfilePictureHandler(w http.ResponseWriter, r *http.R
 	user := r.Context().Value(userCtx).(*store.User)
 
 	// 10MB limit :c
-	err := r.ParseMultipartForm(10 << 20)
+	file, err := formFileStream(w, r, "file", 10<<20)
 	if err != nil {
-		return fmt.Errorf("failed to parse multipart form: %w", err)
-	}
-
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		return fmt.Errorf("failed to get file from form: %w", err)
+		return err
 	}
 	defer file.Close()
 
-	data, err := io.ReadAll(file)
+	contentType, body, err := u.SniffContentType(file)
 	if err != nil {
 		return fmt.Errorf("failed to read file data: %w", err)
 	}
 
-	contentType := http.DetectContentType(data)
-
 	if !strings.HasPrefix(contentType, "image/") {
 		return fmt.Errorf("file must be an image. Got: %s", contentType)
 	}
@@ -178,32 +179,39 @@ func (s *APIServer) uploadProfilePictureHandler(w http.ResponseWriter, r *http.R
 		return fmt.Errorf("failed to create a new uuid")
 	}
 
-	key := fmt.Sprintf("profile-pictures/%s%s", uuid, utils.ConvertFileType(contentType))
+	key := fmt.Sprintf("%s%s%s", profilePictureKeyPrefix, uuid, utils.ConvertFileType(contentType))
 
-	if err := s.R2Storage.UploadFile(key, data, contentType); err != nil {
-		return fmt.Errorf("failed to upload profile picture to R2: %w", err)
+	spooled, err := spoolImage(body, contentType)
+	if err != nil {
+		return err
 	}
+	defer spooled.Cleanup()
 
-	profilePictureURL := fmt.Sprintf("https://cdn.ryo.cat/%s", key)
+	if err := s.checkQuota(user, spooled.Size, uploadedProfilePicture(user)); err != nil {
+		return quotaError(w, err)
+	}
+
+	if err := s.BlobStore.UploadStreamWithContext(r.Context(), key, spooled, contentType, nil); err != nil {
+		return fmt.Errorf("failed to upload profile picture to storage: %w", err)
+	}
 
-	if err := s.Store.Users.UpdateProfilePicture(user.ID, &profilePictureURL); err != nil {
+	if err := s.Store.Users.UpdateProfilePicture(user.ID, &key); err != nil {
 		return fmt.Errorf("failed to update profile picture in database: %w", err)
 	}
+	s.recordFile(user.ID, key, path.Base(file.FileName()), contentType, spooled.Size, store.FileCategoryProfilePicture)
 
-	if user.ProfilePictureURL != nil {
-		oldKey := extractKeyFromURL(*user.ProfilePictureURL)
-		if oldKey != "" {
-			if err := s.R2Storage.DeleteFile(oldKey); err != nil {
-				fmt.Printf("failed to delete old profile picture %s: %v", oldKey, err)
-			}
+	if oldKey := uploadedProfilePicture(user); oldKey != "" {
+		if err := s.BlobStore.DeleteFile(oldKey); err != nil {
+			fmt.Printf("failed to delete old profile picture %s: %v", oldKey, err)
 		}
+		s.forgetFile(oldKey)
 	}
 
 	response := struct {
 		ProfilePictureURL string `json:"profilePictureUrl"`
 		Message           string `json:"message"`
 	}{
-		ProfilePictureURL: profilePictureURL,
+		ProfilePictureURL: s.MediaURLs.URL(key),
 		Message:           "Profile picture updated successfully",
 	}
 
@@ -213,12 +221,10 @@ func (s *APIServer) uploadProfilePictureHandler(w http.ResponseWriter, r *http.R
 func (s *APIServer) deleteProfilePictureHandler(w http.ResponseWriter, r *http.Request) error {
 	user := r.Context().Value(userCtx).(*store.User)
 
-	if user.ProfilePictureURL != nil {
-		oldKey := extractKeyFromURL(*user.ProfilePictureURL)
-		if oldKey != "" {
-			if err := s.R2Storage.DeleteFile(oldKey); err != nil {
-				fmt.Printf("failed to delete profile picture file %s: %v", oldKey, err)
-			}
+	if oldKey := uploadedProfilePicture(user); oldKey != "" {
+		if _, err := s.Trash.Move(r.Context(), oldKey, store.FileCategoryProfilePicture, user.ID); err != nil {
+			log.Printf("failed to move profile picture %s to trash: %v", oldKey, err)
+			s.forgetFile(oldKey)
 		}
 	}
 
@@ -231,13 +237,34 @@ func (s *APIServer) deleteProfilePictureHandler(w http.ResponseWriter, r *http.R
 	})
 }
 
-func extractKeyFromURL(url string) string {
-	parts := strings.Split(url, "/")
-	if len(parts) >= 3 {
-		keyParts := parts[len(parts)-2:]
-		return strings.Join(keyParts, "/")
+func (s *APIServer) profilePictureURL(user *store.User) *string {
+	if user.ProfilePictureKey == nil {
+		return nil
+	}
+	url := s.MediaURLs.URL(*user.ProfilePictureKey)
+	return &url
+}
+
+// profilePictureKey resolves a URL sent by the client to what is stored for
+// the user: the object key for one of our uploads, or the URL itself when
+// the picture is hosted elsewhere.
+func (s *APIServer) profilePictureKey(rawURL string) (string, error) {
+	if key, ok := s.MediaURLs.Key(rawURL); ok && strings.HasPrefix(key, profilePictureKeyPrefix) {
+		return key, nil
+	}
+	if strings.HasPrefix(rawURL, "https://") || strings.HasPrefix(rawURL, "http://") {
+		return rawURL, nil
+	}
+	return "", fmt.Errorf("invalid profile picture URL")
+}
+
+// uploadedProfilePicture returns the key of the user's current picture if it
+// is one we store, or "" when there is none or it is hosted elsewhere.
+func uploadedProfilePicture(user *store.User) string {
+	if user.ProfilePictureKey == nil || !strings.HasPrefix(*user.ProfilePictureKey, profilePictureKeyPrefix) {
+		return ""
 	}
-	return ""
+	return *user.ProfilePictureKey
 }
 
 func (s *APIServer) usernameAvailabilityHandler(w http.ResponseWriter, r *http.Request) error {
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lucialv/ryo.cat/pkg/store"
+	u "github.com/lucialv/ryo.cat/pkg/utils"
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+type StorageUsageResponse struct {
+	Used       int64            `json:"used"`
+	Quota      *int64           `json:"quota"`
+	Remaining  *int64           `json:"remaining"`
+	Categories map[string]int64 `json:"categories"`
+}
+
+type UpdateStorageQuotaRequest struct {
+	Quota *int64 `json:"quota"`
+}
+
+// storageQuota returns how many bytes user may store, or 0 when there is no
+// limit. A quota set on the user wins over the configured default.
+func (s *APIServer) storageQuota(user *store.User) int64 {
+	if user.StorageQuota != nil {
+		return *user.StorageQuota
+	}
+	if user.IsAdmin {
+		return 0
+	}
+	return s.Config.Quota.DefaultBytes
+}
+
+// checkQuota returns errQuotaExceeded if storing size more bytes would take
+// user over their quota. replacing is the key of a file the new one takes the
+// place of, if any; its size is not counted.
+func (s *APIServer) checkQuota(user *store.User, size int64, replacing string) error {
+	quota := s.storageQuota(user)
+	if quota <= 0 {
+		return nil
+	}
+
+	used, err := s.storageUsed(user.ID)
+	if err != nil {
+		return err
+	}
+
+	if replacing != "" {
+		file, err := s.Store.Files.GetByKey(replacing)
+		if err != nil {
+			return fmt.Errorf("failed to look up file: %w", err)
+		}
+		if file != nil && file.UserID == user.ID {
+			used -= file.FileSize
+		}
+	}
+
+	if used+size > quota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+func (s *APIServer) storageUsed(userID string) (int64, error) {
+	usage, err := s.Store.Files.Usage(userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get storage usage: %w", err)
+	}
+	var used int64
+	for _, size := range usage {
+		used += size
+	}
+	return used, nil
+}
+
+// recordFile counts an uploaded object against userID's storage. Failing to
+// record it only skews the usage figures, so it is logged rather than
+// failing an upload that has already been stored.
+func (s *APIServer) recordFile(userID, key, fileName, contentType string, size int64, category string) {
+	if err := s.Store.Files.Save(store.NewFile(userID, key, fileName, contentType, size, category)); err != nil {
+		log.Printf("failed to record file %s: %v", key, err)
+	}
+}
+
+func (s *APIServer) forgetFile(key string) {
+	if err := s.Store.Files.Delete(key); err != nil {
+		log.Printf("failed to delete file record %s: %v", key, err)
+	}
+}
+
+func quotaError(w http.ResponseWriter, err error) error {
+	if errors.Is(err, errQuotaExceeded) {
+		return u.WriteJSON(w, http.StatusRequestEntityTooLarge, ApiError{Error: err.Error()})
+	}
+	return err
+}
+
+func (s *APIServer) getStorageUsageHandler(w http.ResponseWriter, r *http.Request) error {
+	user := r.Context().Value(userCtx).(*store.User)
+
+	usage, err := s.Store.Files.Usage(user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get storage usage: %w", err)
+	}
+
+	response := StorageUsageResponse{
+		Categories: map[string]int64{
+			store.FileCategoryUpload:         0,
+			store.FileCategoryPostMedia:      0,
+			store.FileCategoryProfilePicture: 0,
+		},
+	}
+	for category, size := range usage {
+		response.Categories[category] = size
+		response.Used += size
+	}
+
+	if quota := s.storageQuota(user); quota > 0 {
+		remaining := max(quota-response.Used, 0)
+		response.Quota = &quota
+		response.Remaining = &remaining
+	}
+
+	return u.WriteJSON(w, http.StatusOK, response)
+}
+
+// updateStorageQuotaHandler sets a user's quota in bytes, overriding the
+// default. 0 removes the limit and null goes back to the default.
+func (s *APIServer) updateStorageQuotaHandler(w http.ResponseWriter, r *http.Request) error {
+	userID := chi.URLParam(r, "userId")
+	if userID == "" {
+		return fmt.Errorf("user ID is required")
+	}
+
+	var req UpdateStorageQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+	if req.Quota != nil && *req.Quota < 0 {
+		return fmt.Errorf("quota cannot be negative")
+	}
+
+	user, err := s.Store.Users.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+
+	if err := s.Store.Users.SetStorageQuota(userID, req.Quota); err != nil {
+		return fmt.Errorf("failed to update storage quota: %w", err)
+	}
+
+	return u.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"userId": userID,
+		"quota":  req.Quota,
+	})
+}
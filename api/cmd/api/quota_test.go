@@ -0,0 +1,239 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lucialv/ryo.cat/cmd/api"
+	"github.com/lucialv/ryo.cat/internal/apitest"
+	"github.com/lucialv/ryo.cat/pkg/gc"
+	"github.com/lucialv/ryo.cat/pkg/store"
+)
+
+func presignUpload(t *testing.T, h *apitest.Harness, user *store.User, size int64, want int) string {
+	t.Helper()
+
+	body, _ := json.Marshal(api.PreSignedUploadRequest{Filename: "a.txt", ContentType: "text/plain", Size: size})
+	var res api.PreSignedPostResponse
+	if want != http.StatusOK {
+		decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, "/files/presigned-url/upload", bytes.NewReader(body), user)), want, nil)
+		return ""
+	}
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, "/files/presigned-url/upload", bytes.NewReader(body), user)), want, &res)
+	return res.Key
+}
+
+func storageUsed(t *testing.T, h *apitest.Harness, user *store.User) int64 {
+	t.Helper()
+
+	var res api.StorageUsageResponse
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodGet, "/profile/storage", nil, user)), http.StatusOK, &res)
+	return res.Used
+}
+
+func TestStorageQuota(t *testing.T) {
+	h := apitest.New(t)
+	h.API.Config.Quota.DefaultBytes = 1000
+	admin := h.CreateUser(t, "admin", true)
+	user := h.CreateUser(t, "user", false)
+
+	// each step runs against the usage left by the ones before it
+	steps := []struct {
+		name   string
+		user   *store.User
+		upload int64
+		signed int64
+		want   int
+		used   int64
+	}{
+		{"upload within quota", user, 600, 0, http.StatusCreated, 600},
+		{"pre-signed upload within quota", user, 0, 300, http.StatusOK, 900},
+		{"pre-signed upload over quota", user, 0, 200, http.StatusRequestEntityTooLarge, 900},
+		{"upload over quota", user, 200, 0, http.StatusRequestEntityTooLarge, 900},
+		{"pre-signed upload without a size", user, 0, 0, http.StatusBadRequest, 900},
+		{"admin has no quota", admin, 0, 10000, http.StatusOK, 10000},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if step.upload > 0 {
+				req := uploadRequest(t, h, step.user, "a.txt", bytes.Repeat([]byte("x"), int(step.upload)))
+				decode(t, h.Do(t, req), step.want, nil)
+			} else {
+				presignUpload(t, h, step.user, step.signed, step.want)
+			}
+
+			if used := storageUsed(t, h, step.user); used != step.used {
+				t.Fatalf("using %d bytes, want %d", used, step.used)
+			}
+		})
+	}
+}
+
+func TestStorageQuotaOverride(t *testing.T) {
+	h := apitest.New(t)
+	h.API.Config.Quota.DefaultBytes = 1000
+	admin := h.CreateUser(t, "admin", true)
+	user := h.CreateUser(t, "user", false)
+
+	setQuota := func(t *testing.T, target *store.User, quota *int64, want int) {
+		t.Helper()
+		body, _ := json.Marshal(api.UpdateStorageQuotaRequest{Quota: quota})
+		path := "/admin/users/" + target.ID + "/storage-quota"
+		decode(t, h.Do(t, h.NewRequest(t, http.MethodPut, path, bytes.NewReader(body), admin)), want, nil)
+	}
+	quota := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name   string
+		target *store.User
+		quota  *int64
+		set    int
+		size   int64
+		want   int
+	}{
+		{"negative", user, quota(-1), http.StatusBadRequest, 200, http.StatusOK},
+		{"lower than the default", user, quota(100), http.StatusOK, 200, http.StatusRequestEntityTooLarge},
+		{"higher than the default", user, quota(5000), http.StatusOK, 2000, http.StatusOK},
+		{"cleared back to the default", user, nil, http.StatusOK, 2000, http.StatusRequestEntityTooLarge},
+		{"set on an admin", admin, quota(100), http.StatusOK, 200, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setQuota(t, tt.target, tt.quota, tt.set)
+			presignUpload(t, h, tt.target, tt.size, tt.want)
+		})
+	}
+
+	// only admins may change quotas
+	body, _ := json.Marshal(api.UpdateStorageQuotaRequest{})
+	res := h.Do(t, h.NewRequest(t, http.MethodPut, "/admin/users/"+user.ID+"/storage-quota", bytes.NewReader(body), user))
+	if res.StatusCode == http.StatusOK {
+		t.Fatal("a user changed their own quota")
+	}
+	res.Body.Close()
+}
+
+func TestPendingFilesAreSettled(t *testing.T) {
+	h := apitest.New(t)
+	user := h.CreateUser(t, "user", false)
+
+	uploaded := presignUpload(t, h, user, 50, http.StatusOK)
+	abandoned := presignUpload(t, h, user, 50, http.StatusOK)
+	if used := storageUsed(t, h, user); used != 100 {
+		t.Fatalf("using %d bytes before the policies expire, want the declared 100", used)
+	}
+
+	// the client only used one policy, for a smaller file than it declared
+	if err := h.Blobs.UploadFile(uploaded, []byte("0123456789"), "text/plain"); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	for _, key := range []string{uploaded, abandoned} {
+		file, err := h.Store.Files.GetByKey(key)
+		if err != nil || file == nil {
+			t.Fatalf("failed to get file %s: %v", key, err)
+		}
+		file.PendingUntil = &expired
+		if err := h.Store.Files.Save(file); err != nil {
+			t.Fatalf("failed to save file: %v", err)
+		}
+	}
+
+	if _, err := gc.NewCollector(h.Store, h.Blobs, time.Hour).Run(false); err != nil {
+		t.Fatalf("collection failed: %v", err)
+	}
+
+	tests := []struct {
+		key  string
+		size int64
+		gone bool
+	}{
+		{uploaded, 10, false},
+		{abandoned, 0, true},
+	}
+	for _, tt := range tests {
+		file, err := h.Store.Files.GetByKey(tt.key)
+		if err != nil {
+			t.Fatalf("failed to get file %s: %v", tt.key, err)
+		}
+		if gone := file == nil; gone != tt.gone {
+			t.Fatalf("%s: got gone=%v, want %v", tt.key, gone, tt.gone)
+		}
+		if file != nil && (file.FileSize != tt.size || file.PendingUntil != nil) {
+			t.Fatalf("%s: got size %d pending until %v, want size %d and settled", tt.key, file.FileSize, file.PendingUntil, tt.size)
+		}
+	}
+	if used := storageUsed(t, h, user); used != 10 {
+		t.Fatalf("using %d bytes, want 10", used)
+	}
+}
+
+func TestDeleteNeverUploadedFile(t *testing.T) {
+	h := apitest.New(t)
+	user := h.CreateUser(t, "user", false)
+
+	single := presignUpload(t, h, user, 50, http.StatusOK)
+	batched := presignUpload(t, h, user, 50, http.StatusOK)
+
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodDelete, filePath(single), nil, user)), http.StatusOK, nil)
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodDelete, filePath(single), nil, user)), http.StatusBadRequest, nil)
+
+	body, _ := json.Marshal(api.BatchDeleteRequest{Keys: []string{batched}})
+	var res api.BatchResponse
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, "/files/batch/delete", bytes.NewReader(body), user)), http.StatusOK, &res)
+	if res.Succeeded != 1 {
+		t.Fatalf("batch delete failed: %+v", res.Results)
+	}
+
+	if used := storageUsed(t, h, user); used != 0 {
+		t.Fatalf("using %d bytes after deleting both, want 0", used)
+	}
+	if !strings.HasPrefix(single, "uploads/"+user.ID+"/") {
+		t.Fatalf("pre-signed key %q is outside the user's prefix", single)
+	}
+}
+
+func TestCopyIsChargedToOwner(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		succeeded int
+		used      int64
+	}{
+		{"fits in the owner's quota", 400, 1, 800},
+		{"over the owner's quota", 600, 0, 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := apitest.New(t)
+			h.API.Config.Quota.DefaultBytes = 1000
+			admin := h.CreateUser(t, "admin", true)
+			user := h.CreateUser(t, "user", false)
+
+			// the admin has no quota, but the copy belongs to the user
+			key := uploadFile(t, h, user, "a.txt", bytes.Repeat([]byte("x"), tt.size))
+			body, _ := json.Marshal(api.BatchTransferRequest{Files: []api.FileTransfer{
+				{Source: key, Destination: "uploads/" + user.ID + "/copy.txt"},
+			}})
+			var res api.BatchResponse
+			decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, "/files/batch/copy", bytes.NewReader(body), admin)), http.StatusOK, &res)
+			if res.Succeeded != tt.succeeded {
+				t.Fatalf("got results %+v, want %d copied", res.Results, tt.succeeded)
+			}
+
+			if used := storageUsed(t, h, user); used != tt.used {
+				t.Fatalf("user is using %d bytes, want %d", used, tt.used)
+			}
+			if used := storageUsed(t, h, admin); used != 0 {
+				t.Fatalf("admin is using %d bytes, want 0", used)
+			}
+		})
+	}
+}
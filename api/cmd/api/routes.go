@@ -1,9 +1,11 @@
 package api
 
 import (
+	"errors"
 	"log"
 	"net/http"
 
+	"github.com/lucialv/ryo.cat/pkg/storage"
 	u "github.com/lucialv/ryo.cat/pkg/utils"
 
 	"github.com/go-chi/chi/v5"
@@ -19,6 +21,11 @@ func makeHTTPHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
 			log.Printf("Error occurred: %v", err)
+			// a storage outage isn't the client's fault and is worth retrying
+			if errors.Is(err, storage.ErrUnavailable) {
+				u.WriteJSON(w, http.StatusServiceUnavailable, ApiError{Error: storage.ErrUnavailable.Error()})
+				return
+			}
 			u.WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
 		}
 	}
@@ -40,6 +47,7 @@ func (s *APIServer) Routes() *chi.Mux {
 		r.Group(func(r chi.Router) {
 			r.Use(s.AuthTokenMiddleware)
 			r.Get("/", makeHTTPHandleFunc(s.getUserProfileHandler))
+			r.Get("/storage", makeHTTPHandleFunc(s.getStorageUsageHandler))
 			r.Put("/picture/update", makeHTTPHandleFunc(s.updateProfilePictureHandler))
 			r.Post("/picture/upload", makeHTTPHandleFunc(s.uploadProfilePictureHandler))
 			r.Delete("/picture/delete", makeHTTPHandleFunc(s.deleteProfilePictureHandler))
@@ -52,10 +60,9 @@ func (s *APIServer) Routes() *chi.Mux {
 			r.Get("/", makeHTTPHandleFunc(s.listPostsHandler))
 			r.Get("/{postId}", makeHTTPHandleFunc(s.getPostHandler))
 			r.Get("/user/{userId}", makeHTTPHandleFunc(s.getUserPostsHandler))
+			r.Get("/media/{mediaId}/download", makeHTTPHandleFunc(s.downloadPostMediaHandler))
 		})
 
-		r.Get("/media/{mediaId}/download", makeHTTPHandleFunc(s.downloadPostMediaHandler))
-
 		r.Group(func(r chi.Router) {
 			r.Use(s.AuthTokenMiddleware)
 			r.Post("/{postId}/like", makeHTTPHandleFunc(s.toggleLikeHandler))
@@ -67,6 +74,11 @@ func (s *APIServer) Routes() *chi.Mux {
 			r.Post("/", makeHTTPHandleFunc(s.createPostHandler))
 			r.Delete("/{postId}", makeHTTPHandleFunc(s.deletePostHandler))
 			r.Post("/media/upload", makeHTTPHandleFunc(s.uploadPostMediaHandler))
+			r.Post("/media/uploads", makeHTTPHandleFunc(s.createUploadSessionHandler))
+			r.Post("/media/uploads/{uploadId}/finalize", makeHTTPHandleFunc(s.finalizeUploadSessionHandler))
+			r.Post("/media/multipart", makeHTTPHandleFunc(s.initiateMultipartUploadHandler))
+			r.Post("/media/multipart/{uploadId}/complete", makeHTTPHandleFunc(s.completeMultipartUploadHandler))
+			r.Delete("/media/multipart/{uploadId}", makeHTTPHandleFunc(s.abortMultipartUploadHandler))
 		})
 	})
 
@@ -78,6 +90,9 @@ func (s *APIServer) Routes() *chi.Mux {
 			r.Get("/list", makeHTTPHandleFunc(s.listFilesHandler))
 			r.Post("/presigned-url/download", makeHTTPHandleFunc(s.generatePreSignedURLHandler))
 			r.Post("/presigned-url/upload", makeHTTPHandleFunc(s.generatePreSignedUploadURLHandler))
+			r.Post("/batch/delete", makeHTTPHandleFunc(s.batchDeleteFilesHandler))
+			r.Post("/batch/copy", makeHTTPHandleFunc(s.batchCopyFilesHandler))
+			r.Post("/batch/move", makeHTTPHandleFunc(s.batchMoveFilesHandler))
 			r.Route("/{key:.*}", func(r chi.Router) {
 				r.Get("/", makeHTTPHandleFunc(s.downloadFileHandler))
 				r.Delete("/", makeHTTPHandleFunc(s.deleteFileHandler))
@@ -87,5 +102,25 @@ func (s *APIServer) Routes() *chi.Mux {
 		})
 	})
 
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(s.AuthTokenMiddleware)
+		r.Use(s.adminOnlyMiddleware)
+		r.Post("/gc", makeHTTPHandleFunc(s.runGCHandler))
+		r.Put("/users/{userId}/storage-quota", makeHTTPHandleFunc(s.updateStorageQuotaHandler))
+		r.Get("/trash", makeHTTPHandleFunc(s.listTrashHandler))
+		r.Post("/trash/{trashId}/restore", makeHTTPHandleFunc(s.restoreTrashHandler))
+		r.Get("/trash/posts", makeHTTPHandleFunc(s.listDeletedPostsHandler))
+		r.Post("/trash/posts/{postId}/restore", makeHTTPHandleFunc(s.restorePostHandler))
+	})
+
+	if s.LocalStorage != nil {
+		r.Route("/blobs", func(r chi.Router) {
+			r.Get("/*", makeHTTPHandleFunc(s.downloadLocalBlobHandler))
+			r.Post("/", makeHTTPHandleFunc(s.postLocalBlobHandler))
+			r.Put("/*", makeHTTPHandleFunc(s.uploadLocalBlobPartHandler))
+		})
+		r.Get("/media/*", makeHTTPHandleFunc(s.downloadLocalMediaHandler))
+	}
+
 	return r
 }
@@ -2,17 +2,33 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
-	"strconv"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/lucialv/ryo.cat/pkg/gc"
+	"github.com/lucialv/ryo.cat/pkg/storage"
+	"github.com/lucialv/ryo.cat/pkg/store"
+	"github.com/lucialv/ryo.cat/pkg/trash"
 	u "github.com/lucialv/ryo.cat/pkg/utils"
 )
 
+const userFilesPrefix = "uploads/"
+
+// only 10MB files through the /files API >//<
+const maxFileSize = 10 << 20
+
+// most keys a single batch request may touch
+const maxBatchSize = 100
+
+var errFileAccessDenied = errors.New("you don't have access to this file")
+
 type FileUploadRequest struct {
 	Filename    string            `json:"filename"`
 	ContentType string            `json:"content_type"`
@@ -36,6 +52,21 @@ type PreSignedURLResponse struct {
 	Expiration int64  `json:"expiration"`
 }
 
+type PreSignedUploadRequest struct {
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+	Expiration  int64  `json:"expiration,omitempty"`
+}
+
+type PreSignedPostResponse struct {
+	URL        string            `json:"url"`
+	Fields     map[string]string `json:"fields"`
+	Key        string            `json:"key"`
+	Expiration int64             `json:"expiration"`
+	MaxSize    int64             `json:"max_size"`
+}
+
 type FileInfoResponse struct {
 	Key          string            `json:"key"`
 	Size         int64             `json:"size"`
@@ -45,39 +76,85 @@ type FileInfoResponse struct {
 	Metadata     map[string]string `json:"metadata,omitempty"`
 }
 
+type BatchDeleteRequest struct {
+	Keys []string `json:"keys"`
+}
+
+type FileTransfer struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+type BatchTransferRequest struct {
+	Files []FileTransfer `json:"files"`
+}
+
+type BatchResult struct {
+	Key         string `json:"key"`
+	Destination string `json:"destination,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results   []BatchResult `json:"results"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+}
+
 type ListFilesResponse struct {
 	Files  []string `json:"files"`
 	Prefix string   `json:"prefix,omitempty"`
 }
 
 func (s *APIServer) uploadFileHandler(w http.ResponseWriter, r *http.Request) error {
-	// only 10MB files >//<
-	err := r.ParseMultipartForm(10 << 20)
-	if err != nil {
-		return fmt.Errorf("failed to parse multipart form: %w", err)
+	user := r.Context().Value(userCtx).(*store.User)
+
+	// the body is a little bigger than the file, which errs on the safe side
+	if r.ContentLength > 0 {
+		if err := s.checkQuota(user, r.ContentLength, ""); err != nil {
+			return quotaError(w, err)
+		}
 	}
 
-	file, header, err := r.FormFile("file")
+	file, err := formFileStream(w, r, "file", maxFileSize)
 	if err != nil {
-		return fmt.Errorf("failed to get file from form: %w", err)
+		return err
 	}
 	defer file.Close()
 
-	data, err := io.ReadAll(file)
+	detectedType, body, err := u.SniffContentType(file)
 	if err != nil {
 		return fmt.Errorf("failed to read file data: %w", err)
 	}
 
-	key := fmt.Sprintf("uploads/%d_%s", time.Now().Unix(), header.Filename)
+	fileName := path.Base(file.FileName())
+	key := fmt.Sprintf("%s%d_%s", userFilePrefix(user.ID), time.Now().Unix(), fileName)
 
-	contentType := header.Header.Get("Content-Type")
+	contentType := file.Header.Get("Content-Type")
 	if contentType == "" {
-		contentType = http.DetectContentType(data)
+		contentType = detectedType
 	}
 
-	err = s.R2Storage.UploadFile(key, data, contentType)
+	err = s.BlobStore.UploadStreamWithContext(r.Context(), key, body, contentType, nil)
 	if err != nil {
-		return fmt.Errorf("failed to upload file to R2: %w", err)
+		return fmt.Errorf("failed to upload file to storage: %w", err)
+	}
+
+	var size int64
+	if info, err := s.BlobStore.GetFileInfoWithContext(r.Context(), key); err == nil {
+		size = info.Size
+	}
+
+	// chunked uploads have no Content-Length to check up front
+	if err := s.checkQuota(user, size, ""); err != nil {
+		if delErr := s.BlobStore.DeleteFile(key); delErr != nil {
+			log.Printf("failed to delete upload over quota %s: %v", key, delErr)
+		}
+		return quotaError(w, err)
+	}
+
+	if err := s.Store.Files.Save(store.NewFile(user.ID, key, fileName, contentType, size, store.FileCategoryUpload)); err != nil {
+		return fmt.Errorf("failed to record file: %w", err)
 	}
 
 	response := FileUploadResponse{
@@ -95,23 +172,19 @@ func (s *APIServer) downloadFileHandler(w http.ResponseWriter, r *http.Request)
 
 	key = strings.ReplaceAll(key, "%2F", "/")
 
-	data, err := s.R2Storage.DownloadFile(key)
-	if err != nil {
-		if strings.Contains(err.Error(), "NoSuchKey") {
+	if err := s.authorizeFile(r, key); err != nil {
+		return fileAccessError(w, err)
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if err := serveBlob(w, r, s.BlobStore, key); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
 			return fmt.Errorf("file not found")
 		}
 		return fmt.Errorf("failed to download file: %w", err)
 	}
 
-	info, err := s.R2Storage.GetFileInfo(key)
-	if err == nil && info.ContentType != "" {
-		w.Header().Set("Content-Type", info.ContentType)
-	}
-
-	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
-
 	return nil
 }
 
@@ -123,12 +196,23 @@ func (s *APIServer) deleteFileHandler(w http.ResponseWriter, r *http.Request) er
 
 	key = strings.ReplaceAll(key, "%2F", "/")
 
-	err := s.R2Storage.DeleteFile(key)
-	if err != nil {
-		if strings.Contains(err.Error(), "NoSuchKey") {
+	if err := s.checkWritableKey(r, key); err != nil {
+		return fileAccessError(w, err)
+	}
+
+	user := r.Context().Value(userCtx).(*store.User)
+
+	if _, err := s.Trash.Move(r.Context(), key, store.FileCategoryUpload, user.ID); err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+		forgotten, err := s.forgetMissingFile(key)
+		if err != nil {
+			return err
+		}
+		if !forgotten {
 			return fmt.Errorf("file not found")
 		}
-		return fmt.Errorf("failed to delete file: %w", err)
 	}
 
 	return u.WriteJSON(w, http.StatusOK, map[string]string{"message": "file deleted successfully"})
@@ -142,9 +226,13 @@ func (s *APIServer) getFileInfoHandler(w http.ResponseWriter, r *http.Request) e
 
 	key = strings.ReplaceAll(key, "%2F", "/")
 
-	info, err := s.R2Storage.GetFileInfo(key)
+	if err := s.authorizeFile(r, key); err != nil {
+		return fileAccessError(w, err)
+	}
+
+	info, err := s.BlobStore.GetFileInfoWithContext(r.Context(), key)
 	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") {
+		if errors.Is(err, storage.ErrNotFound) {
 			return fmt.Errorf("file not found")
 		}
 		return fmt.Errorf("failed to get file info: %w", err)
@@ -172,7 +260,13 @@ func (s *APIServer) getFileInfoHandler(w http.ResponseWriter, r *http.Request) e
 func (s *APIServer) listFilesHandler(w http.ResponseWriter, r *http.Request) error {
 	prefix := r.URL.Query().Get("prefix")
 
-	files, err := s.R2Storage.ListFiles(prefix)
+	// everyone but admins only sees their own uploads
+	if user := r.Context().Value(userCtx).(*store.User); !user.IsAdmin {
+		own := userFilePrefix(user.ID)
+		prefix = own + strings.TrimPrefix(strings.TrimLeft(prefix, "/"), own)
+	}
+
+	files, err := s.BlobStore.ListFilesWithContext(r.Context(), prefix)
 	if err != nil {
 		return fmt.Errorf("failed to list files: %w", err)
 	}
@@ -195,11 +289,15 @@ func (s *APIServer) generatePreSignedURLHandler(w http.ResponseWriter, r *http.R
 		return fmt.Errorf("file key is required")
 	}
 
+	if err := s.authorizeFile(r, req.Key); err != nil {
+		return fileAccessError(w, err)
+	}
+
 	if req.Expiration == 0 {
 		req.Expiration = 3600
 	}
 
-	url, err := s.R2Storage.GeneratePreSignedURL(req.Key, req.Expiration)
+	url, err := s.BlobStore.GeneratePreSignedURL(req.Key, req.Expiration)
 	if err != nil {
 		return fmt.Errorf("failed to generate pre-signed URL: %w", err)
 	}
@@ -213,33 +311,76 @@ func (s *APIServer) generatePreSignedURLHandler(w http.ResponseWriter, r *http.R
 	return u.WriteJSON(w, http.StatusOK, response)
 }
 
+// generatePreSignedUploadURLHandler hands out a POST policy for a single
+// file. The key is chosen here and the policy pins it, along with the content
+// type and declared size, so the bucket refuses anything else.
 func (s *APIServer) generatePreSignedUploadURLHandler(w http.ResponseWriter, r *http.Request) error {
-	var req PreSignedURLRequest
+	var req PreSignedUploadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return fmt.Errorf("failed to decode request body: %w", err)
 	}
 
-	if req.Key == "" {
-		return fmt.Errorf("file key is required")
-	}
-
 	if req.ContentType == "" {
 		req.ContentType = "application/octet-stream"
 	}
+	if !allowedUploadType(req.ContentType) {
+		return fmt.Errorf("content type %s is not allowed", req.ContentType)
+	}
+
+	if req.Size <= 0 {
+		return fmt.Errorf("file size is required")
+	}
+	if req.Size > maxFileSize {
+		return u.WriteJSON(w, http.StatusRequestEntityTooLarge, ApiError{Error: fmt.Sprintf("files can be at most %d bytes", maxFileSize)})
+	}
 
 	if req.Expiration == 0 {
 		req.Expiration = 3600
 	}
 
-	url, err := s.R2Storage.GeneratePreSignedPutURL(req.Key, req.ContentType, req.Expiration)
+	user := r.Context().Value(userCtx).(*store.User)
+
+	if err := s.checkQuota(user, req.Size, ""); err != nil {
+		return quotaError(w, err)
+	}
+
+	uuid, err := uuid.NewV4()
 	if err != nil {
-		return fmt.Errorf("failed to generate pre-signed upload URL: %w", err)
+		return fmt.Errorf("failed to create a new uuid")
 	}
+	key := fmt.Sprintf("%s%s%s", userFilePrefix(user.ID), uuid, u.ConvertFileType(req.ContentType))
 
-	response := PreSignedURLResponse{
-		URL:        url,
-		Key:        req.Key,
+	fileName := path.Base(req.Filename)
+	if req.Filename == "" {
+		fileName = path.Base(key)
+	}
+
+	post, err := s.BlobStore.GeneratePreSignedPost(storage.PostConditions{
+		Key:         key,
+		ContentType: req.ContentType,
+		MinSize:     1,
+		MaxSize:     req.Size,
+	}, req.Expiration)
+	if err != nil {
+		return fmt.Errorf("failed to generate pre-signed upload: %w", err)
+	}
+
+	// the declared size is what counts against the quota, since the object
+	// isn't uploaded until the client uses the policy; the collector settles
+	// the row once the policy has expired
+	file := store.NewFile(user.ID, key, fileName, req.ContentType, req.Size, store.FileCategoryUpload)
+	pendingUntil := file.CreatedAt.Add(time.Duration(req.Expiration) * time.Second)
+	file.PendingUntil = &pendingUntil
+	if err := s.Store.Files.Save(file); err != nil {
+		return fmt.Errorf("failed to record file: %w", err)
+	}
+
+	response := PreSignedPostResponse{
+		URL:        post.URL,
+		Fields:     post.Fields,
+		Key:        key,
 		Expiration: req.Expiration,
+		MaxSize:    req.Size,
 	}
 
 	return u.WriteJSON(w, http.StatusOK, response)
@@ -253,10 +394,295 @@ func (s *APIServer) fileExistsHandler(w http.ResponseWriter, r *http.Request) er
 
 	key = strings.ReplaceAll(key, "%2F", "/")
 
-	exists, err := s.R2Storage.FileExists(key)
+	if err := s.authorizeFile(r, key); err != nil {
+		return fileAccessError(w, err)
+	}
+
+	exists, err := s.BlobStore.FileExistsWithContext(r.Context(), key)
 	if err != nil {
 		return fmt.Errorf("failed to check if file exists: %w", err)
 	}
 
 	return u.WriteJSON(w, http.StatusOK, map[string]bool{"exists": exists})
 }
+
+// batchDeleteFilesHandler moves every key to the trash, deleting the
+// originals with a single batch request. Each key gets its own result.
+func (s *APIServer) batchDeleteFilesHandler(w http.ResponseWriter, r *http.Request) error {
+	var req BatchDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+	if len(req.Keys) == 0 {
+		return fmt.Errorf("at least one key is required")
+	}
+	if len(req.Keys) > maxBatchSize {
+		return fmt.Errorf("at most %d keys can be deleted at once", maxBatchSize)
+	}
+
+	user := r.Context().Value(userCtx).(*store.User)
+
+	results := make([]BatchResult, len(req.Keys))
+	var allowed []string
+	for i, key := range req.Keys {
+		results[i].Key = key
+		if err := s.checkWritableKey(r, key); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		allowed = append(allowed, key)
+	}
+
+	failed := s.Trash.MoveAll(r.Context(), allowed, store.FileCategoryUpload, user.ID)
+	for i := range results {
+		err := failed[results[i].Key]
+		if errors.Is(err, storage.ErrNotFound) {
+			if forgotten, forgetErr := s.forgetMissingFile(results[i].Key); forgetErr != nil {
+				err = forgetErr
+			} else if forgotten {
+				err = nil
+			}
+		}
+		if err != nil {
+			results[i].Error = batchError(err)
+		}
+	}
+
+	return u.WriteJSON(w, http.StatusOK, newBatchResponse(results))
+}
+
+func (s *APIServer) batchCopyFilesHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.batchTransferFiles(w, r, false)
+}
+
+func (s *APIServer) batchMoveFilesHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.batchTransferFiles(w, r, true)
+}
+
+// batchTransferFiles copies or moves objects within the bucket without
+// downloading them. Destinations that already exist are refused rather than
+// overwritten.
+func (s *APIServer) batchTransferFiles(w http.ResponseWriter, r *http.Request, move bool) error {
+	var req BatchTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+	if len(req.Files) == 0 {
+		return fmt.Errorf("at least one file is required")
+	}
+	if len(req.Files) > maxBatchSize {
+		return fmt.Errorf("at most %d files can be transferred at once", maxBatchSize)
+	}
+
+	results := make([]BatchResult, len(req.Files))
+	for i, file := range req.Files {
+		results[i].Key = file.Source
+		results[i].Destination = file.Destination
+		if err := s.transferFile(r, file.Source, file.Destination, move); err != nil {
+			results[i].Error = batchError(err)
+		}
+	}
+
+	return u.WriteJSON(w, http.StatusOK, newBatchResponse(results))
+}
+
+func (s *APIServer) transferFile(r *http.Request, src, dst string, move bool) error {
+	if move {
+		if err := s.checkWritableKey(r, src); err != nil {
+			return err
+		}
+	} else {
+		if err := validFileKey(src); err != nil {
+			return err
+		}
+		if err := s.authorizeFile(r, src); err != nil {
+			return err
+		}
+	}
+	if err := s.checkWritableKey(r, dst); err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+	if src == dst {
+		return fmt.Errorf("source and destination are the same")
+	}
+
+	ctx := r.Context()
+
+	exists, err := s.BlobStore.FileExistsWithContext(ctx, dst)
+	if err != nil {
+		return fmt.Errorf("failed to check destination: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("destination already exists")
+	}
+
+	info, err := s.BlobStore.GetFileInfoWithContext(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	file, err := s.Store.Files.GetByKey(src)
+	if err != nil {
+		return fmt.Errorf("failed to look up file: %w", err)
+	}
+
+	// copies and moves keep the owner, and stay out of other users' prefixes
+	// because of the destination check
+	user := r.Context().Value(userCtx).(*store.User)
+	owner, fileName, category := user, path.Base(dst), store.FileCategoryUpload
+	if file != nil {
+		fileName, category = file.FileName, file.Category
+		if file.UserID != user.ID {
+			owner, err = s.Store.Users.GetByID(file.UserID)
+			if err != nil {
+				return fmt.Errorf("failed to get file owner: %w", err)
+			}
+			if owner == nil {
+				return fmt.Errorf("file owner not found")
+			}
+		}
+	}
+
+	// a copy is charged to the owner, so it has to fit in their quota
+	if !move {
+		if err := s.checkQuota(owner, info.Size, ""); err != nil {
+			return err
+		}
+	}
+
+	if move {
+		err = s.BlobStore.MoveFileWithContext(ctx, src, dst)
+	} else {
+		err = s.BlobStore.CopyFileWithContext(ctx, src, dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	if move {
+		s.forgetFile(src)
+	}
+	s.recordFile(owner.ID, dst, fileName, info.ContentType, info.Size, category)
+
+	return nil
+}
+
+// checkWritableKey reports whether the current user may delete key, or write
+// to it when it is a destination. Objects under the prefixes the posts and
+// profile endpoints manage are refused, even for admins, since rows point at
+// them by key.
+func (s *APIServer) checkWritableKey(r *http.Request, key string) error {
+	if err := validFileKey(key); err != nil {
+		return err
+	}
+	for _, prefix := range append([]string{trash.Prefix}, gc.Prefixes...) {
+		if strings.HasPrefix(key, prefix) {
+			return fmt.Errorf("files under %s can't be changed through the files API", prefix)
+		}
+	}
+	return s.authorizeFile(r, key)
+}
+
+// forgetMissingFile drops the record of key, whose object is gone, and
+// reports whether there was one. A pre-signed upload that was never used
+// leaves such a record behind, and deleting it should still free the quota.
+func (s *APIServer) forgetMissingFile(key string) (bool, error) {
+	file, err := s.Store.Files.GetByKey(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up file: %w", err)
+	}
+	if file == nil {
+		return false, nil
+	}
+	if err := s.Store.Files.Delete(key); err != nil {
+		return false, fmt.Errorf("failed to delete file record: %w", err)
+	}
+	return true, nil
+}
+
+func validFileKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("invalid file key: %q", key)
+	}
+	return nil
+}
+
+func batchError(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return "file not found"
+	case errors.Is(err, storage.ErrUnavailable):
+		return storage.ErrUnavailable.Error()
+	}
+	return err.Error()
+}
+
+func newBatchResponse(results []BatchResult) BatchResponse {
+	response := BatchResponse{Results: results}
+	for _, result := range results {
+		if result.Error == "" {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	return response
+}
+
+// userFilePrefix is where the /files API keeps the objects userID uploads.
+func userFilePrefix(userID string) string {
+	return userFilesPrefix + userID + "/"
+}
+
+// allowedUploadType reports whether files of contentType may be uploaded
+// with a pre-signed POST. Anything a browser would render as a page, such as
+// HTML or SVG, is refused since the bucket serves it as uploaded.
+func allowedUploadType(contentType string) bool {
+	switch {
+	case contentType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(contentType, "image/"),
+		strings.HasPrefix(contentType, "video/"),
+		strings.HasPrefix(contentType, "audio/"):
+		return true
+	}
+
+	switch contentType {
+	case "text/plain", "text/csv", "application/pdf", "application/zip", "application/json", "application/octet-stream":
+		return true
+	}
+	return false
+}
+
+// authorizeFile checks that the current user may access key. Admins can
+// access anything; everyone else only files they uploaded.
+func (s *APIServer) authorizeFile(r *http.Request, key string) error {
+	user := r.Context().Value(userCtx).(*store.User)
+	if user.IsAdmin {
+		return nil
+	}
+
+	file, err := s.Store.Files.GetByKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to look up file: %w", err)
+	}
+	if file != nil {
+		if file.UserID != user.ID {
+			return errFileAccessDenied
+		}
+		return nil
+	}
+
+	// nothing recorded yet, e.g. asking whether a key is free
+	if !strings.HasPrefix(key, userFilePrefix(user.ID)) {
+		return errFileAccessDenied
+	}
+	return nil
+}
+
+func fileAccessError(w http.ResponseWriter, err error) error {
+	if errors.Is(err, errFileAccessDenied) {
+		return u.WriteJSON(w, http.StatusForbidden, ApiError{Error: err.Error()})
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lucialv/ryo.cat/cmd/api"
+	"github.com/lucialv/ryo.cat/internal/apitest"
+	"github.com/lucialv/ryo.cat/pkg/store"
+)
+
+func uploadRequest(t *testing.T, h *apitest.Harness, user *store.User, name string, data []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("failed to create form: %v", err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := h.NewRequest(t, http.MethodPost, "/files/upload", &body, user)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func uploadFile(t *testing.T, h *apitest.Harness, user *store.User, name string, data []byte) string {
+	t.Helper()
+
+	var res api.FileUploadResponse
+	decode(t, h.Do(t, uploadRequest(t, h, user, name, data)), http.StatusCreated, &res)
+	return res.Key
+}
+
+// filePath is the /files route for key, with its slashes escaped the way
+// clients send them.
+func filePath(key string, suffix ...string) string {
+	return "/files/" + strings.Join(append([]string{strings.ReplaceAll(key, "/", "%2F")}, suffix...), "/")
+}
+
+func TestFileAuthorization(t *testing.T) {
+	h := apitest.New(t)
+	admin := h.CreateUser(t, "admin", true)
+	owner := h.CreateUser(t, "owner", false)
+	other := h.CreateUser(t, "other", false)
+
+	key := uploadFile(t, h, owner, "notes.txt", []byte("my notes"))
+
+	// objects other endpoints manage, which the files API must leave alone
+	for _, managed := range []string{"posts/media/a.png", "private/posts/media/b.png", "profile-pictures/c.png", "trash/d/e.txt"} {
+		if err := h.Blobs.UploadFile(managed, []byte("managed"), "image/png"); err != nil {
+			t.Fatalf("failed to upload %s: %v", managed, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   *store.User
+		want   int
+	}{
+		{"owner downloads", http.MethodGet, filePath(key), owner, http.StatusOK},
+		{"owner reads info", http.MethodGet, filePath(key, "info"), owner, http.StatusOK},
+		{"admin downloads", http.MethodGet, filePath(key), admin, http.StatusOK},
+		{"other user downloads", http.MethodGet, filePath(key), other, http.StatusForbidden},
+		{"other user reads info", http.MethodGet, filePath(key, "info"), other, http.StatusForbidden},
+		{"other user checks existence", http.MethodGet, filePath(key, "exists"), other, http.StatusForbidden},
+		{"other user deletes", http.MethodDelete, filePath(key), other, http.StatusForbidden},
+		{"signed out", http.MethodGet, filePath(key), nil, http.StatusUnauthorized},
+		{"key outside own prefix", http.MethodGet, filePath("uploads/" + other.ID + "/x.txt"), owner, http.StatusForbidden},
+		{"admin deletes post media", http.MethodDelete, filePath("posts/media/a.png"), admin, http.StatusBadRequest},
+		{"admin deletes private post media", http.MethodDelete, filePath("private/posts/media/b.png"), admin, http.StatusBadRequest},
+		{"admin deletes profile picture", http.MethodDelete, filePath("profile-pictures/c.png"), admin, http.StatusBadRequest},
+		{"admin deletes from trash", http.MethodDelete, filePath("trash/d/e.txt"), admin, http.StatusBadRequest},
+		{"owner deletes", http.MethodDelete, filePath(key), owner, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decode(t, h.Do(t, h.NewRequest(t, tt.method, tt.path, nil, tt.user)), tt.want, nil)
+		})
+	}
+
+	for _, managed := range []string{"posts/media/a.png", "private/posts/media/b.png", "profile-pictures/c.png", "trash/d/e.txt"} {
+		if exists, _ := h.Blobs.FileExists(managed); !exists {
+			t.Fatalf("%s was deleted through the files API", managed)
+		}
+	}
+}
+
+func TestBatchDeleteAuthorization(t *testing.T) {
+	h := apitest.New(t)
+	owner := h.CreateUser(t, "owner", false)
+	other := h.CreateUser(t, "other", false)
+
+	own := uploadFile(t, h, owner, "a.txt", []byte("a"))
+	theirs := uploadFile(t, h, other, "b.txt", []byte("b"))
+	if err := h.Blobs.UploadFile("posts/media/c.png", []byte("c"), "image/png"); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	body, _ := json.Marshal(api.BatchDeleteRequest{Keys: []string{own, theirs, "posts/media/c.png", "../etc/passwd"}})
+	var res api.BatchResponse
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, "/files/batch/delete", bytes.NewReader(body), owner)), http.StatusOK, &res)
+
+	tests := []struct {
+		key     string
+		deleted bool
+	}{
+		{own, true},
+		{theirs, false},
+		{"posts/media/c.png", false},
+		{"../etc/passwd", false},
+	}
+
+	for i, tt := range tests {
+		result := res.Results[i]
+		if result.Key != tt.key {
+			t.Fatalf("result %d is for %q, want %q", i, result.Key, tt.key)
+		}
+		if deleted := result.Error == ""; deleted != tt.deleted {
+			t.Fatalf("%s: got error %q, want deleted=%v", tt.key, result.Error, tt.deleted)
+		}
+	}
+	if res.Succeeded != 1 || res.Failed != 3 {
+		t.Fatalf("got %d succeeded and %d failed, want 1 and 3", res.Succeeded, res.Failed)
+	}
+	if exists, _ := h.Blobs.FileExists(theirs); !exists {
+		t.Fatal("another user's file was deleted")
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lucialv/ryo.cat/pkg/store"
+	"github.com/lucialv/ryo.cat/pkg/trash"
+	u "github.com/lucialv/ryo.cat/pkg/utils"
+)
+
+type TrashListResponse struct {
+	Objects []store.TrashedObject `json:"objects"`
+	Page    int                   `json:"page"`
+	Limit   int                   `json:"limit"`
+	HasMore bool                  `json:"hasMore"`
+}
+
+type DeletedPostsResponse struct {
+	Posts   []PostResponse `json:"posts"`
+	Page    int            `json:"page"`
+	Limit   int            `json:"limit"`
+	HasMore bool           `json:"hasMore"`
+}
+
+type RestoredFileResponse struct {
+	FileKey  string `json:"fileKey"`
+	Category string `json:"category"`
+}
+
+// pageParams reads the page and limit query parameters of the admin
+// listings.
+func pageParams(r *http.Request) (page, limit int) {
+	limitStr := r.URL.Query().Get("limit")
+	pageStr := r.URL.Query().Get("page")
+
+	limit = 50
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	page = 1
+	if pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+	return page, limit
+}
+
+func (s *APIServer) listTrashHandler(w http.ResponseWriter, r *http.Request) error {
+	page, limit := pageParams(r)
+
+	objects, err := s.Trash.List(limit+1, (page-1)*limit)
+	if err != nil {
+		return err
+	}
+
+	hasMore := len(objects) > limit
+	if hasMore {
+		objects = objects[:limit]
+	}
+	if objects == nil {
+		objects = []store.TrashedObject{}
+	}
+
+	return u.WriteJSON(w, http.StatusOK, TrashListResponse{
+		Objects: objects,
+		Page:    page,
+		Limit:   limit,
+		HasMore: hasMore,
+	})
+}
+
+// restoreTrashHandler puts a trashed object back where it was and records it
+// again. A profile picture becomes its owner's picture again. Post media is
+// refused: it stays in place while its post is deleted and comes back with
+// the post, so media in the trash belongs to a post that is gone for good,
+// and a restored copy would be referenced by nothing and collected again.
+func (s *APIServer) restoreTrashHandler(w http.ResponseWriter, r *http.Request) error {
+	trashID := chi.URLParam(r, "trashId")
+	if trashID == "" {
+		return fmt.Errorf("trash ID is required")
+	}
+
+	user := r.Context().Value(userCtx).(*store.User)
+
+	trashed, err := s.Store.Trash.GetByID(trashID)
+	if err != nil {
+		return fmt.Errorf("failed to get trashed file: %w", err)
+	}
+	if trashed != nil && trashed.Category == store.FileCategoryPostMedia {
+		return u.WriteJSON(w, http.StatusConflict, ApiError{Error: "post media can only be restored with its post"})
+	}
+
+	obj, err := s.Trash.Restore(r.Context(), trashID)
+	if err != nil {
+		switch {
+		case errors.Is(err, trash.ErrNotFound):
+			return u.WriteJSON(w, http.StatusNotFound, ApiError{Error: err.Error()})
+		case errors.Is(err, trash.ErrConflict):
+			return u.WriteJSON(w, http.StatusConflict, ApiError{Error: err.Error()})
+		}
+		return fmt.Errorf("failed to restore file: %w", err)
+	}
+
+	// files of deleted users are handed to the admin restoring them
+	ownerID := user.ID
+	if obj.OwnerID != nil {
+		ownerID = *obj.OwnerID
+	}
+
+	key := obj.OriginalKey
+	switch obj.Category {
+	case store.FileCategoryProfilePicture:
+		s.recordFile(ownerID, key, obj.FileName, obj.ContentType, obj.FileSize, obj.Category)
+		if obj.OwnerID != nil {
+			if err := s.restoreProfilePicture(r, *obj.OwnerID, key, user.ID); err != nil {
+				return err
+			}
+		}
+	default:
+		s.recordFile(ownerID, key, obj.FileName, obj.ContentType, obj.FileSize, obj.Category)
+	}
+
+	return u.WriteJSON(w, http.StatusOK, RestoredFileResponse{
+		FileKey:  key,
+		Category: obj.Category,
+	})
+}
+
+// restoreProfilePicture makes key the picture of userID. The picture it
+// replaces goes to the trash.
+func (s *APIServer) restoreProfilePicture(r *http.Request, userID, key, restoredBy string) error {
+	owner, err := s.Store.Users.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if owner == nil {
+		return nil
+	}
+
+	if err := s.Store.Users.UpdateProfilePicture(owner.ID, &key); err != nil {
+		return fmt.Errorf("failed to update profile picture: %w", err)
+	}
+
+	if oldKey := uploadedProfilePicture(owner); oldKey != "" && oldKey != key {
+		if _, err := s.Trash.Move(r.Context(), oldKey, store.FileCategoryProfilePicture, restoredBy); err != nil {
+			log.Printf("failed to move profile picture %s to trash: %v", oldKey, err)
+			s.forgetFile(oldKey)
+		}
+	}
+	return nil
+}
+
+// listDeletedPostsHandler lists the deleted posts that haven't been purged
+// yet, most recently deleted first.
+func (s *APIServer) listDeletedPostsHandler(w http.ResponseWriter, r *http.Request) error {
+	page, limit := pageParams(r)
+
+	posts, err := s.Store.Posts.ListDeletedPosts(limit+1, (page-1)*limit)
+	if err != nil {
+		return fmt.Errorf("failed to list deleted posts: %w", err)
+	}
+
+	hasMore := len(posts) > limit
+	if hasMore {
+		posts = posts[:limit]
+	}
+
+	responses := make([]PostResponse, 0, len(posts))
+	for i := range posts {
+		response, err := s.convertPostToResponse(&posts[i])
+		if err != nil {
+			return err
+		}
+		responses = append(responses, response)
+	}
+
+	return u.WriteJSON(w, http.StatusOK, DeletedPostsResponse{
+		Posts:   responses,
+		Page:    page,
+		Limit:   limit,
+		HasMore: hasMore,
+	})
+}
+
+// restorePostHandler brings back a deleted post with its likes and media,
+// which were left in place when it was deleted.
+func (s *APIServer) restorePostHandler(w http.ResponseWriter, r *http.Request) error {
+	postID := chi.URLParam(r, "postId")
+	if postID == "" {
+		return fmt.Errorf("post ID is required")
+	}
+
+	user := r.Context().Value(userCtx).(*store.User)
+
+	restored, err := s.Store.Posts.RestorePost(postID)
+	if err != nil {
+		return fmt.Errorf("failed to restore post: %w", err)
+	}
+	if !restored {
+		return u.WriteJSON(w, http.StatusNotFound, ApiError{Error: "deleted post not found"})
+	}
+
+	post, err := s.Store.Posts.GetPostByIDWithUserContext(postID, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get post: %w", err)
+	}
+	if post == nil {
+		return u.WriteJSON(w, http.StatusNotFound, ApiError{Error: "deleted post not found"})
+	}
+
+	response, err := s.convertPostToResponse(post)
+	if err != nil {
+		return err
+	}
+	return u.WriteJSON(w, http.StatusOK, response)
+}
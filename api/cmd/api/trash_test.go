@@ -0,0 +1,158 @@
+package api_test
+
+import (
+	"context"
+	"image/color"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lucialv/ryo.cat/cmd/api"
+	"github.com/lucialv/ryo.cat/internal/apitest"
+	"github.com/lucialv/ryo.cat/pkg/store"
+)
+
+func listTrash(t *testing.T, h *apitest.Harness, admin *store.User) map[string]store.TrashedObject {
+	t.Helper()
+
+	var res api.TrashListResponse
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodGet, "/admin/trash", nil, admin)), http.StatusOK, &res)
+
+	byCategory := make(map[string]store.TrashedObject)
+	for _, obj := range res.Objects {
+		byCategory[obj.Category] = obj
+	}
+	return byCategory
+}
+
+func TestRestoreTrash(t *testing.T) {
+	h := apitest.New(t)
+	admin := h.CreateUser(t, "admin", true)
+	user := h.CreateUser(t, "user", false)
+
+	// post media trashed before deleted posts could be restored
+	media := uploadPostMedia(t, h, admin, testPNG(t, color.White))
+	if _, err := h.API.Trash.Move(context.Background(), media.FileKey, store.FileCategoryPostMedia, admin.ID); err != nil {
+		t.Fatalf("failed to trash post media: %v", err)
+	}
+
+	key := uploadFile(t, h, user, "notes.txt", []byte("my notes"))
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodDelete, filePath(key), nil, user)), http.StatusOK, nil)
+
+	trashed := listTrash(t, h, admin)
+
+	tests := []struct {
+		name     string
+		id       string
+		want     int
+		restored bool
+	}{
+		{"media of a deleted post", trashed[store.FileCategoryPostMedia].ID, http.StatusConflict, false},
+		{"uploaded file", trashed[store.FileCategoryUpload].ID, http.StatusOK, true},
+		{"already restored", trashed[store.FileCategoryUpload].ID, http.StatusNotFound, false},
+		{"unknown", "no-such-id", http.StatusNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res api.RestoredFileResponse
+			path := "/admin/trash/" + tt.id + "/restore"
+			if !tt.restored {
+				decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, path, nil, admin)), tt.want, nil)
+				return
+			}
+			decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, path, nil, admin)), tt.want, &res)
+			if res.FileKey != key {
+				t.Fatalf("restored %s, want %s", res.FileKey, key)
+			}
+		})
+	}
+
+	// the refused media stays in the trash until it is purged
+	if _, ok := listTrash(t, h, admin)[store.FileCategoryPostMedia]; !ok {
+		t.Fatal("post media left the trash")
+	}
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodGet, filePath(key), nil, user)), http.StatusOK, nil)
+}
+
+func TestRestoreDeletedPost(t *testing.T) {
+	h := apitest.New(t)
+	admin := h.CreateUser(t, "admin", true)
+	user := h.CreateUser(t, "user", false)
+
+	media := uploadPostMedia(t, h, admin, testPNG(t, color.White))
+	var post api.PostResponse
+	decode(t, createPost(t, h, admin, store.VisibilityPublic, media), http.StatusCreated, &post)
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, "/posts/"+post.ID+"/like", nil, user)), http.StatusOK, nil)
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodDelete, "/posts/"+post.ID, nil, admin)), http.StatusOK, nil)
+
+	// hidden everywhere, but its media stays where it was
+	var feed api.PostsListResponse
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodGet, "/posts/", nil, user)), http.StatusOK, &feed)
+	if len(feed.Posts) != 0 {
+		t.Fatalf("feed has %d posts after the delete, want none", len(feed.Posts))
+	}
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, "/posts/"+post.ID+"/like", nil, user)), http.StatusNotFound, nil)
+	if exists, _ := h.Blobs.FileExists(media.FileKey); !exists {
+		t.Fatal("the media of the deleted post was removed")
+	}
+
+	var deleted api.DeletedPostsResponse
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodGet, "/admin/trash/posts", nil, admin)), http.StatusOK, &deleted)
+	if len(deleted.Posts) != 1 || deleted.Posts[0].ID != post.ID || deleted.Posts[0].DeletedAt == nil {
+		t.Fatalf("got deleted posts %+v, want %s", deleted.Posts, post.ID)
+	}
+
+	path := "/admin/trash/posts/" + post.ID + "/restore"
+	var restored api.PostResponse
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, path, nil, admin)), http.StatusOK, &restored)
+	if restored.LikeCount != 1 || len(restored.Media) != 1 || restored.DeletedAt != nil {
+		t.Fatalf("restored %+v, want the post with its like and media", restored)
+	}
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, path, nil, admin)), http.StatusNotFound, nil)
+
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodGet, "/posts/", nil, user)), http.StatusOK, &feed)
+	if len(feed.Posts) != 1 {
+		t.Fatalf("feed has %d posts after the restore, want 1", len(feed.Posts))
+	}
+}
+
+func TestPurgeDeletedPosts(t *testing.T) {
+	h := apitest.New(t)
+	admin := h.CreateUser(t, "admin", true)
+	h.API.Trash.Retention = time.Hour
+
+	shared := uploadPostMedia(t, h, admin, testPNG(t, color.White))
+	own := uploadPostMedia(t, h, admin, testPNG(t, color.Black))
+
+	var deleted, kept api.PostResponse
+	decode(t, createPost(t, h, admin, store.VisibilityPublic, shared, own), http.StatusCreated, &deleted)
+	decode(t, createPost(t, h, admin, store.VisibilityPublic, shared), http.StatusCreated, &kept)
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodDelete, "/posts/"+deleted.ID, nil, admin)), http.StatusOK, nil)
+
+	// nothing has been deleted long enough yet
+	report, err := h.API.Trash.Purge()
+	if err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if report.PostsPurged != 0 {
+		t.Fatalf("purged %d posts within the retention, want none", report.PostsPurged)
+	}
+
+	h.API.Trash.Retention = 0
+	if report, err = h.API.Trash.Purge(); err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if report.PostsPurged != 1 {
+		t.Fatalf("purged %d posts, want 1", report.PostsPurged)
+	}
+
+	if exists, _ := h.Blobs.FileExists(own.FileKey); exists {
+		t.Fatal("media only the purged post used is still stored")
+	}
+	if exists, _ := h.Blobs.FileExists(shared.FileKey); !exists {
+		t.Fatal("media another post uses was removed")
+	}
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, "/admin/trash/posts/"+deleted.ID+"/restore", nil, admin)), http.StatusNotFound, nil)
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodGet, "/posts/"+kept.ID, nil, admin)), http.StatusOK, nil)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"github.com/lucialv/ryo.cat/pkg/media"
+)
+
+// formFileStream returns the named file field of a multipart request as a
+// stream instead of letting ParseMultipartForm buffer the whole upload. The
+// request body is capped at maxBytes.
+func formFileStream(w http.ResponseWriter, r *http.Request, field string, maxBytes int64) (*multipart.Part, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse multipart form: %w", err)
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("failed to get file from form: %w", http.ErrMissingFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse multipart form: %w", err)
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// errInvalidImage is returned by spoolImage when the upload can't be parsed,
+// as opposed to failing to spool it.
+var errInvalidImage = errors.New("invalid image")
+
+// spooledUpload is an upload copied to a temporary file, so it can be hashed
+// and inspected before anything is written to the bucket.
+type spooledUpload struct {
+	*os.File
+	Size   int64
+	SHA256 string
+}
+
+func spoolUpload(r io.Reader) (*spooledUpload, error) {
+	f, err := os.CreateTemp("", "ryo-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to read file data: %w", err)
+	}
+
+	return &spooledUpload{
+		File:   f,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+func (u *spooledUpload) Cleanup() {
+	u.Close()
+	os.Remove(u.Name())
+}
+
+// spoolImage spools an image upload with its EXIF, XMP and IPTC metadata
+// removed, so the hash and size describe what will actually be stored.
+// Formats media.StripMetadata doesn't know are spooled unchanged.
+func spoolImage(r io.Reader, contentType string) (*spooledUpload, error) {
+	raw, err := spoolUpload(r)
+	if err != nil || !media.CanStrip(contentType) {
+		return raw, err
+	}
+	defer raw.Cleanup()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(media.StripMetadata(pw, raw, contentType))
+	}()
+
+	stripped, err := spoolUpload(pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return nil, fmt.Errorf("%w: %w", errInvalidImage, err)
+	}
+	return stripped, nil
+}
@@ -0,0 +1,340 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/lucialv/ryo.cat/pkg/media"
+	"github.com/lucialv/ryo.cat/pkg/storage"
+	"github.com/lucialv/ryo.cat/pkg/store"
+	"github.com/lucialv/ryo.cat/pkg/utils"
+	u "github.com/lucialv/ryo.cat/pkg/utils"
+)
+
+// 50MB limit :c
+const maxPostMediaSize = 50 << 20
+
+const uploadSessionExpiration = 60 * 60
+
+type CreateUploadSessionRequest struct {
+	ContentType string `json:"contentType"`
+	FileSize    int64  `json:"fileSize"`
+}
+
+type UploadSessionResponse struct {
+	UploadID  string            `json:"uploadId"`
+	FileKey   string            `json:"fileKey"`
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// createUploadSessionHandler starts a direct upload of post media. The
+// client POSTs the file to the returned URL and then calls finalize, which
+// is what makes the media usable in a post.
+func (s *APIServer) createUploadSessionHandler(w http.ResponseWriter, r *http.Request) error {
+	var req CreateUploadSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("failed to decode request body: %w", err)
+	}
+
+	if _, err := postMediaType(req.ContentType); err != nil {
+		return err
+	}
+	if req.ContentType == "image/svg+xml" {
+		return fmt.Errorf("unsupported file type: %s. Only images and videos are allowed", req.ContentType)
+	}
+
+	if req.FileSize <= 0 {
+		return fmt.Errorf("file size is required")
+	}
+	if req.FileSize > maxPostMediaSize {
+		return fmt.Errorf("file is too large. Maximum size is %d bytes", maxPostMediaSize)
+	}
+
+	user := r.Context().Value(userCtx).(*store.User)
+	if err := s.checkQuota(user, req.FileSize, ""); err != nil {
+		return quotaError(w, err)
+	}
+
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("failed to create a new uuid")
+	}
+
+	key := fmt.Sprintf("%s%s%s", postMediaKeyPrefix, uuid, utils.ConvertFileType(req.ContentType))
+
+	post, err := s.BlobStore.GeneratePreSignedPost(storage.PostConditions{
+		Key:         key,
+		ContentType: req.ContentType,
+		MinSize:     1,
+		MaxSize:     req.FileSize,
+	}, uploadSessionExpiration)
+	if err != nil {
+		return fmt.Errorf("failed to generate pre-signed upload: %w", err)
+	}
+
+	session := store.NewUploadSession(user.ID, key, req.ContentType, req.FileSize, time.Now().Add(uploadSessionExpiration*time.Second))
+	if err := s.Store.Uploads.Create(session); err != nil {
+		return fmt.Errorf("failed to create upload session: %w", err)
+	}
+
+	// hold the declared size against the quota until finalize records the
+	// real one; if the session expires first the collector settles the row
+	// like that of any other pre-signed upload
+	file := store.NewFile(user.ID, key, path.Base(key), req.ContentType, req.FileSize, store.FileCategoryPostMedia)
+	pendingUntil := session.ExpiresAt.UTC()
+	file.PendingUntil = &pendingUntil
+	if err := s.Store.Files.Save(file); err != nil {
+		return fmt.Errorf("failed to record file: %w", err)
+	}
+
+	response := UploadSessionResponse{
+		UploadID:  session.ID,
+		FileKey:   key,
+		URL:       post.URL,
+		Fields:    post.Fields,
+		ExpiresAt: session.ExpiresAt,
+	}
+
+	return u.WriteJSON(w, http.StatusCreated, response)
+}
+
+// finalizeUploadSessionHandler checks the object a client uploaded for a
+// session and registers it as post media. Finalizing twice returns the same
+// result.
+func (s *APIServer) finalizeUploadSessionHandler(w http.ResponseWriter, r *http.Request) error {
+	uploadID := chi.URLParam(r, "uploadId")
+	if uploadID == "" {
+		return fmt.Errorf("upload ID is required")
+	}
+
+	user := r.Context().Value(userCtx).(*store.User)
+
+	session, err := s.Store.Uploads.GetByID(uploadID)
+	if err != nil {
+		return fmt.Errorf("failed to get upload session: %w", err)
+	}
+	if session == nil || session.UserID != user.ID {
+		return u.WriteJSON(w, http.StatusNotFound, ApiError{Error: "upload not found"})
+	}
+
+	if session.Status == store.UploadFinalized {
+		return s.writeFinalizedUpload(w, session.FileKey)
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return fmt.Errorf("upload session has expired")
+	}
+
+	exists, err := s.BlobStore.FileExistsWithContext(r.Context(), session.FileKey)
+	if err != nil {
+		return fmt.Errorf("failed to check if media file exists: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("file has not been uploaded yet")
+	}
+
+	response, err := s.verifyUploadedMedia(session.FileKey, session.FileSize, session.ContentType)
+	if err != nil {
+		s.forgetFile(session.FileKey)
+		return err
+	}
+
+	finalized, err := s.Store.Uploads.Finalize(session.ID, response.FileKey)
+	if err != nil {
+		return fmt.Errorf("failed to finalize upload session: %w", err)
+	}
+	if !finalized {
+		// finalized by a concurrent request
+		session, err = s.Store.Uploads.GetByID(uploadID)
+		if err != nil || session == nil {
+			return fmt.Errorf("failed to get upload session: %w", err)
+		}
+		return s.writeFinalizedUpload(w, session.FileKey)
+	}
+
+	if response.FileKey != session.FileKey {
+		s.forgetFile(session.FileKey)
+	}
+	s.recordFile(user.ID, response.FileKey, path.Base(response.FileKey), response.MimeType, response.FileSize, store.FileCategoryPostMedia)
+
+	return u.WriteJSON(w, http.StatusOK, response)
+}
+
+func (s *APIServer) writeFinalizedUpload(w http.ResponseWriter, key string) error {
+	obj, err := s.Store.Media.GetByKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to look up media: %w", err)
+	}
+	if obj == nil {
+		return u.WriteJSON(w, http.StatusNotFound, ApiError{Error: "upload not found"})
+	}
+
+	mediaType, err := postMediaType(obj.MimeType)
+	if err != nil {
+		return err
+	}
+
+	return u.WriteJSON(w, http.StatusOK, CreateMediaRequest{
+		FileKey:   obj.FileKey,
+		MediaType: mediaType,
+		MimeType:  obj.MimeType,
+		FileSize:  obj.FileSize,
+	})
+}
+
+// verifyUploadedMedia inspects an object a client put in the bucket without
+// going through the API. Its type is sniffed from the content rather than
+// trusted, videos are probed, and the object is registered for
+// deduplication; if an identical object is already stored the upload is
+// deleted and the existing key returned, and if the existing row outlived
+// its object the upload takes the object's place. Images are re-uploaded
+// without their metadata first. Rejected uploads are deleted.
+// declaredType, if set, is the content type the client said it would upload
+// and has to be the same kind of media.
+func (s *APIServer) verifyUploadedMedia(key string, maxSize int64, declaredType string) (*CreateMediaRequest, error) {
+	body, info, err := s.BlobStore.OpenFile(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploaded media: %w", err)
+	}
+	contentType, replay, err := u.SniffContentType(body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to read uploaded media: %w", err)
+	}
+
+	mediaType, err := postMediaType(contentType)
+	if err == nil && info.Size > maxSize {
+		err = fmt.Errorf("file is too large. Maximum size is %d bytes", maxSize)
+	}
+	if err == nil && declaredType != "" {
+		if declared, _ := postMediaType(declaredType); declared != mediaType {
+			err = fmt.Errorf("uploaded file is %s, not %s", contentType, declaredType)
+		}
+	}
+	var video *media.VideoInfo
+	if err == nil && mediaType == "video" {
+		video, err = probePostVideo(newBlobReaderAt(s.BlobStore, key, info.Size), info.Size)
+		if err == nil {
+			contentType = video.ContentType
+		}
+	}
+	if err != nil {
+		body.Close()
+		if delErr := s.BlobStore.DeleteFile(key); delErr != nil {
+			log.Printf("failed to delete rejected upload %s: %v", key, delErr)
+		}
+		return nil, err
+	}
+
+	// images are stored without their metadata, as they would have been had
+	// they gone through the API, and hashed afterwards so both kinds of
+	// upload deduplicate against each other
+	var stripped *spooledUpload
+	var contentHash string
+	size := info.Size
+	if mediaType == "image" && media.CanStrip(contentType) {
+		stripped, err = spoolImage(replay, contentType)
+		body.Close()
+		if err != nil {
+			if errors.Is(err, errInvalidImage) {
+				if delErr := s.BlobStore.DeleteFile(key); delErr != nil {
+					log.Printf("failed to delete rejected upload %s: %v", key, delErr)
+				}
+			}
+			return nil, err
+		}
+		defer stripped.Cleanup()
+		contentHash, size = stripped.SHA256, stripped.Size
+	} else {
+		hash := sha256.New()
+		_, err = io.Copy(hash, replay)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read uploaded media: %w", err)
+		}
+		contentHash = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	finalKey := key
+	existing, err := s.Store.Media.GetByHash(contentHash, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up media: %w", err)
+	}
+	metadata := map[string]*string{"sha256": &contentHash}
+	if existing != nil {
+		if existing.FileKey != key {
+			exists, err := s.BlobStore.FileExists(existing.FileKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check if media file exists: %w", err)
+			}
+			if exists {
+				if err := s.BlobStore.DeleteFile(key); err != nil {
+					log.Printf("failed to delete duplicate media file %s: %v", key, err)
+				}
+			} else if err := s.replaceMissingMedia(existing.FileKey, key, stripped, contentType, metadata); err != nil {
+				return nil, err
+			}
+		}
+		finalKey = existing.FileKey
+	} else {
+		if stripped != nil {
+			if err := s.BlobStore.UploadStream(key, stripped, contentType, metadata); err != nil {
+				return nil, fmt.Errorf("failed to store stripped image: %w", err)
+			}
+		}
+
+		obj := store.NewMediaObject(key, contentHash, contentType, size)
+		if video != nil {
+			applyVideoInfo(obj, video)
+		}
+
+		finalKey, err = s.registerPostMedia(obj)
+		if err != nil {
+			return nil, err
+		}
+		if finalKey == key && mediaType == "image" {
+			if body, _, err := s.BlobStore.OpenFile(key); err == nil {
+				s.processPostImage(key, body, contentType)
+				body.Close()
+			}
+		}
+	}
+
+	return &CreateMediaRequest{
+		FileKey:   finalKey,
+		MediaType: mediaType,
+		MimeType:  contentType,
+		FileSize:  size,
+	}, nil
+}
+
+// replaceMissingMedia puts the upload at key where a media row that outlived
+// its object says the object is, so the row is usable again. stripped, if
+// set, is what should be stored instead of the upload itself.
+func (s *APIServer) replaceMissingMedia(existingKey, key string, stripped *spooledUpload, contentType string, metadata map[string]*string) error {
+	if stripped == nil {
+		if err := s.BlobStore.MoveFile(key, existingKey); err != nil {
+			return fmt.Errorf("failed to restore media file: %w", err)
+		}
+		return nil
+	}
+
+	if err := s.BlobStore.UploadStream(existingKey, stripped, contentType, metadata); err != nil {
+		return fmt.Errorf("failed to restore media file: %w", err)
+	}
+	if err := s.BlobStore.DeleteFile(key); err != nil {
+		log.Printf("failed to delete duplicate media file %s: %v", key, err)
+	}
+	return nil
+}
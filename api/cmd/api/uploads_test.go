@@ -0,0 +1,182 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lucialv/ryo.cat/cmd/api"
+	"github.com/lucialv/ryo.cat/internal/apitest"
+	"github.com/lucialv/ryo.cat/pkg/gc"
+	"github.com/lucialv/ryo.cat/pkg/store"
+)
+
+// secret stands in for GPS coordinates and the like; it must not survive
+// an upload.
+const secret = "GPS 48.8584N 2.2945E"
+
+// jpegWithComment encodes a small JPEG with secret in a comment segment
+// right after SOI.
+func jpegWithComment(t *testing.T) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, img, nil); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	data := enc.Bytes()
+
+	segment := []byte{0xff, 0xfe, 0, byte(len(secret) + 2)}
+	return append(append(append([]byte{}, data[:2]...), append(segment, secret...)...), data[2:]...)
+}
+
+func startUpload(t *testing.T, h *apitest.Harness, user *store.User, contentType string, size int64) api.UploadSessionResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(api.CreateUploadSessionRequest{ContentType: contentType, FileSize: size})
+	var session api.UploadSessionResponse
+	decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, "/posts/media/uploads", bytes.NewReader(body), user)), http.StatusCreated, &session)
+	return session
+}
+
+func TestFinalizeStripsMetadata(t *testing.T) {
+	h := apitest.New(t)
+	admin := h.CreateUser(t, "admin", true)
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"jpeg with metadata", "image/jpeg", jpegWithComment(t)},
+		{"png without metadata", "image/png", testPNG(t, color.White)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := startUpload(t, h, admin, tt.contentType, int64(len(tt.data)))
+			if err := h.Blobs.UploadFile(session.FileKey, tt.data, tt.contentType); err != nil {
+				t.Fatalf("failed to upload: %v", err)
+			}
+
+			var media api.CreateMediaRequest
+			path := "/posts/media/uploads/" + session.UploadID + "/finalize"
+			decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, path, nil, admin)), http.StatusOK, &media)
+
+			stored, err := h.Blobs.DownloadFile(media.FileKey)
+			if err != nil {
+				t.Fatalf("failed to download: %v", err)
+			}
+			if bytes.Contains(stored, []byte(secret)) {
+				t.Fatal("the stored image still has its metadata")
+			}
+			if media.FileSize != int64(len(stored)) {
+				t.Fatalf("got size %d, want the %d bytes stored", media.FileSize, len(stored))
+			}
+
+			// the same file sent through the API is stripped the same way,
+			// so it deduplicates against the direct upload
+			if form := uploadPostMedia(t, h, admin, tt.data); form.FileKey != media.FileKey {
+				t.Fatalf("form upload stored as %s, want the finalized %s", form.FileKey, media.FileKey)
+			}
+		})
+	}
+}
+
+func TestFinalizeReplacesMissingMedia(t *testing.T) {
+	h := apitest.New(t)
+	admin := h.CreateUser(t, "admin", true)
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"stripped image", "image/jpeg", jpegWithComment(t)},
+		{"image stored as uploaded", "image/png", testPNG(t, color.White)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the media row outlives its object
+			first := uploadPostMedia(t, h, admin, tt.data)
+			if err := h.Blobs.DeleteFile(first.FileKey); err != nil {
+				t.Fatalf("failed to delete: %v", err)
+			}
+
+			session := startUpload(t, h, admin, tt.contentType, int64(len(tt.data)))
+			if err := h.Blobs.UploadFile(session.FileKey, tt.data, tt.contentType); err != nil {
+				t.Fatalf("failed to upload: %v", err)
+			}
+			var media api.CreateMediaRequest
+			path := "/posts/media/uploads/" + session.UploadID + "/finalize"
+			decode(t, h.Do(t, h.NewRequest(t, http.MethodPost, path, nil, admin)), http.StatusOK, &media)
+
+			if media.FileKey != first.FileKey {
+				t.Fatalf("finalized as %s, want the existing %s", media.FileKey, first.FileKey)
+			}
+			if exists, _ := h.Blobs.FileExists(first.FileKey); !exists {
+				t.Fatal("the upload didn't take the missing object's place")
+			}
+			if exists, _ := h.Blobs.FileExists(session.FileKey); exists {
+				t.Fatal("the duplicate upload was kept")
+			}
+
+			decode(t, createPost(t, h, admin, store.VisibilityPublic, media), http.StatusCreated, nil)
+		})
+	}
+}
+
+func TestExpiredUploadSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		uploaded []byte
+		used     int64
+	}{
+		{"never uploaded", nil, 0},
+		{"uploaded but not finalized", []byte("0123456789"), 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := apitest.New(t)
+			admin := h.CreateUser(t, "admin", true)
+
+			session := startUpload(t, h, admin, "image/png", 500)
+			if used := storageUsed(t, h, admin); used != 500 {
+				t.Fatalf("using %d bytes while the session is open, want the declared 500", used)
+			}
+			if tt.uploaded != nil {
+				if err := h.Blobs.UploadFile(session.FileKey, tt.uploaded, "image/png"); err != nil {
+					t.Fatalf("failed to upload: %v", err)
+				}
+			}
+
+			file, err := h.Store.Files.GetByKey(session.FileKey)
+			if err != nil || file == nil {
+				t.Fatalf("failed to get file: %v", err)
+			}
+			expired := time.Now().Add(-time.Minute)
+			file.PendingUntil = &expired
+			if err := h.Store.Files.Save(file); err != nil {
+				t.Fatalf("failed to save file: %v", err)
+			}
+
+			if _, err := gc.NewCollector(h.Store, h.Blobs, time.Hour).Run(false); err != nil {
+				t.Fatalf("collection failed: %v", err)
+			}
+			if used := storageUsed(t, h, admin); used != tt.used {
+				t.Fatalf("using %d bytes after the session expired, want %d", used, tt.used)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lucialv/ryo.cat/pkg/media"
+	"github.com/lucialv/ryo.cat/pkg/storage"
+	"github.com/lucialv/ryo.cat/pkg/store"
+)
+
+const maxVideoDuration = 10 * time.Minute
+
+// probePostVideo reads a video's container headers and rejects anything a
+// browser couldn't play or that runs longer than maxVideoDuration.
+func probePostVideo(r io.ReaderAt, size int64) (*media.VideoInfo, error) {
+	info, err := media.ProbeVideo(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := info.Playable(); err != nil {
+		return nil, err
+	}
+	if info.Duration > maxVideoDuration {
+		return nil, fmt.Errorf("video is too long. Maximum duration is %s", maxVideoDuration)
+	}
+	return info, nil
+}
+
+func applyVideoInfo(obj *store.MediaObject, info *media.VideoInfo) {
+	obj.Width = info.Width
+	obj.Height = info.Height
+	obj.VideoMetadata = store.VideoMetadata{
+		DurationMs: info.Duration.Milliseconds(),
+		VideoCodec: info.VideoCodec,
+		AudioCodec: info.AudioCodec,
+		HasAudio:   info.HasAudio,
+	}
+}
+
+const blobReadBlockSize = 64 << 10
+
+// blobReaderAt reads a stored object through range requests, fetching it in
+// blocks so that walking a container's many small headers doesn't turn into
+// one request per header.
+type blobReaderAt struct {
+	blobs  storage.BlobStore
+	key    string
+	size   int64
+	blocks map[int64][]byte
+}
+
+func newBlobReaderAt(blobs storage.BlobStore, key string, size int64) *blobReaderAt {
+	return &blobReaderAt{
+		blobs:  blobs,
+		key:    key,
+		size:   size,
+		blocks: make(map[int64][]byte),
+	}
+}
+
+func (b *blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= b.size {
+			return n, io.EOF
+		}
+
+		// a large read, like an MP4 moov box, is fetched in one go
+		if len(p)-n > blobReadBlockSize {
+			length := min(int64(len(p)-n), b.size-pos)
+			body, err := b.blobs.OpenFileRange(b.key, pos, length)
+			if err != nil {
+				return n, err
+			}
+			read, err := io.ReadFull(body, p[n:n+int(length)])
+			body.Close()
+			n += read
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		block, err := b.block(pos / blobReadBlockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos%blobReadBlockSize:])
+	}
+	return n, nil
+}
+
+func (b *blobReaderAt) block(index int64) ([]byte, error) {
+	if block, ok := b.blocks[index]; ok {
+		return block, nil
+	}
+
+	start := index * blobReadBlockSize
+	length := min(int64(blobReadBlockSize), b.size-start)
+	body, err := b.blobs.OpenFileRange(b.key, start, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	block := make([]byte, length)
+	if _, err := io.ReadFull(body, block); err != nil {
+		return nil, err
+	}
+
+	if len(b.blocks) >= 64 {
+		clear(b.blocks)
+	}
+	b.blocks[index] = block
+	return block, nil
+}
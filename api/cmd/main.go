@@ -1,10 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
-	"fmt"
 
 	"github.com/lucialv/ryo.cat/cmd/api"
 	"github.com/lucialv/ryo.cat/internal/auth"
@@ -19,12 +20,14 @@ func main() {
 	}
 	addr := env.GetString("ADDR", ":8000")
 	if p := os.Getenv("PORT"); p != "" {
-     	addr = fmt.Sprintf(":%s", p)
+		addr = fmt.Sprintf(":%s", p)
 	}
 
+	apiURL := env.GetString("EXTERNAL_URL", "https://api.ryo.cat")
+
 	cfg := api.Config{
 		Addr:        addr,
-		ApiURL:      env.GetString("EXTERNAL_URL", "https://api.ryo.cat"),
+		ApiURL:      apiURL,
 		FrontendURL: env.GetString("FRONTEND_URL", "https://ryo.cat"),
 		Env:         env.GetString("ENV", "development"),
 		Auth: api.AuthConfig{
@@ -44,6 +47,36 @@ func main() {
 			AccessKeySecret: env.GetString("ACCESS_KEY_SECRET", ""),
 			BucketName:      env.GetString("BUCKET_NAME", ""),
 		},
+		Storage: api.StorageConfig{
+			Driver:      env.GetString("STORAGE_DRIVER", "r2"),
+			LocalDir:    env.GetString("LOCAL_STORAGE_DIR", "./data/storage"),
+			LocalSecret: env.GetString("LOCAL_STORAGE_SECRET", ""),
+
+			Timeout:          env.GetDuration("STORAGE_TIMEOUT", 10*time.Second),
+			TransferTimeout:  env.GetDuration("STORAGE_TRANSFER_TIMEOUT", 5*time.Minute),
+			MaxRetries:       int(env.GetInt64("STORAGE_MAX_RETRIES", 3)),
+			BreakerThreshold: int(env.GetInt64("STORAGE_BREAKER_THRESHOLD", 5)),
+			BreakerCooldown:  env.GetDuration("STORAGE_BREAKER_COOLDOWN", 30*time.Second),
+		},
+		Media: api.MediaConfig{
+			BaseURL:      env.GetString("MEDIA_BASE_URL", "https://cdn.ryo.cat"),
+			PathPrefix:   env.GetString("MEDIA_PATH_PREFIX", ""),
+			LocalBaseURL: env.GetString("MEDIA_LOCAL_BASE_URL", strings.TrimRight(apiURL, "/")+"/api/v1/media"),
+			SignedURLTTL: env.GetDuration("MEDIA_SIGNED_URL_TTL", time.Hour),
+		},
+		Quota: api.QuotaConfig{
+			DefaultBytes: env.GetInt64("STORAGE_QUOTA_BYTES", 1<<30),
+		},
+		GC: api.GCConfig{
+			Enabled:     env.GetBool("GC_ENABLED", true),
+			DryRun:      env.GetBool("GC_DRY_RUN", false),
+			Interval:    env.GetDuration("GC_INTERVAL", 6*time.Hour),
+			GracePeriod: env.GetDuration("GC_GRACE_PERIOD", 24*time.Hour),
+		},
+		Trash: api.TrashConfig{
+			Retention:     env.GetDuration("TRASH_RETENTION", 30*24*time.Hour),
+			PurgeInterval: env.GetDuration("TRASH_PURGE_INTERVAL", time.Hour),
+		},
 	}
 
 	store, err := store.NewUserStore(
@@ -54,14 +87,20 @@ func main() {
 		log.Fatalf("failed to connect to database: %v", err)
 	}
 
-	r2Storage, err := storage.NewR2Storage(storage.R2Config{
-		AccountID:       cfg.R2.AccountID,
-		AccessKeyID:     cfg.R2.AccessKeyID,
-		AccessKeySecret: cfg.R2.AccessKeySecret,
-		BucketName:      cfg.R2.BucketName,
-	})
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(store, os.Args[2:]); err != nil {
+			log.Fatalf("migrate failed: %v", err)
+		}
+		return
+	}
+
+	if err := migrateOnStart(store, env.GetBool("DB_AUTO_MIGRATE", true)); err != nil {
+		log.Fatalf("database schema check failed: %v", err)
+	}
+
+	blobStore, err := newBlobStore(cfg)
 	if err != nil {
-		log.Fatalf("failed to initialize R2 storage: %v", err)
+		log.Fatalf("failed to initialize %s storage: %v", cfg.Storage.Driver, err)
 	}
 
 	jwtAuthenticator := auth.NewJWTAuthenticator(
@@ -71,6 +110,54 @@ func main() {
 		cfg.Auth.Token.Exp,
 	)
 
-	server := api.NewAPIServer(cfg, store, r2Storage, jwtAuthenticator)
+	server := api.NewAPIServer(cfg, store, blobStore, jwtAuthenticator)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backfill-media":
+			if err := server.BackfillMediaDimensions(); err != nil {
+				log.Fatalf("backfill failed: %v", err)
+			}
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+		return
+	}
+
 	server.Run()
 }
+
+func newBlobStore(cfg api.Config) (storage.BlobStore, error) {
+	switch cfg.Storage.Driver {
+	case "r2":
+		r2, err := storage.NewR2Storage(storage.R2Config{
+			AccountID:       cfg.R2.AccountID,
+			AccessKeyID:     cfg.R2.AccessKeyID,
+			AccessKeySecret: cfg.R2.AccessKeySecret,
+			BucketName:      cfg.R2.BucketName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewResilientStore(r2, storage.ResilientConfig{
+			Timeout:          cfg.Storage.Timeout,
+			TransferTimeout:  cfg.Storage.TransferTimeout,
+			MaxRetries:       cfg.Storage.MaxRetries,
+			FailureThreshold: cfg.Storage.BreakerThreshold,
+			Cooldown:         cfg.Storage.BreakerCooldown,
+		}), nil
+	case "local":
+		// signed URLs are the only thing keeping private media private, so
+		// there is no default to fall back on
+		if cfg.Storage.LocalSecret == "" {
+			return nil, fmt.Errorf("LOCAL_STORAGE_SECRET must be set to use local storage")
+		}
+		return storage.NewLocalStorage(storage.LocalConfig{
+			Dir:     cfg.Storage.LocalDir,
+			BaseURL: strings.TrimRight(cfg.ApiURL, "/") + "/api/v1/blobs",
+			Secret:  cfg.Storage.LocalSecret,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+	}
+}
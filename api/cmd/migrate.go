@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	store "github.com/lucialv/ryo.cat/pkg/store"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status | baseline <version>"
+
+// runMigrate handles `migrate <subcommand>`.
+func runMigrate(s *store.Storage, args []string) error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		for _, migration := range applied {
+			log.Printf("applied %04d-%s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			log.Printf("schema is up to date at %04d", m.Latest())
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		reverted, err := m.Down(steps)
+		for _, migration := range reverted {
+			log.Printf("reverted %04d-%s", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			switch {
+			case status.Name == "":
+				state = "applied by a newer build"
+			case status.Modified:
+				state = "applied, changed since"
+			case status.AppliedAt != nil:
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-24s  %s\n", status.Version, status.Name, state)
+		}
+		return m.Check()
+	case "baseline":
+		if len(args) < 2 {
+			return fmt.Errorf(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version: %s", args[1])
+		}
+		if err := m.Baseline(version); err != nil {
+			return err
+		}
+		log.Printf("recorded migrations up to %04d as applied", version)
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+	return nil
+}
+
+// migrateOnStart refuses to start against a schema this build doesn't
+// understand and, if autoMigrate is set, applies pending migrations.
+func migrateOnStart(s *store.Storage, autoMigrate bool) error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+
+	if !autoMigrate {
+		return m.Check()
+	}
+
+	applied, err := m.Up()
+	for _, migration := range applied {
+		log.Printf("applied migration %04d-%s", migration.Version, migration.Name)
+	}
+	return err
+}
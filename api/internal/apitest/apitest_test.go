@@ -0,0 +1,60 @@
+package apitest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/lucialv/ryo.cat/internal/apitest"
+	"github.com/lucialv/ryo.cat/pkg/store"
+)
+
+func TestHarness(t *testing.T) {
+	h := apitest.New(t)
+	admin := h.CreateUser(t, "admin", true)
+	user := h.CreateUser(t, "user", false)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   *store.User
+		want   int
+	}{
+		{"public route signed out", http.MethodGet, "/posts/", nil, http.StatusOK},
+		{"authenticated route signed out", http.MethodGet, "/profile/", nil, http.StatusUnauthorized},
+		{"authenticated route signed in", http.MethodGet, "/profile/", user, http.StatusOK},
+		{"admin route as user", http.MethodGet, "/admin/trash", user, http.StatusForbidden},
+		{"admin route as admin", http.MethodGet, "/admin/trash", admin, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := h.Do(t, h.NewRequest(t, tt.method, tt.path, nil, tt.user))
+			defer res.Body.Close()
+
+			if res.StatusCode != tt.want {
+				t.Fatalf("%s %s: got status %d, want %d", tt.method, tt.path, res.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestHarnessSessionIsTheUser(t *testing.T) {
+	h := apitest.New(t)
+	user := h.CreateUser(t, "someone", false)
+
+	res := h.Do(t, h.NewRequest(t, http.MethodGet, "/profile/", nil, user))
+	defer res.Body.Close()
+
+	var profile struct {
+		ID       string `json:"id"`
+		UserName string `json:"username"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&profile); err != nil {
+		t.Fatalf("failed to decode profile: %v", err)
+	}
+	if profile.ID != user.ID || profile.UserName != user.UserName {
+		t.Fatalf("got profile %+v, want user %s (%s)", profile, user.ID, user.UserName)
+	}
+}
@@ -33,7 +33,7 @@ func NewJWTAuthenticator(secret, aud, iss string, exp time.Duration) *JWTAuthent
 }
 
 func (a *JWTAuthenticator) GenerateToken(jwtClaims JWTClaims) (string, error) {
-	secret := []byte(env.GetString("JWT_SECRET", ""))
+	secret := []byte(a.secret)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":          jwtClaims.Sub,
 		"username":    jwtClaims.UserName,
@@ -4,7 +4,9 @@ import (
 	"encoding/base64"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func check(e error) {
@@ -48,6 +50,45 @@ func GetString(name, fallback string) string {
 	return env
 }
 
+func GetBool(name string, fallback bool) bool {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	b, err := strconv.ParseBool(env)
+	if err != nil {
+		log.Printf("invalid value for %s, using %t: %v", name, fallback, err)
+		return fallback
+	}
+	return b
+}
+
+func GetInt64(name string, fallback int64) int64 {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.ParseInt(env, 10, 64)
+	if err != nil {
+		log.Printf("invalid value for %s, using %d: %v", name, fallback, err)
+		return fallback
+	}
+	return n
+}
+
+func GetDuration(name string, fallback time.Duration) time.Duration {
+	env, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(env)
+	if err != nil {
+		log.Printf("invalid value for %s, using %s: %v", name, fallback, err)
+		return fallback
+	}
+	return d
+}
+
 func GetGoogleCredJSON() []byte {
 	b64 := os.Getenv("GOOGLE_CREDENTIALS")
 	googleCredsJSON, err := base64.StdEncoding.DecodeString(b64)
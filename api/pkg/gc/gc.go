@@ -0,0 +1,230 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lucialv/ryo.cat/pkg/storage"
+	"github.com/lucialv/ryo.cat/pkg/store"
+)
+
+// Prefixes holds the bucket prefixes whose objects only live as long as a
+// database row points at them.
+var Prefixes = []string{"posts/media/", "private/posts/media/", "profile-pictures/"}
+
+// PublicPrefixes are the Prefixes the CDN serves. Media of posts that aren't
+// public lives under private/ and is only reachable through signed URLs.
+var PublicPrefixes = []string{"posts/media/", "profile-pictures/"}
+
+// Collector deletes objects under Prefixes that no post or user references.
+// Uploads land in the bucket before the row that links them is written, so
+// only objects older than GracePeriod are considered.
+type Collector struct {
+	Store       *store.Storage
+	Blobs       storage.BlobStore
+	GracePeriod time.Duration
+
+	// a collection lists the whole prefix, so runs never overlap
+	mu sync.Mutex
+}
+
+type Report struct {
+	DryRun     bool      `json:"dryRun"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Scanned    int       `json:"scanned"`
+	Referenced int       `json:"referenced"`
+	TooRecent  int       `json:"tooRecent"`
+	Orphans    []Orphan  `json:"orphans"`
+	Deleted    int       `json:"deleted"`
+	Failed     int       `json:"failed"`
+}
+
+type Orphan struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	Error        string    `json:"error,omitempty"`
+}
+
+func NewCollector(store *store.Storage, blobs storage.BlobStore, gracePeriod time.Duration) *Collector {
+	return &Collector{
+		Store:       store,
+		Blobs:       blobs,
+		GracePeriod: gracePeriod,
+	}
+}
+
+// Run makes one pass over the bucket. With dryRun set it only reports what
+// it would delete.
+func (c *Collector) Run(dryRun bool) (*Report, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := &Report{
+		DryRun:    dryRun,
+		StartedAt: time.Now().UTC(),
+		Orphans:   []Orphan{},
+	}
+
+	referenced, err := c.referencedKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := report.StartedAt.Add(-c.GracePeriod)
+
+	for _, prefix := range Prefixes {
+		keys, err := c.Blobs.ListFiles(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+
+		for _, key := range keys {
+			report.Scanned++
+			if referenced[key] {
+				report.Referenced++
+				continue
+			}
+
+			info, err := c.Blobs.GetFileInfo(key)
+			if err != nil {
+				// deleted since it was listed
+				continue
+			}
+			if info.LastModified.After(cutoff) {
+				report.TooRecent++
+				continue
+			}
+
+			orphan := Orphan{
+				Key:          key,
+				Size:         info.Size,
+				LastModified: info.LastModified,
+			}
+			if !dryRun {
+				if err := c.remove(key); err != nil {
+					orphan.Error = err.Error()
+					report.Failed++
+				} else {
+					report.Deleted++
+				}
+			}
+			report.Orphans = append(report.Orphans, orphan)
+		}
+	}
+
+	// the objects of abandoned uploads are orphans and collected like any other
+	if !dryRun {
+		if _, err := c.Store.Uploads.DeleteExpired(cutoff); err != nil {
+			log.Printf("failed to delete expired upload sessions: %v", err)
+		}
+		c.settlePendingFiles(report.StartedAt)
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// Start runs a collection every interval until the returned stop function is
+// called.
+func (c *Collector) Start(interval time.Duration, dryRun bool) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report, err := c.Run(dryRun)
+				if err != nil {
+					log.Printf("storage gc failed: %v", err)
+					continue
+				}
+				log.Printf("storage gc: scanned %d objects, %d orphaned, %d deleted, %d failed (dry run: %t)",
+					report.Scanned, len(report.Orphans), report.Deleted, report.Failed, report.DryRun)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// settlePendingFiles gives the files recorded for pre-signed uploads whose
+// policy expired before now the size of the object that was uploaded, and
+// drops those where nothing was.
+func (c *Collector) settlePendingFiles(now time.Time) {
+	files, err := c.Store.Files.ListPending(now)
+	if err != nil {
+		log.Printf("failed to list pending files: %v", err)
+		return
+	}
+
+	for _, file := range files {
+		info, err := c.Blobs.GetFileInfo(file.FileKey)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			err = c.Store.Files.Delete(file.FileKey)
+		case err == nil:
+			err = c.Store.Files.SetUploaded(file.FileKey, info.Size)
+		}
+		if err != nil {
+			log.Printf("failed to settle pending file %s: %v", file.FileKey, err)
+		}
+	}
+}
+
+func (c *Collector) referencedKeys() (map[string]bool, error) {
+	referenced := make(map[string]bool)
+
+	mediaKeys, err := c.Store.Posts.ListMediaFileKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list post media: %w", err)
+	}
+	for _, key := range mediaKeys {
+		referenced[key] = true
+	}
+
+	pictureKeys, err := c.Store.Users.ListProfilePictureKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profile pictures: %w", err)
+	}
+	for _, key := range pictureKeys {
+		referenced[key] = true
+	}
+
+	return referenced, nil
+}
+
+func (c *Collector) remove(key string) error {
+	// drop the dedup row first so a new upload can't be pointed at an object
+	// that is about to disappear
+	if err := c.Store.Media.DeleteUnreferenced(key); err != nil {
+		return fmt.Errorf("failed to delete media record: %w", err)
+	}
+	if obj, err := c.Store.Media.GetByKey(key); err != nil {
+		return fmt.Errorf("failed to look up media record: %w", err)
+	} else if obj != nil {
+		return fmt.Errorf("object was attached to a post during collection")
+	}
+
+	if err := c.Blobs.DeleteFile(key); err != nil {
+		return err
+	}
+	if err := c.Store.Files.Delete(key); err != nil {
+		return fmt.Errorf("failed to delete file record: %w", err)
+	}
+
+	// the variant objects are orphans too and get collected on their own
+	if err := c.Store.Media.DeleteVariants(key); err != nil {
+		return fmt.Errorf("failed to delete variant records: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package media
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurHashSampleSize is the longest side images are shrunk to before
+// hashing. A placeholder only has a handful of components, so sampling the
+// full image would cost a lot for no visible difference.
+const blurHashSampleSize = 64
+
+// ImageInfo describes a decoded image for layout and placeholders.
+type ImageInfo struct {
+	Width    int
+	Height   int
+	BlurHash string
+}
+
+// Analyze returns the dimensions and BlurHash of img. The hash uses four
+// components along the longer side and three along the shorter one.
+func Analyze(img image.Image) (*ImageInfo, error) {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+
+	xComponents, yComponents := 4, 3
+	if b.Dy() > b.Dx() {
+		xComponents, yComponents = 3, 4
+	}
+
+	hash, err := BlurHash(img, xComponents, yComponents)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImageInfo{
+		Width:    b.Dx(),
+		Height:   b.Dy(),
+		BlurHash: hash,
+	}, nil
+}
+
+// BlurHash encodes img following https://github.com/woltapp/blurhash.
+func BlurHash(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", fmt.Errorf("blurhash components must be between 1 and 9")
+	}
+
+	sample := shrink(img, blurHashSampleSize)
+	width, height := sample.Bounds().Dx(), sample.Bounds().Dy()
+
+	// convert once instead of once per component
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := sample.PixOffset(x, y)
+			linear[y*width+x] = [3]float64{
+				sRGBToLinear(sample.Pix[i]),
+				sRGBToLinear(sample.Pix[i+1]),
+				sRGBToLinear(sample.Pix[i+2]),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					p := linear[y*width+x]
+					r += basis * p[0]
+					g += basis * p[1]
+					b += basis * p[2]
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encode83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	hash.WriteString(encode83(encodeDC(dc), 4))
+	for _, f := range ac {
+		hash.WriteString(encode83(encodeAC(f, maxValue), 2))
+	}
+
+	return hash.String(), nil
+}
+
+// shrink returns img as NRGBA, scaled down so its longer side is at most
+// size pixels.
+func shrink(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func encodeDC(c [3]float64) int {
+	return linearToSRGB(c[0])<<16 + linearToSRGB(c[1])<<8 + linearToSRGB(c[2])
+}
+
+func encodeAC(c [3]float64, maxValue float64) int {
+	quant := func(v float64) int {
+		return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+	}
+	return quant(c[0])*19*19 + quant(c[1])*19 + quant(c[2])
+}
+
+func encode83(value, length int) string {
+	var b strings.Builder
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		b.WriteByte(base83Chars[digit])
+	}
+	return b.String()
+}
+
+func sRGBToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	c := math.Max(0, math.Min(1, v))
+	if c <= 0.0031308 {
+		return int(c*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(c, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,152 @@
+package media
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func solidImage(w, h int, c color.Color) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestBlurHashSolid(t *testing.T) {
+	// Expected hashes come from the reference encoder. Its basis isn't
+	// shifted by half a pixel, so even a flat image has some AC energy unless
+	// it's black.
+	tests := []struct {
+		name string
+		c    color.Color
+		x, y int
+		want string
+	}{
+		{"black", color.Black, 4, 3, "L00000fQfQfQfQfQfQfQfQfQfQfQ"},
+		{"white", color.White, 4, 3, "LfTSUA~qfQ~q~qt7fQt7fQfQfQfQ"},
+		{"red", color.NRGBA{255, 0, 0, 255}, 4, 3, "LfTI:j|cfQ|c|csUfQsUfQfQfQfQ"},
+		{"blue-grey", color.NRGBA{18, 52, 86, 255}, 4, 3, "L327F4pMfQpMpMkDfQkDfQfQfQfQ"},
+		{"portrait components", color.White, 3, 4, "TfTSUA~qfQ~qt7fQfQfQfQ~qt7fQ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BlurHash(solidImage(8, 8, tt.c), tt.x, tt.y)
+			if err != nil {
+				t.Fatalf("BlurHash: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlurHashLength(t *testing.T) {
+	tests := []struct {
+		x, y int
+	}{
+		{1, 1}, {4, 3}, {3, 4}, {9, 9},
+	}
+
+	for _, tt := range tests {
+		got, err := BlurHash(testImage(6, 5), tt.x, tt.y)
+		if err != nil {
+			t.Fatalf("%dx%d: %v", tt.x, tt.y, err)
+		}
+		if want := 6 + 2*(tt.x*tt.y-1); len(got) != want {
+			t.Fatalf("%dx%d: got length %d, want %d", tt.x, tt.y, len(got), want)
+		}
+		if size := encode83((tt.x-1)+(tt.y-1)*9, 1); got[:1] != size {
+			t.Fatalf("%dx%d: got size flag %q, want %q", tt.x, tt.y, got[:1], size)
+		}
+	}
+}
+
+func TestBlurHashInvalidComponents(t *testing.T) {
+	tests := []struct {
+		name string
+		x, y int
+	}{
+		{"zero x", 0, 3},
+		{"zero y", 4, 0},
+		{"x too large", 10, 3},
+		{"y too large", 4, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := BlurHash(testImage(2, 2), tt.x, tt.y); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name          string
+		img           image.Image
+		width, height int
+		size          string
+	}{
+		{"landscape", testImage(6, 4), 6, 4, "L"},
+		{"portrait", testImage(4, 6), 4, 6, "T"},
+		{"square", testImage(5, 5), 5, 5, "L"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Analyze(tt.img)
+			if err != nil {
+				t.Fatalf("Analyze: %v", err)
+			}
+			if info.Width != tt.width || info.Height != tt.height {
+				t.Fatalf("got %dx%d, want %dx%d", info.Width, info.Height, tt.width, tt.height)
+			}
+			if !strings.HasPrefix(info.BlurHash, tt.size) {
+				t.Fatalf("got hash %q, want it to start with %q", info.BlurHash, tt.size)
+			}
+		})
+	}
+
+	if _, err := Analyze(image.NewNRGBA(image.Rect(0, 0, 0, 0))); err == nil {
+		t.Fatal("expected an error for an empty image")
+	}
+}
+
+func TestEncode83(t *testing.T) {
+	tests := []struct {
+		value, length int
+		want          string
+	}{
+		{0, 1, "0"},
+		{82, 1, "~"},
+		{83, 2, "10"},
+		{3429, 2, "fQ"},
+		{0xffffff, 4, "TSUA"},
+	}
+
+	for _, tt := range tests {
+		if got := encode83(tt.value, tt.length); got != tt.want {
+			t.Fatalf("encode83(%d, %d): got %q, want %q", tt.value, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestSRGBRoundTrip(t *testing.T) {
+	for v := 0; v < 256; v++ {
+		if got := linearToSRGB(sRGBToLinear(uint8(v))); got != v {
+			t.Fatalf("%d came back as %d", v, got)
+		}
+	}
+	if l := sRGBToLinear(255); math.Abs(l-1) > 1e-9 {
+		t.Fatalf("white is %v in linear light, want 1", l)
+	}
+}
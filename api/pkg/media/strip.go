@@ -0,0 +1,430 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// rotatedJPEGQuality is used when a JPEG has to be re-encoded to apply its
+// EXIF orientation.
+const rotatedJPEGQuality = 90
+
+var errMalformed = errors.New("malformed image")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// CanStrip reports whether StripMetadata understands contentType.
+func CanStrip(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	}
+	return false
+}
+
+// StripMetadata copies the image in src to dst without its EXIF, XMP and
+// IPTC metadata. Everything else, including the compressed image data and
+// ICC colour profiles, is copied byte for byte. A JPEG or PNG whose EXIF
+// orientation is not the default is decoded, rotated and re-encoded instead,
+// since dropping the tag would otherwise turn the picture on its side.
+func StripMetadata(dst io.Writer, src io.ReadSeeker, contentType string) error {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(dst, src)
+	case "image/png":
+		return stripPNG(dst, src)
+	case "image/webp":
+		return stripWebP(dst, src)
+	}
+	return fmt.Errorf("cannot strip metadata from %s", contentType)
+}
+
+// JPEG markers that carry metadata: APP1 holds EXIF and XMP, APP13 holds
+// Photoshop/IPTC and COM is a free-form comment.
+const (
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerAPP1  = 0xe1
+	markerAPP13 = 0xed
+	markerCOM   = 0xfe
+)
+
+func stripJPEG(dst io.Writer, src io.ReadSeeker) error {
+	orientation := 1
+	err := walkJPEG(src, func(marker byte, payload []byte) error {
+		if marker == markerAPP1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			orientation = exifOrientation(payload[6:])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if orientation > 1 && orientation <= 8 {
+		img, err := Decode(src)
+		if err != nil {
+			return err
+		}
+		return jpeg.Encode(dst, applyOrientation(img, orientation), &jpeg.Options{Quality: rotatedJPEGQuality})
+	}
+
+	w := bufio.NewWriter(dst)
+	if _, err := w.Write([]byte{0xff, 0xd8}); err != nil {
+		return err
+	}
+
+	err = walkJPEG(src, func(marker byte, payload []byte) error {
+		if marker == markerAPP1 || marker == markerAPP13 || marker == markerCOM {
+			return nil
+		}
+		if _, err := w.Write([]byte{0xff, marker}); err != nil {
+			return err
+		}
+		if isStandaloneMarker(marker) {
+			return nil
+		}
+		if marker == markerSOS {
+			// payload is the rest of the file: scan data and trailing markers
+			_, err := w.Write(payload)
+			return err
+		}
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(payload)+2))
+		if _, err := w.Write(length[:]); err != nil {
+			return err
+		}
+		_, err := w.Write(payload)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// walkJPEG calls fn for every marker segment up to and including the start
+// of scan. The SOS payload is everything left in the file.
+func walkJPEG(src io.Reader, fn func(marker byte, payload []byte) error) error {
+	r := bufio.NewReader(src)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return errMalformed
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return errMalformed
+		}
+		if b != 0xff {
+			return errMalformed
+		}
+
+		marker, err := r.ReadByte()
+		for err == nil && marker == 0xff {
+			// fill bytes
+			marker, err = r.ReadByte()
+		}
+		if err != nil {
+			return errMalformed
+		}
+
+		if isStandaloneMarker(marker) {
+			if err := fn(marker, nil); err != nil {
+				return err
+			}
+			if marker == markerEOI {
+				return nil
+			}
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return errMalformed
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if n < 2 {
+			return errMalformed
+		}
+
+		payload := make([]byte, n-2)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return errMalformed
+		}
+
+		if marker == markerSOS {
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			var header [2]byte
+			binary.BigEndian.PutUint16(header[:], uint16(n))
+			return fn(marker, append(append(header[:], payload...), rest...))
+		}
+
+		if err := fn(marker, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// isStandaloneMarker reports whether marker is one of TEM, RSTn or EOI,
+// which carry no length or payload.
+func isStandaloneMarker(marker byte) bool {
+	return marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) || marker == markerEOI
+}
+
+// stripPNG drops the eXIf chunk and the text chunks, which is where XMP and
+// other free-form metadata live.
+func stripPNG(dst io.Writer, src io.ReadSeeker) error {
+	orientation := 1
+	err := walkPNG(src, func(typ string, data []byte) error {
+		if typ == "eXIf" {
+			orientation = exifOrientation(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if orientation > 1 && orientation <= 8 {
+		img, err := Decode(src)
+		if err != nil {
+			return err
+		}
+		return png.Encode(dst, applyOrientation(img, orientation))
+	}
+
+	w := bufio.NewWriter(dst)
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+
+	err = walkPNG(src, func(typ string, data []byte) error {
+		switch typ {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+			return nil
+		}
+		return writePNGChunk(w, typ, data)
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+func walkPNG(src io.Reader, fn func(typ string, data []byte) error) error {
+	r := bufio.NewReader(src)
+
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return errMalformed
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return errMalformed
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:])
+
+		// read through a limit rather than allocating whatever the header claims
+		data, err := io.ReadAll(io.LimitReader(r, length))
+		if err != nil || int64(len(data)) != length {
+			return errMalformed
+		}
+		// the CRC is recomputed when the chunk is written
+		if _, err := r.Discard(4); err != nil {
+			return errMalformed
+		}
+
+		if err := fn(typ, data); err != nil {
+			return err
+		}
+		if typ == "IEND" {
+			return nil
+		}
+	}
+}
+
+func writePNGChunk(w io.Writer, typ string, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+
+	for _, b := range [][]byte{header[:], data, sum[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VP8X flags for the optional metadata chunks.
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebP drops the EXIF and XMP chunks of an extended WebP file. Simple
+// lossy and lossless files have nowhere to put metadata and are copied as
+// they are. WebP decoders don't apply EXIF orientation, so there is nothing
+// to rotate.
+func stripWebP(dst io.Writer, src io.ReadSeeker) error {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return errMalformed
+	}
+
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	for pos := 12; pos < len(data); {
+		if pos+8 > len(data) {
+			return errMalformed
+		}
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		if pos+8+size > len(data) {
+			return errMalformed
+		}
+		end := pos + 8 + size + size%2
+		if end > len(data) {
+			// a missing pad byte on the last chunk is common enough to accept
+			end = len(data)
+		}
+
+		chunk := data[pos:end]
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			if size < 1 {
+				return errMalformed
+			}
+			chunk = append([]byte(nil), chunk...)
+			chunk[8] &^= webpFlagEXIF | webpFlagXMP
+			out = append(out, chunk...)
+		default:
+			out = append(out, chunk...)
+		}
+		pos = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+
+	_, err = dst.Write(out)
+	return err
+}
+
+// exifOrientation reads the orientation tag from a TIFF-structured EXIF
+// block and returns 1, the default, when it is missing or unreadable.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		tag := order.Uint16(tiff[entry : entry+2])
+		typ := order.Uint16(tiff[entry+2 : entry+4])
+		if tag == 0x0112 && typ == 3 {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 1
+}
+
+// applyOrientation returns img turned the way EXIF orientation o says it
+// should be displayed.
+func applyOrientation(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-dx, dy
+			case 3:
+				sx, sy = w-1-dx, h-1-dy
+			case 4:
+				sx, sy = dx, h-1-dy
+			case 5:
+				sx, sy = dy, dx
+			case 6:
+				sx, sy = dy, h-1-dx
+			case 7:
+				sx, sy = w-1-dy, h-1-dx
+			case 8:
+				sx, sy = w-1-dy, dx
+			default:
+				sx, sy = dx, dy
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
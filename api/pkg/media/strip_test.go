@@ -0,0 +1,235 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// secret stands in for GPS coordinates and the like; it must not survive
+// stripping.
+const secret = "GPS 48.8584N 2.2945E"
+
+// tiffWithOrientation builds a little TIFF block with an orientation tag,
+// followed by secret.
+func tiffWithOrientation(order binary.ByteOrder, orientation uint16) []byte {
+	var b bytes.Buffer
+	if order == binary.LittleEndian {
+		b.WriteString("II*\x00")
+	} else {
+		b.WriteString("MM\x00*")
+	}
+	binary.Write(&b, order, uint32(8))
+	binary.Write(&b, order, uint16(1))
+	binary.Write(&b, order, uint16(0x0112))
+	binary.Write(&b, order, uint16(3))
+	binary.Write(&b, order, uint32(1))
+	binary.Write(&b, order, orientation)
+	binary.Write(&b, order, uint16(0))
+	binary.Write(&b, order, uint32(0))
+	b.WriteString(secret)
+	return b.Bytes()
+}
+
+func testImage(w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 40), uint8(y * 40), 100, 255})
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// jpegWithMetadata encodes a w×h JPEG and inserts EXIF, IPTC, a comment and
+// an ICC profile right after SOI.
+func jpegWithMetadata(t *testing.T, w, h int, orientation uint16) []byte {
+	t.Helper()
+
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, testImage(w, h), nil); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	data := enc.Bytes()
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+	out.Write(jpegSegment(markerAPP1, append([]byte("Exif\x00\x00"), tiffWithOrientation(binary.BigEndian, orientation)...)))
+	out.Write(jpegSegment(0xe2, []byte("ICC_PROFILE\x00keep me")))
+	out.Write(jpegSegment(markerAPP13, []byte("Photoshop 3.0\x00"+secret)))
+	out.Write(jpegSegment(markerCOM, []byte(secret)))
+	out.Write(data[2:])
+	return out.Bytes()
+}
+
+// pngWithMetadata encodes a w×h PNG and inserts eXIf and text chunks before
+// the image data.
+func pngWithMetadata(t *testing.T, w, h int, orientation uint16) []byte {
+	t.Helper()
+
+	var enc bytes.Buffer
+	if err := png.Encode(&enc, testImage(w, h)); err != nil {
+		t.Fatalf("failed to encode png: %v", err)
+	}
+	data := enc.Bytes()
+
+	// signature plus the IHDR chunk, which has to come first
+	ihdrEnd := len(pngSignature) + 8 + 13 + 4
+
+	var out bytes.Buffer
+	out.Write(data[:ihdrEnd])
+	writePNGChunk(&out, "eXIf", tiffWithOrientation(binary.LittleEndian, orientation))
+	writePNGChunk(&out, "tEXt", []byte("Comment\x00"+secret))
+	writePNGChunk(&out, "iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+secret))
+	out.Write(data[ihdrEnd:])
+	return out.Bytes()
+}
+
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := append([]byte(fourCC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	out := append([]byte("RIFF"), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)))
+	return append(out, body...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		// dimensions after stripping, zero for formats the test can't decode
+		width, height int
+		keep          string
+	}{
+		{"jpeg", "image/jpeg", jpegWithMetadata(t, 4, 2, 1), 4, 2, "ICC_PROFILE"},
+		{"jpeg rotated", "image/jpeg", jpegWithMetadata(t, 4, 2, 6), 2, 4, ""},
+		{"png", "image/png", pngWithMetadata(t, 4, 2, 1), 4, 2, "IDAT"},
+		{"png rotated", "image/png", pngWithMetadata(t, 4, 2, 8), 2, 4, ""},
+		{"webp", "image/webp", webpFile(
+			webpChunk("VP8X", []byte{webpFlagEXIF | webpFlagXMP, 0, 0, 0, 3, 0, 0, 1, 0, 0}),
+			webpChunk("VP8L", []byte("image data")),
+			webpChunk("EXIF", tiffWithOrientation(binary.LittleEndian, 1)),
+			webpChunk("XMP ", []byte(secret)),
+		), 0, 0, "image data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := StripMetadata(&out, bytes.NewReader(tt.data), tt.contentType); err != nil {
+				t.Fatalf("StripMetadata: %v", err)
+			}
+			stripped := out.Bytes()
+
+			if bytes.Contains(stripped, []byte(secret)) {
+				t.Fatal("metadata survived stripping")
+			}
+			if tt.keep != "" && !bytes.Contains(stripped, []byte(tt.keep)) {
+				t.Fatalf("%q was dropped", tt.keep)
+			}
+
+			if tt.width != 0 {
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(stripped))
+				if err != nil {
+					t.Fatalf("stripped image does not decode: %v", err)
+				}
+				if cfg.Width != tt.width || cfg.Height != tt.height {
+					t.Fatalf("got %dx%d, want %dx%d", cfg.Width, cfg.Height, tt.width, tt.height)
+				}
+			}
+		})
+	}
+}
+
+func TestStripWebPHeader(t *testing.T) {
+	data := webpFile(
+		webpChunk("VP8X", []byte{webpFlagEXIF | webpFlagXMP | 0x10, 0, 0, 0, 3, 0, 0, 1, 0, 0}),
+		webpChunk("VP8L", []byte("image")),
+		webpChunk("EXIF", []byte(secret)),
+	)
+
+	var out bytes.Buffer
+	if err := StripMetadata(&out, bytes.NewReader(data), "image/webp"); err != nil {
+		t.Fatalf("StripMetadata: %v", err)
+	}
+	stripped := out.Bytes()
+
+	if got := int(binary.LittleEndian.Uint32(stripped[4:8])); got != len(stripped)-8 {
+		t.Fatalf("RIFF size is %d, want %d", got, len(stripped)-8)
+	}
+	// the alpha flag stays, the metadata flags go
+	if flags := stripped[20]; flags != 0x10 {
+		t.Fatalf("VP8X flags are %#x, want 0x10", flags)
+	}
+}
+
+func TestStripMetadataMalformed(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"jpeg without SOI", "image/jpeg", []byte("not a jpeg")},
+		{"truncated jpeg segment", "image/jpeg", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x20, 'E'}},
+		{"png without signature", "image/png", []byte("not a png")},
+		{"truncated png chunk", "image/png", append(append([]byte(nil), pngSignature...), 0, 0, 1, 0, 'I', 'D', 'A', 'T')},
+		{"webp without header", "image/webp", []byte("RIFF")},
+		{"webp chunk past end", "image/webp", webpFile([]byte("VP8L\xff\x00\x00\x00"))},
+		{"unsupported type", "image/gif", []byte("GIF89a")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := StripMetadata(&out, bytes.NewReader(tt.data), tt.contentType); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"little endian", tiffWithOrientation(binary.LittleEndian, 6), 6},
+		{"big endian", tiffWithOrientation(binary.BigEndian, 3), 3},
+		{"too short", []byte("II*\x00"), 1},
+		{"not tiff", []byte("GIF89a\x00\x00\x00\x00"), 1},
+		{"IFD past end", []byte("II*\x00\xff\x00\x00\x00"), 1},
+		{"truncated entry", tiffWithOrientation(binary.LittleEndian, 6)[:14], 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.tiff); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
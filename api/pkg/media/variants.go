@@ -0,0 +1,119 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// VariantWidths are the widths resized copies are made at. Images narrower
+// than a width are not scaled up.
+var VariantWidths = []int{320, 640, 1280}
+
+// maxDecodePixels keeps a small file that claims huge dimensions from being
+// decoded into gigabytes of memory.
+const maxDecodePixels = 50_000_000
+
+const variantJPEGQuality = 82
+
+var ErrTooLarge = errors.New("image is too large to process")
+
+type Variant struct {
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// CanResize reports whether variants are made for contentType. Animated GIFs
+// would lose their animation, so GIFs are served as they are.
+func CanResize(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	}
+	return false
+}
+
+// GenerateVariants returns a copy of src scaled to each of VariantWidths
+// that is narrower than the original. JPEGs stay JPEGs, everything else is
+// written as PNG unless it has no transparency.
+func GenerateVariants(src image.Image, contentType string) ([]Variant, error) {
+	if !CanResize(contentType) {
+		return nil, nil
+	}
+
+	bounds := src.Bounds()
+	opaque := isOpaque(src)
+
+	var variants []Variant
+	for _, width := range VariantWidths {
+		if width >= bounds.Dx() {
+			break
+		}
+
+		height := bounds.Dy() * width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+		var buf bytes.Buffer
+		variantType := "image/png"
+		var err error
+		if contentType == "image/jpeg" || opaque {
+			variantType = "image/jpeg"
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: variantJPEGQuality})
+		} else {
+			err = png.Encode(&buf, dst)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %dpx variant: %w", width, err)
+		}
+
+		variants = append(variants, Variant{
+			Width:       width,
+			Height:      height,
+			ContentType: variantType,
+			Data:        buf.Bytes(),
+		})
+	}
+
+	return variants, nil
+}
+
+// Decode reads an image after checking its dimensions against
+// maxDecodePixels.
+func Decode(r io.Reader) (image.Image, error) {
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+	if config.Width*config.Height > maxDecodePixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
@@ -0,0 +1,552 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// maxMoovSize caps how much of an MP4 header is read into memory. Real moov
+// boxes are a few megabytes even for long videos.
+const maxMoovSize = 64 << 20
+
+var ErrUnsupportedVideo = errors.New("unsupported video")
+
+type VideoInfo struct {
+	// ContentType is derived from the container rather than trusted from
+	// the upload: video/mp4, video/quicktime or video/webm.
+	ContentType string
+	Duration    time.Duration
+	Width       int
+	Height      int
+	VideoCodec  string
+	AudioCodec  string
+	HasAudio    bool
+}
+
+// playableVideoCodecs are the codecs browsers can decode, keyed by the
+// sample entry type (MP4) or codec ID (WebM).
+var playableVideoCodecs = map[string]bool{
+	"avc1": true, "avc3": true, "hvc1": true, "hev1": true, "av01": true, "vp09": true,
+	"V_VP8": true, "V_VP9": true, "V_AV1": true, "V_MPEG4/ISO/AVC": true,
+}
+
+var playableAudioCodecs = map[string]bool{
+	"mp4a": true, "Opus": true, "fLaC": true,
+	"A_OPUS": true, "A_VORBIS": true, "A_AAC": true,
+}
+
+// Playable reports why a browser couldn't play the video, or nil if it can.
+func (v *VideoInfo) Playable() error {
+	if v.VideoCodec == "" {
+		return fmt.Errorf("%w: no video track", ErrUnsupportedVideo)
+	}
+	if !playableVideoCodecs[v.VideoCodec] {
+		return fmt.Errorf("%w: video codec %s", ErrUnsupportedVideo, v.VideoCodec)
+	}
+	if v.HasAudio && !playableAudioCodecs[v.AudioCodec] {
+		return fmt.Errorf("%w: audio codec %s", ErrUnsupportedVideo, v.AudioCodec)
+	}
+	return nil
+}
+
+// ProbeVideo reads the container headers of an MP4, QuickTime or WebM file.
+// Only the headers are read; r is accessed at arbitrary offsets because an
+// MP4's moov box is often written after the media data.
+func ProbeVideo(r io.ReaderAt, size int64) (*VideoInfo, error) {
+	var magic [12]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return nil, fmt.Errorf("%w: file is too short", ErrUnsupportedVideo)
+	}
+
+	switch {
+	case string(magic[4:8]) == "ftyp":
+		return probeMP4(r, size)
+	case bytes.Equal(magic[:4], []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return probeWebM(r, size)
+	}
+	return nil, fmt.Errorf("%w: unknown container", ErrUnsupportedVideo)
+}
+
+type mp4Box struct {
+	typ    string
+	offset int64 // of the payload
+	size   int64 // of the payload
+}
+
+// readMP4Box reads the box header at offset, limited to end.
+func readMP4Box(r io.ReaderAt, offset, end int64) (mp4Box, error) {
+	var header [16]byte
+	if offset+8 > end {
+		return mp4Box{}, io.ErrUnexpectedEOF
+	}
+	if _, err := r.ReadAt(header[:8], offset); err != nil {
+		return mp4Box{}, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	box := mp4Box{typ: string(header[4:8]), offset: offset + 8}
+
+	switch size {
+	case 0:
+		// extends to the end of the file
+		size = end - offset
+	case 1:
+		if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+			return mp4Box{}, err
+		}
+		size = int64(binary.BigEndian.Uint64(header[8:16]))
+		box.offset += 8
+	}
+
+	box.size = size - (box.offset - offset)
+	if box.size < 0 || box.offset+box.size > end {
+		return mp4Box{}, fmt.Errorf("%w: box %q overruns its parent", ErrUnsupportedVideo, box.typ)
+	}
+	return box, nil
+}
+
+func probeMP4(r io.ReaderAt, size int64) (*VideoInfo, error) {
+	info := &VideoInfo{ContentType: "video/mp4"}
+
+	var moov *mp4Box
+	for offset := int64(0); offset < size; {
+		box, err := readMP4Box(r, offset, size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedVideo, err)
+		}
+
+		switch box.typ {
+		case "ftyp":
+			var brand [4]byte
+			if box.size >= 4 {
+				if _, err := r.ReadAt(brand[:], box.offset); err == nil && string(brand[:]) == "qt  " {
+					info.ContentType = "video/quicktime"
+				}
+			}
+		case "moov":
+			moov = &box
+		}
+
+		if moov != nil {
+			break
+		}
+		offset = box.offset + box.size
+	}
+
+	if moov == nil {
+		return nil, fmt.Errorf("%w: no moov box", ErrUnsupportedVideo)
+	}
+	if moov.size > maxMoovSize {
+		return nil, fmt.Errorf("%w: moov box is too large", ErrUnsupportedVideo)
+	}
+
+	data := make([]byte, moov.size)
+	if _, err := r.ReadAt(data, moov.offset); err != nil {
+		return nil, fmt.Errorf("failed to read moov box: %w", err)
+	}
+	moovReader := bytes.NewReader(data)
+
+	err := walkMP4(moovReader, 0, moov.size, func(box mp4Box) error {
+		switch box.typ {
+		case "mvhd":
+			payload, err := boxPayload(data, box)
+			if err != nil {
+				return err
+			}
+			info.Duration = mvhdDuration(payload)
+		case "trak":
+			return probeTrak(data, box, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func walkMP4(r io.ReaderAt, offset, end int64, fn func(mp4Box) error) error {
+	for offset < end {
+		box, err := readMP4Box(r, offset, end)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedVideo, err)
+		}
+		if err := fn(box); err != nil {
+			return err
+		}
+		offset = box.offset + box.size
+	}
+	return nil
+}
+
+func boxPayload(data []byte, box mp4Box) ([]byte, error) {
+	if box.offset+box.size > int64(len(data)) {
+		return nil, fmt.Errorf("%w: truncated %s box", ErrUnsupportedVideo, box.typ)
+	}
+	return data[box.offset : box.offset+box.size], nil
+}
+
+func mvhdDuration(p []byte) time.Duration {
+	if len(p) < 1 {
+		return 0
+	}
+
+	var timescale, duration uint64
+	if p[0] == 1 {
+		if len(p) < 32 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(p[20:24]))
+		duration = binary.BigEndian.Uint64(p[24:32])
+	} else {
+		if len(p) < 20 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(p[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(p[16:20]))
+	}
+
+	if timescale == 0 || duration == math.MaxUint64 || duration == math.MaxUint32 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// probeTrak fills in the codec of a video or audio track, and the display
+// size from the video track's tkhd.
+func probeTrak(data []byte, trak mp4Box, info *VideoInfo) error {
+	r := bytes.NewReader(data)
+
+	var handler, codec string
+	var width, height int
+
+	var visit func(box mp4Box) error
+	visit = func(box mp4Box) error {
+		switch box.typ {
+		case "mdia", "minf", "stbl":
+			return walkMP4(r, box.offset, box.offset+box.size, visit)
+		case "tkhd":
+			p, err := boxPayload(data, box)
+			if err != nil {
+				return err
+			}
+			// width and height are the last two 16.16 fixed-point fields
+			if len(p) >= 8 {
+				width = int(binary.BigEndian.Uint32(p[len(p)-8:]) >> 16)
+				height = int(binary.BigEndian.Uint32(p[len(p)-4:]) >> 16)
+			}
+		case "hdlr":
+			p, err := boxPayload(data, box)
+			if err != nil {
+				return err
+			}
+			if len(p) >= 12 {
+				handler = string(p[8:12])
+			}
+		case "stsd":
+			p, err := boxPayload(data, box)
+			if err != nil {
+				return err
+			}
+			// version/flags and entry count, then the first sample entry
+			if len(p) >= 16 {
+				codec = strings.TrimSpace(string(p[12:16]))
+			}
+		}
+		return nil
+	}
+
+	if err := walkMP4(r, trak.offset, trak.offset+trak.size, visit); err != nil {
+		return err
+	}
+
+	switch handler {
+	case "vide":
+		if info.VideoCodec == "" {
+			info.VideoCodec = codec
+			info.Width, info.Height = width, height
+		}
+	case "soun":
+		if !info.HasAudio {
+			info.HasAudio = true
+			info.AudioCodec = codec
+		}
+	}
+	return nil
+}
+
+// EBML element IDs used by WebM.
+const (
+	ebmlDocType       = 0x4282
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549a966
+	ebmlTimecodeScale = 0x2ad7b1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654ae6b
+	ebmlTrackEntry    = 0xae
+	ebmlTrackType     = 0x83
+	ebmlCodecID       = 0x86
+	ebmlVideo         = 0xe0
+	ebmlPixelWidth    = 0xb0
+	ebmlPixelHeight   = 0xba
+	ebmlCluster       = 0x1f43b675
+)
+
+// ebmlUnknownSize marks an element whose size wasn't known when it was
+// written, as live-recorded WebM files do for the segment.
+const ebmlUnknownSize = -1
+
+type ebmlElement struct {
+	id     uint64
+	offset int64 // of the payload
+	size   int64
+}
+
+func readEBMLVarint(r io.ReaderAt, offset int64, keepMarker bool) (uint64, int, error) {
+	var first [1]byte
+	if _, err := r.ReadAt(first[:], offset); err != nil {
+		return 0, 0, err
+	}
+
+	length := 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, fmt.Errorf("%w: invalid EBML varint", ErrUnsupportedVideo)
+	}
+
+	buf := make([]byte, length)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return 0, 0, err
+	}
+	if !keepMarker {
+		buf[0] &= 0xff >> length
+	}
+
+	var v uint64
+	for _, b := range buf {
+		v = v<<8 | uint64(b)
+	}
+	return v, length, nil
+}
+
+func readEBMLElement(r io.ReaderAt, offset int64) (ebmlElement, error) {
+	id, idLen, err := readEBMLVarint(r, offset, true)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+	size, sizeLen, err := readEBMLVarint(r, offset+int64(idLen), false)
+	if err != nil {
+		return ebmlElement{}, err
+	}
+
+	el := ebmlElement{id: id, offset: offset + int64(idLen+sizeLen), size: int64(size)}
+	if size == 1<<(7*sizeLen)-1 {
+		el.size = ebmlUnknownSize
+	}
+	return el, nil
+}
+
+func probeWebM(r io.ReaderAt, size int64) (*VideoInfo, error) {
+	header, err := readEBMLElement(r, 0)
+	if err != nil || header.size == ebmlUnknownSize {
+		return nil, fmt.Errorf("%w: invalid EBML header", ErrUnsupportedVideo)
+	}
+
+	info := &VideoInfo{ContentType: "video/webm"}
+	err = walkEBML(r, header.offset, header.offset+header.size, func(el ebmlElement) (bool, error) {
+		if el.id == ebmlDocType {
+			docType, err := readEBMLString(r, el)
+			if err != nil {
+				return false, err
+			}
+			if docType != "webm" && docType != "matroska" {
+				return false, fmt.Errorf("%w: EBML document type %q", ErrUnsupportedVideo, docType)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	segment, err := readEBMLElement(r, header.offset+header.size)
+	if err != nil || segment.id != ebmlSegment {
+		return nil, fmt.Errorf("%w: no segment", ErrUnsupportedVideo)
+	}
+	segmentEnd := size
+	if segment.size != ebmlUnknownSize && segment.offset+segment.size < size {
+		segmentEnd = segment.offset + segment.size
+	}
+
+	timecodeScale := uint64(1_000_000)
+	var duration float64
+	var haveInfo, haveTracks bool
+
+	err = walkEBML(r, segment.offset, segmentEnd, func(el ebmlElement) (bool, error) {
+		switch el.id {
+		case ebmlInfo:
+			haveInfo = true
+			return true, walkEBML(r, el.offset, el.offset+el.size, func(child ebmlElement) (bool, error) {
+				switch child.id {
+				case ebmlTimecodeScale:
+					v, err := readEBMLUint(r, child)
+					if err != nil {
+						return false, err
+					}
+					timecodeScale = v
+				case ebmlDuration:
+					v, err := readEBMLFloat(r, child)
+					if err != nil {
+						return false, err
+					}
+					duration = v
+				}
+				return true, nil
+			})
+		case ebmlTracks:
+			haveTracks = true
+			return true, walkEBML(r, el.offset, el.offset+el.size, func(child ebmlElement) (bool, error) {
+				if child.id == ebmlTrackEntry {
+					return true, probeWebMTrack(r, child, info)
+				}
+				return true, nil
+			})
+		case ebmlCluster:
+			// media data; everything we need comes before the first cluster
+			return false, nil
+		}
+		return !(haveInfo && haveTracks), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info.Duration = time.Duration(duration * float64(timecodeScale))
+	return info, nil
+}
+
+// walkEBML calls fn for each child element between offset and end until fn
+// returns false. Elements of unknown size can't be skipped, so the walk
+// stops at them too.
+func walkEBML(r io.ReaderAt, offset, end int64, fn func(ebmlElement) (bool, error)) error {
+	for offset < end {
+		el, err := readEBMLElement(r, offset)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedVideo, err)
+		}
+		if el.size != ebmlUnknownSize && el.offset+el.size > end {
+			return fmt.Errorf("%w: element %x overruns its parent", ErrUnsupportedVideo, el.id)
+		}
+
+		more, err := fn(el)
+		if err != nil {
+			return err
+		}
+		if !more || el.size == ebmlUnknownSize {
+			return nil
+		}
+		offset = el.offset + el.size
+	}
+	return nil
+}
+
+func probeWebMTrack(r io.ReaderAt, entry ebmlElement, info *VideoInfo) error {
+	var trackType uint64
+	var codec string
+	var width, height uint64
+
+	err := walkEBML(r, entry.offset, entry.offset+entry.size, func(el ebmlElement) (bool, error) {
+		var err error
+		switch el.id {
+		case ebmlTrackType:
+			trackType, err = readEBMLUint(r, el)
+		case ebmlCodecID:
+			codec, err = readEBMLString(r, el)
+		case ebmlVideo:
+			err = walkEBML(r, el.offset, el.offset+el.size, func(child ebmlElement) (bool, error) {
+				var err error
+				switch child.id {
+				case ebmlPixelWidth:
+					width, err = readEBMLUint(r, child)
+				case ebmlPixelHeight:
+					height, err = readEBMLUint(r, child)
+				}
+				return true, err
+			})
+		}
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+
+	switch trackType {
+	case 1:
+		if info.VideoCodec == "" {
+			info.VideoCodec = codec
+			info.Width, info.Height = int(width), int(height)
+		}
+	case 2:
+		if !info.HasAudio {
+			info.HasAudio = true
+			info.AudioCodec = codec
+		}
+	}
+	return nil
+}
+
+func readEBMLData(r io.ReaderAt, el ebmlElement, max int64) ([]byte, error) {
+	if el.size < 0 || el.size > max {
+		return nil, fmt.Errorf("%w: element %x has an invalid size", ErrUnsupportedVideo, el.id)
+	}
+	buf := make([]byte, el.size)
+	if _, err := r.ReadAt(buf, el.offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func readEBMLUint(r io.ReaderAt, el ebmlElement) (uint64, error) {
+	buf, err := readEBMLData(r, el, 8)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, b := range buf {
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+func readEBMLFloat(r io.ReaderAt, el ebmlElement) (float64, error) {
+	buf, err := readEBMLData(r, el, 8)
+	if err != nil {
+		return 0, err
+	}
+	switch len(buf) {
+	case 0:
+		return 0, nil
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+	}
+	return 0, fmt.Errorf("%w: invalid float size", ErrUnsupportedVideo)
+}
+
+func readEBMLString(r io.ReaderAt, el ebmlElement) (string, error) {
+	buf, err := readEBMLData(r, el, 1024)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\x00"), nil
+}
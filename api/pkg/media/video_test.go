@@ -0,0 +1,215 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(out, uint32(8+len(body)))
+	copy(out[4:], typ)
+	return append(out, body...)
+}
+
+// largeBox writes a box with a 64-bit size, as used for big mdat boxes.
+func largeBox(typ string, payload []byte) []byte {
+	out := make([]byte, 16, 16+len(payload))
+	binary.BigEndian.PutUint32(out, 1)
+	copy(out[4:], typ)
+	binary.BigEndian.PutUint64(out[8:], uint64(16+len(payload)))
+	return append(out, payload...)
+}
+
+func ftyp(brand string) []byte {
+	return box("ftyp", []byte(brand), make([]byte, 4), []byte("isom"))
+}
+
+func mvhd(timescale, duration uint32) []byte {
+	p := make([]byte, 100)
+	binary.BigEndian.PutUint32(p[12:], timescale)
+	binary.BigEndian.PutUint32(p[16:], duration)
+	return box("mvhd", p)
+}
+
+func trak(handler, codec string, width, height int) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], uint32(width)<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], uint32(height)<<16)
+
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], handler)
+
+	stsd := make([]byte, 16)
+	binary.BigEndian.PutUint32(stsd[4:], 1)
+	binary.BigEndian.PutUint32(stsd[8:], 8)
+	copy(stsd[12:], codec)
+
+	return box("trak",
+		box("tkhd", tkhd),
+		box("mdia", box("hdlr", hdlr), box("minf", box("stbl", box("stsd", stsd)))),
+	)
+}
+
+func mp4File(boxes ...[]byte) []byte {
+	return bytes.Join(boxes, nil)
+}
+
+// ebml writes an element with an eight-byte size, which every reader has to
+// accept; size -1 marks it as unknown.
+func ebml(id uint64, size int64, payload ...[]byte) []byte {
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+
+	body := bytes.Join(payload, nil)
+	if size == 0 {
+		size = int64(len(body))
+	}
+	sizeBytes := make([]byte, 8)
+	if size < 0 {
+		binary.BigEndian.PutUint64(sizeBytes, 1<<56-1)
+	} else {
+		binary.BigEndian.PutUint64(sizeBytes, uint64(size))
+	}
+	sizeBytes[0] = 0x01
+	return append(append(out, sizeBytes...), body...)
+}
+
+func ebmlUint(id, v uint64) []byte {
+	return ebml(id, 0, binary.BigEndian.AppendUint32(nil, uint32(v)))
+}
+
+func ebmlFloat(id uint64, v float64) []byte {
+	return ebml(id, 0, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func webmTrack(trackType uint64, codec string, width, height uint64) []byte {
+	children := [][]byte{
+		ebmlUint(ebmlTrackType, trackType),
+		ebml(ebmlCodecID, 0, []byte(codec)),
+	}
+	if trackType == 1 {
+		children = append(children, ebml(ebmlVideo, 0,
+			ebmlUint(ebmlPixelWidth, width),
+			ebmlUint(ebmlPixelHeight, height),
+		))
+	}
+	return ebml(ebmlTrackEntry, 0, children...)
+}
+
+func webmFile(docType string, segmentSize int64, children ...[]byte) []byte {
+	header := ebml(0x1a45dfa3, 0, ebml(ebmlDocType, 0, []byte(docType)))
+	return append(header, ebml(ebmlSegment, segmentSize, children...)...)
+}
+
+func TestProbeVideo(t *testing.T) {
+	mdat := box("mdat", make([]byte, 64))
+	moov := box("moov",
+		mvhd(1000, 2500),
+		trak("vide", "avc1", 1280, 720),
+		trak("soun", "mp4a", 0, 0),
+	)
+	webmInfo := ebml(ebmlInfo, 0, ebmlUint(ebmlTimecodeScale, 1_000_000), ebmlFloat(ebmlDuration, 2500))
+	webmTracks := ebml(ebmlTracks, 0,
+		webmTrack(1, "V_VP9", 640, 360),
+		webmTrack(2, "A_OPUS", 0, 0),
+	)
+
+	tests := []struct {
+		name string
+		data []byte
+		want VideoInfo
+	}{
+		{"mp4", mp4File(ftyp("isom"), moov, mdat), VideoInfo{
+			ContentType: "video/mp4", Duration: 2500 * time.Millisecond,
+			Width: 1280, Height: 720, VideoCodec: "avc1", AudioCodec: "mp4a", HasAudio: true,
+		}},
+		{"moov after large mdat", mp4File(ftyp("isom"), largeBox("mdat", make([]byte, 64)), moov), VideoInfo{
+			ContentType: "video/mp4", Duration: 2500 * time.Millisecond,
+			Width: 1280, Height: 720, VideoCodec: "avc1", AudioCodec: "mp4a", HasAudio: true,
+		}},
+		{"quicktime", mp4File(ftyp("qt  "), box("moov", mvhd(600, 300), trak("vide", "avc1", 320, 240))), VideoInfo{
+			ContentType: "video/quicktime", Duration: 500 * time.Millisecond,
+			Width: 320, Height: 240, VideoCodec: "avc1",
+		}},
+		{"webm", webmFile("webm", 0, webmInfo, webmTracks), VideoInfo{
+			ContentType: "video/webm", Duration: 2500 * time.Millisecond,
+			Width: 640, Height: 360, VideoCodec: "V_VP9", AudioCodec: "A_OPUS", HasAudio: true,
+		}},
+		{"live webm", webmFile("webm", -1, webmTracks, ebml(ebmlCluster, -1)), VideoInfo{
+			ContentType: "video/webm",
+			Width:       640, Height: 360, VideoCodec: "V_VP9", AudioCodec: "A_OPUS", HasAudio: true,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ProbeVideo(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatalf("ProbeVideo: %v", err)
+			}
+			if *info != tt.want {
+				t.Fatalf("got %+v, want %+v", *info, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeVideoInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte("ftyp")},
+		{"unknown container", []byte("GIF89a\x00\x00\x00\x00\x00\x00")},
+		{"no moov", mp4File(ftyp("isom"), box("mdat", make([]byte, 16)))},
+		{"box overruns file", append(ftyp("isom"), 0, 0, 1, 0, 'm', 'o', 'o', 'v')},
+		{"child overruns moov", mp4File(ftyp("isom"), box("moov", []byte{0, 0, 1, 0, 't', 'r', 'a', 'k'}))},
+		{"other EBML doc type", webmFile("avi", 0)},
+		{"no segment", ebml(0x1a45dfa3, 0, ebml(ebmlDocType, 0, []byte("webm")))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ProbeVideo(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if !errors.Is(err, ErrUnsupportedVideo) {
+				t.Fatalf("got error %v, want ErrUnsupportedVideo", err)
+			}
+		})
+	}
+}
+
+func TestPlayable(t *testing.T) {
+	tests := []struct {
+		name string
+		info VideoInfo
+		ok   bool
+	}{
+		{"h264 and aac", VideoInfo{VideoCodec: "avc1", AudioCodec: "mp4a", HasAudio: true}, true},
+		{"vp9 without audio", VideoInfo{VideoCodec: "V_VP9"}, true},
+		{"no video track", VideoInfo{AudioCodec: "mp4a", HasAudio: true}, false},
+		{"mpeg-4 part 2", VideoInfo{VideoCodec: "mp4v"}, false},
+		{"ac-3 audio", VideoInfo{VideoCodec: "avc1", AudioCodec: "ac-3", HasAudio: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.info.Playable()
+			if tt.ok && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrUnsupportedVideo) {
+				t.Fatalf("got error %v, want ErrUnsupportedVideo", err)
+			}
+		})
+	}
+}
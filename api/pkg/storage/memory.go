@@ -0,0 +1,446 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ BlobStore = (*MemoryStorage)(nil)
+
+// MemoryStorage is an in-process BlobStore for tests. Pre-signed URLs use a
+// memory:// scheme and are only meant to be inspected, not fetched.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	uploads map[string]*memoryUpload
+}
+
+type memoryUpload struct {
+	key         string
+	contentType string
+	parts       map[int64][]byte
+}
+
+type memoryObject struct {
+	data         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+	metadata     map[string]string
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		objects: make(map[string]memoryObject),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+func (m *MemoryStorage) UploadFile(key string, data []byte, contentType string) error {
+	return m.UploadFileWithContext(context.Background(), key, data, contentType)
+}
+
+func (m *MemoryStorage) UploadFileWithContext(ctx context.Context, key string, data []byte, contentType string) error {
+	return m.UploadFileWithMetadataWithContext(ctx, key, data, contentType, nil)
+}
+
+func (m *MemoryStorage) UploadFileWithMetadata(key string, data []byte, contentType string, metadata map[string]*string) error {
+	return m.UploadFileWithMetadataWithContext(context.Background(), key, data, contentType, metadata)
+}
+
+func (m *MemoryStorage) UploadFileWithMetadataWithContext(ctx context.Context, key string, data []byte, contentType string, metadata map[string]*string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if key == "" {
+		return fmt.Errorf("file key is required")
+	}
+
+	sum := md5.Sum(data)
+	obj := memoryObject{
+		data:         append([]byte(nil), data...),
+		contentType:  contentType,
+		etag:         hex.EncodeToString(sum[:]),
+		lastModified: time.Now().UTC(),
+		metadata:     make(map[string]string, len(metadata)),
+	}
+	for k, v := range metadata {
+		if v != nil {
+			obj.metadata[k] = *v
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = obj
+	return nil
+}
+
+func (m *MemoryStorage) UploadStream(key string, body io.Reader, contentType string, metadata map[string]*string) error {
+	return m.UploadStreamWithContext(context.Background(), key, body, contentType, metadata)
+}
+
+func (m *MemoryStorage) UploadStreamWithContext(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]*string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read file data: %w", err)
+	}
+	return m.UploadFileWithMetadataWithContext(ctx, key, data, contentType, metadata)
+}
+
+func (m *MemoryStorage) DownloadFile(key string) ([]byte, error) {
+	return m.DownloadFileWithContext(context.Background(), key)
+}
+
+func (m *MemoryStorage) DownloadFileWithContext(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), obj.data...), nil
+}
+
+func (m *MemoryStorage) OpenFile(key string) (io.ReadCloser, *FileInfo, error) {
+	return m.OpenFileWithContext(context.Background(), key)
+}
+
+func (m *MemoryStorage) OpenFileWithContext(ctx context.Context, key string) (io.ReadCloser, *FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := m.DownloadFileWithContext(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := m.GetFileInfoWithContext(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+func (m *MemoryStorage) OpenFileRange(key string, offset, length int64) (io.ReadCloser, error) {
+	return m.OpenFileRangeWithContext(context.Background(), key, offset, length)
+}
+
+func (m *MemoryStorage) OpenFileRangeWithContext(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := m.DownloadFileWithContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MemoryStorage) DeleteFile(key string) error {
+	return m.DeleteFileWithContext(context.Background(), key)
+}
+
+func (m *MemoryStorage) DeleteFileWithContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *MemoryStorage) DeleteFiles(keys []string) (map[string]error, error) {
+	return m.DeleteFilesWithContext(context.Background(), keys)
+}
+
+func (m *MemoryStorage) DeleteFilesWithContext(ctx context.Context, keys []string) (map[string]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.objects, key)
+	}
+	return map[string]error{}, nil
+}
+
+func (m *MemoryStorage) CopyFile(srcKey, dstKey string) error {
+	return m.CopyFileWithContext(context.Background(), srcKey, dstKey)
+}
+
+func (m *MemoryStorage) CopyFileWithContext(ctx context.Context, srcKey, dstKey string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[srcKey]
+	if !ok {
+		return ErrNotFound
+	}
+	// the data is never modified in place, so the copy can share it
+	obj.lastModified = time.Now()
+	m.objects[dstKey] = obj
+	return nil
+}
+
+func (m *MemoryStorage) MoveFile(srcKey, dstKey string) error {
+	return m.MoveFileWithContext(context.Background(), srcKey, dstKey)
+}
+
+func (m *MemoryStorage) MoveFileWithContext(ctx context.Context, srcKey, dstKey string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[srcKey]
+	if !ok {
+		return ErrNotFound
+	}
+	obj.lastModified = time.Now()
+	m.objects[dstKey] = obj
+	delete(m.objects, srcKey)
+	return nil
+}
+
+func (m *MemoryStorage) FileExists(key string) (bool, error) {
+	return m.FileExistsWithContext(context.Background(), key)
+}
+
+func (m *MemoryStorage) FileExistsWithContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.objects[key]
+	return ok, nil
+}
+
+func (m *MemoryStorage) ListFiles(prefix string) ([]string, error) {
+	return m.ListFilesWithContext(context.Background(), prefix)
+}
+
+func (m *MemoryStorage) ListFilesWithContext(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *MemoryStorage) GetFileInfo(key string) (*FileInfo, error) {
+	return m.GetFileInfoWithContext(context.Background(), key)
+}
+
+func (m *MemoryStorage) GetFileInfoWithContext(ctx context.Context, key string) (*FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	metadata := make(map[string]*string, len(obj.metadata))
+	for k, v := range obj.metadata {
+		metadata[k] = &v
+	}
+
+	return &FileInfo{
+		Key:          key,
+		Size:         int64(len(obj.data)),
+		ContentType:  obj.contentType,
+		LastModified: obj.lastModified,
+		ETag:         obj.etag,
+		Metadata:     metadata,
+	}, nil
+}
+
+func (m *MemoryStorage) GeneratePreSignedURL(key string, expiration int64) (string, error) {
+	return memoryURL("GET", key, expiration), nil
+}
+
+func (m *MemoryStorage) GeneratePreSignedPost(conditions PostConditions, expiration int64) (*PreSignedPost, error) {
+	if err := conditions.validate(); err != nil {
+		return nil, err
+	}
+
+	policy, err := encodePolicy(time.Now().Add(time.Duration(expiration)*time.Second), conditions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode POST policy: %w", err)
+	}
+
+	return &PreSignedPost{
+		URL: "memory:///",
+		Fields: map[string]string{
+			"key":          conditions.Key,
+			"Content-Type": conditions.ContentType,
+			"policy":       policy,
+		},
+	}, nil
+}
+
+func (m *MemoryStorage) CreateMultipartUpload(key string, contentType string) (string, error) {
+	return m.CreateMultipartUploadWithContext(context.Background(), key, contentType)
+}
+
+func (m *MemoryStorage) CreateMultipartUploadWithContext(ctx context.Context, key string, contentType string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	uploadID := fmt.Sprintf("upload-%d", len(m.uploads)+1)
+	m.uploads[uploadID] = &memoryUpload{
+		key:         key,
+		contentType: contentType,
+		parts:       make(map[int64][]byte),
+	}
+	return uploadID, nil
+}
+
+func (m *MemoryStorage) GeneratePreSignedPartURL(key string, uploadID string, partNumber int64, expiration int64) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if upload, ok := m.uploads[uploadID]; !ok || upload.key != key {
+		return "", fmt.Errorf("multipart upload %s: %w", uploadID, ErrNotFound)
+	}
+	return fmt.Sprintf("%s&uploadId=%s&partNumber=%d", memoryURL("PUT", key, expiration), uploadID, partNumber), nil
+}
+
+// UploadPart stands in for a client PUT to a pre-signed part URL.
+func (m *MemoryStorage) UploadPart(key string, uploadID string, partNumber int64, data []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		return "", fmt.Errorf("multipart upload %s: %w", uploadID, ErrNotFound)
+	}
+	upload.parts[partNumber] = append([]byte(nil), data...)
+
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (m *MemoryStorage) CompleteMultipartUpload(key string, uploadID string, parts []CompletedPart) error {
+	return m.CompleteMultipartUploadWithContext(context.Background(), key, uploadID, parts)
+}
+
+func (m *MemoryStorage) CompleteMultipartUploadWithContext(ctx context.Context, key string, uploadID string, parts []CompletedPart) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		m.mu.Unlock()
+		return fmt.Errorf("multipart upload %s: %w", uploadID, ErrNotFound)
+	}
+
+	var data []byte
+	for _, part := range parts {
+		chunk, ok := upload.parts[part.PartNumber]
+		if !ok {
+			m.mu.Unlock()
+			return fmt.Errorf("part %d was not uploaded", part.PartNumber)
+		}
+		sum := md5.Sum(chunk)
+		if hex.EncodeToString(sum[:]) != part.ETag {
+			m.mu.Unlock()
+			return fmt.Errorf("part %d has a different ETag", part.PartNumber)
+		}
+		data = append(data, chunk...)
+	}
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+
+	return m.UploadFileWithContext(ctx, key, data, upload.contentType)
+}
+
+func (m *MemoryStorage) AbortMultipartUpload(key string, uploadID string) error {
+	return m.AbortMultipartUploadWithContext(context.Background(), key, uploadID)
+}
+
+func (m *MemoryStorage) AbortMultipartUploadWithContext(ctx context.Context, key string, uploadID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if upload, ok := m.uploads[uploadID]; !ok || upload.key != key {
+		return fmt.Errorf("multipart upload %s: %w", uploadID, ErrNotFound)
+	}
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+func memoryURL(method, key string, expiration int64) string {
+	query := url.Values{}
+	query.Set("method", method)
+	query.Set("expires", fmt.Sprint(time.Now().Add(time.Duration(expiration)*time.Second).Unix()))
+	return fmt.Sprintf("memory:///%s?%s", key, query.Encode())
+}
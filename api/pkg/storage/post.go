@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// PostConditions restrict what a browser may upload with a pre-signed POST.
+// The key and content type must match exactly and the body must be between
+// MinSize and MaxSize bytes.
+type PostConditions struct {
+	Key         string
+	ContentType string
+	MinSize     int64
+	MaxSize     int64
+}
+
+// PreSignedPost is a form upload: the client sends a multipart/form-data
+// POST to URL with every field in Fields followed by a "file" field.
+type PreSignedPost struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+func (c PostConditions) validate() error {
+	if c.Key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if c.ContentType == "" {
+		return fmt.Errorf("content type is required")
+	}
+	if c.MinSize < 0 || c.MaxSize < c.MinSize {
+		return fmt.Errorf("invalid size range %d-%d", c.MinSize, c.MaxSize)
+	}
+	return nil
+}
+
+// encodePolicy builds an S3 POST policy document for conditions plus any
+// extra conditions, returned base64 encoded as it is sent and signed.
+func encodePolicy(expires time.Time, c PostConditions, extra ...interface{}) (string, error) {
+	conditions := []interface{}{
+		map[string]string{"key": c.Key},
+		map[string]string{"Content-Type": c.ContentType},
+		[]interface{}{"content-length-range", c.MinSize, c.MaxSize},
+	}
+	conditions = append(conditions, extra...)
+
+	policy, err := json.Marshal(map[string]interface{}{
+		"expiration": expires.UTC().Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(policy), nil
+}
@@ -2,28 +2,29 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
-type FileInfo struct {
-	Key          string
-	Size         int64
-	ContentType  string
-	LastModified time.Time
-	ETag         string
-	Metadata     map[string]*string
-}
+var _ BlobStore = (*R2Storage)(nil)
 
 type R2Storage struct {
 	client     *s3.S3
+	uploader   *s3manager.Uploader
 	bucketName string
 }
 
@@ -46,6 +47,9 @@ func NewR2Storage(config R2Config) (*R2Storage, error) {
 			"",
 		),
 		S3ForcePathStyle: aws.Bool(true),
+		// retries are left to ResilientStore, which knows which calls are
+		// safe to repeat and trips its breaker when they keep failing
+		MaxRetries: aws.Int(0),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AWS session: %w", err)
@@ -53,14 +57,25 @@ func NewR2Storage(config R2Config) (*R2Storage, error) {
 
 	client := s3.New(sess)
 
+	// streamed uploads buffer PartSize * Concurrency bytes per request
+	uploader := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		u.PartSize = s3manager.MinUploadPartSize
+		u.Concurrency = 2
+	})
+
 	return &R2Storage{
 		client:     client,
+		uploader:   uploader,
 		bucketName: config.BucketName,
 	}, nil
 }
 
 func (r *R2Storage) UploadFile(key string, data []byte, contentType string) error {
-	_, err := r.client.PutObject(&s3.PutObjectInput{
+	return r.UploadFileWithContext(context.Background(), key, data, contentType)
+}
+
+func (r *R2Storage) UploadFileWithContext(ctx context.Context, key string, data []byte, contentType string) error {
+	_, err := r.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(r.bucketName),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(data),
@@ -74,7 +89,11 @@ func (r *R2Storage) UploadFile(key string, data []byte, contentType string) erro
 }
 
 func (r *R2Storage) UploadFileWithMetadata(key string, data []byte, contentType string, metadata map[string]*string) error {
-	_, err := r.client.PutObject(&s3.PutObjectInput{
+	return r.UploadFileWithMetadataWithContext(context.Background(), key, data, contentType, metadata)
+}
+
+func (r *R2Storage) UploadFileWithMetadataWithContext(ctx context.Context, key string, data []byte, contentType string, metadata map[string]*string) error {
+	_, err := r.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(r.bucketName),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(data),
@@ -88,12 +107,38 @@ func (r *R2Storage) UploadFileWithMetadata(key string, data []byte, contentType
 	return nil
 }
 
+func (r *R2Storage) UploadStream(key string, body io.Reader, contentType string, metadata map[string]*string) error {
+	return r.UploadStreamWithContext(context.Background(), key, body, contentType, metadata)
+}
+
+func (r *R2Storage) UploadStreamWithContext(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]*string) error {
+	_, err := r.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(r.bucketName),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stream file to R2: %w", err)
+	}
+
+	return nil
+}
+
 func (r *R2Storage) DownloadFile(key string) ([]byte, error) {
-	result, err := r.client.GetObject(&s3.GetObjectInput{
+	return r.DownloadFileWithContext(context.Background(), key)
+}
+
+func (r *R2Storage) DownloadFileWithContext(ctx context.Context, key string) ([]byte, error) {
+	result, err := r.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(r.bucketName),
 		Key:    aws.String(key),
 	})
 	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
 		return nil, fmt.Errorf("failed to download file from R2: %w", err)
 	}
 	defer result.Body.Close()
@@ -106,8 +151,67 @@ func (r *R2Storage) DownloadFile(key string) ([]byte, error) {
 	return data, nil
 }
 
+func (r *R2Storage) OpenFile(key string) (io.ReadCloser, *FileInfo, error) {
+	return r.OpenFileWithContext(context.Background(), key)
+}
+
+func (r *R2Storage) OpenFileWithContext(ctx context.Context, key string) (io.ReadCloser, *FileInfo, error) {
+	result, err := r.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to open file from R2: %w", err)
+	}
+
+	info := &FileInfo{
+		Key:          key,
+		Size:         aws.Int64Value(result.ContentLength),
+		ContentType:  aws.StringValue(result.ContentType),
+		LastModified: aws.TimeValue(result.LastModified),
+		ETag:         strings.Trim(aws.StringValue(result.ETag), "\""),
+		Metadata:     result.Metadata,
+	}
+
+	return result.Body, info, nil
+}
+
+// OpenFileRange reads length bytes starting at offset, or everything after
+// offset when length is negative.
+func (r *R2Storage) OpenFileRange(key string, offset, length int64) (io.ReadCloser, error) {
+	return r.OpenFileRangeWithContext(context.Background(), key, offset, length)
+}
+
+func (r *R2Storage) OpenFileRangeWithContext(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+
+	result, err := r.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucketName),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to open file range from R2: %w", err)
+	}
+
+	return result.Body, nil
+}
+
 func (r *R2Storage) DeleteFile(key string) error {
-	_, err := r.client.DeleteObject(&s3.DeleteObjectInput{
+	return r.DeleteFileWithContext(context.Background(), key)
+}
+
+func (r *R2Storage) DeleteFileWithContext(ctx context.Context, key string) error {
+	_, err := r.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(r.bucketName),
 		Key:    aws.String(key),
 	})
@@ -118,13 +222,91 @@ func (r *R2Storage) DeleteFile(key string) error {
 	return nil
 }
 
+// r2DeleteBatchSize is the most keys DeleteObjects accepts in one request.
+const r2DeleteBatchSize = 1000
+
+func (r *R2Storage) DeleteFiles(keys []string) (map[string]error, error) {
+	return r.DeleteFilesWithContext(context.Background(), keys)
+}
+
+// DeleteFilesWithContext deletes keys with as few DeleteObjects requests as
+// possible. The returned map holds the keys R2 refused to delete.
+func (r *R2Storage) DeleteFilesWithContext(ctx context.Context, keys []string) (map[string]error, error) {
+	failed := make(map[string]error)
+
+	for start := 0; start < len(keys); start += r2DeleteBatchSize {
+		batch := keys[start:min(start+r2DeleteBatchSize, len(keys))]
+
+		objects := make([]*s3.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			objects[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		result, err := r.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(r.bucketName),
+			Delete: &s3.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return failed, fmt.Errorf("failed to delete files from R2: %w", err)
+		}
+		for _, e := range result.Errors {
+			failed[aws.StringValue(e.Key)] = fmt.Errorf("failed to delete file from R2: %s: %s", aws.StringValue(e.Code), aws.StringValue(e.Message))
+		}
+	}
+
+	return failed, nil
+}
+
+func (r *R2Storage) CopyFile(srcKey, dstKey string) error {
+	return r.CopyFileWithContext(context.Background(), srcKey, dstKey)
+}
+
+// CopyFileWithContext copies an object within the bucket without downloading
+// it. The copy keeps the content type and metadata of the source.
+func (r *R2Storage) CopyFileWithContext(ctx context.Context, srcKey, dstKey string) error {
+	_, err := r.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(r.bucketName),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(url.PathEscape(r.bucketName) + "/" + escapeKey(srcKey)),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to copy file in R2: %w", err)
+	}
+
+	return nil
+}
+
+func (r *R2Storage) MoveFile(srcKey, dstKey string) error {
+	return r.MoveFileWithContext(context.Background(), srcKey, dstKey)
+}
+
+// MoveFileWithContext copies the object to dstKey and then deletes srcKey,
+// as the bucket has no rename. If the delete fails the object is left at
+// both keys.
+func (r *R2Storage) MoveFileWithContext(ctx context.Context, srcKey, dstKey string) error {
+	if err := r.CopyFileWithContext(ctx, srcKey, dstKey); err != nil {
+		return err
+	}
+	return r.DeleteFileWithContext(ctx, srcKey)
+}
+
 func (r *R2Storage) FileExists(key string) (bool, error) {
-	_, err := r.client.HeadObject(&s3.HeadObjectInput{
+	return r.FileExistsWithContext(context.Background(), key)
+}
+
+func (r *R2Storage) FileExistsWithContext(ctx context.Context, key string) (bool, error) {
+	_, err := r.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(r.bucketName),
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") {
+		if isNotFound(err) {
 			return false, nil
 		}
 		return false, fmt.Errorf("failed to check if file exists: %w", err)
@@ -134,6 +316,10 @@ func (r *R2Storage) FileExists(key string) (bool, error) {
 }
 
 func (r *R2Storage) ListFiles(prefix string) ([]string, error) {
+	return r.ListFilesWithContext(context.Background(), prefix)
+}
+
+func (r *R2Storage) ListFilesWithContext(ctx context.Context, prefix string) ([]string, error) {
 	var keys []string
 
 	input := &s3.ListObjectsV2Input{
@@ -144,7 +330,7 @@ func (r *R2Storage) ListFiles(prefix string) ([]string, error) {
 		input.Prefix = aws.String(prefix)
 	}
 
-	err := r.client.ListObjectsV2Pages(input,
+	err := r.client.ListObjectsV2PagesWithContext(ctx, input,
 		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
 			for _, object := range page.Contents {
 				keys = append(keys, *object.Key)
@@ -160,11 +346,18 @@ func (r *R2Storage) ListFiles(prefix string) ([]string, error) {
 }
 
 func (r *R2Storage) GetFileInfo(key string) (*FileInfo, error) {
-	result, err := r.client.HeadObject(&s3.HeadObjectInput{
+	return r.GetFileInfoWithContext(context.Background(), key)
+}
+
+func (r *R2Storage) GetFileInfoWithContext(ctx context.Context, key string) (*FileInfo, error) {
+	result, err := r.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(r.bucketName),
 		Key:    aws.String(key),
 	})
 	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
@@ -194,17 +387,152 @@ func (r *R2Storage) GeneratePreSignedURL(key string, expiration int64) (string,
 	return url, nil
 }
 
-func (r *R2Storage) GeneratePreSignedPutURL(key string, contentType string, expiration int64) (string, error) {
-	req, _ := r.client.PutObjectRequest(&s3.PutObjectInput{
+// GeneratePreSignedPost signs a SigV4 POST policy. Unlike a pre-signed PUT,
+// the bucket rejects uploads that don't match the policy's key, content type
+// and size range.
+func (r *R2Storage) GeneratePreSignedPost(conditions PostConditions, expiration int64) (*PreSignedPost, error) {
+	if err := conditions.validate(); err != nil {
+		return nil, err
+	}
+
+	creds, err := r.client.Config.Credentials.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %w", err)
+	}
+
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	region := aws.StringValue(r.client.Config.Region)
+	credential := fmt.Sprintf("%s/%s/%s/s3/aws4_request", creds.AccessKeyID, date, region)
+
+	policy, err := encodePolicy(now.Add(time.Duration(expiration)*time.Second), conditions,
+		map[string]string{"bucket": r.bucketName},
+		map[string]string{"x-amz-algorithm": "AWS4-HMAC-SHA256"},
+		map[string]string{"x-amz-credential": credential},
+		map[string]string{"x-amz-date": amzDate},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode POST policy: %w", err)
+	}
+
+	signingKey := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+
+	return &PreSignedPost{
+		URL: fmt.Sprintf("%s/%s", strings.TrimRight(r.client.Endpoint, "/"), r.bucketName),
+		Fields: map[string]string{
+			"key":              conditions.Key,
+			"Content-Type":     conditions.ContentType,
+			"policy":           policy,
+			"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+			"x-amz-credential": credential,
+			"x-amz-date":       amzDate,
+			"x-amz-signature":  hex.EncodeToString(hmacSHA256(signingKey, policy)),
+		},
+	}, nil
+}
+
+func (r *R2Storage) CreateMultipartUpload(key string, contentType string) (string, error) {
+	return r.CreateMultipartUploadWithContext(context.Background(), key, contentType)
+}
+
+func (r *R2Storage) CreateMultipartUploadWithContext(ctx context.Context, key string, contentType string) (string, error) {
+	result, err := r.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
 		Bucket:      aws.String(r.bucketName),
 		Key:         aws.String(key),
 		ContentType: aws.String(contentType),
 	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	return aws.StringValue(result.UploadId), nil
+}
+
+func (r *R2Storage) GeneratePreSignedPartURL(key string, uploadID string, partNumber int64, expiration int64) (string, error) {
+	req, _ := r.client.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(r.bucketName),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(partNumber),
+	})
 
 	url, err := req.Presign(time.Duration(expiration) * time.Second)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate pre-signed PUT URL: %w", err)
+		return "", fmt.Errorf("failed to generate pre-signed part URL: %w", err)
 	}
 
 	return url, nil
 }
+
+func (r *R2Storage) CompleteMultipartUpload(key string, uploadID string, parts []CompletedPart) error {
+	return r.CompleteMultipartUploadWithContext(context.Background(), key, uploadID, parts)
+}
+
+func (r *R2Storage) CompleteMultipartUploadWithContext(ctx context.Context, key string, uploadID string, parts []CompletedPart) error {
+	completed := make([]*s3.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.PartNumber),
+		})
+	}
+
+	_, err := r.client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(r.bucketName),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+func (r *R2Storage) AbortMultipartUpload(key string, uploadID string) error {
+	return r.AbortMultipartUploadWithContext(context.Background(), key, uploadID)
+}
+
+func (r *R2Storage) AbortMultipartUploadWithContext(ctx context.Context, key string, uploadID string) error {
+	_, err := r.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(r.bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapeKey escapes every segment of key for use in a URL path, keeping the
+// slashes between them.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func isNotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
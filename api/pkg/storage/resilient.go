@@ -0,0 +1,491 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// ErrUnavailable is returned while the bucket is failing, either straight
+// away because the circuit breaker is open or after retries ran out.
+var ErrUnavailable = errors.New("storage is temporarily unavailable")
+
+var _ BlobStore = (*ResilientStore)(nil)
+
+// ResilientStore wraps a BlobStore with per-call timeouts, retries of
+// transient failures and a circuit breaker. Once enough calls in a row have
+// failed, calls fail with ErrUnavailable without reaching the bucket until
+// the cooldown has passed and a trial call succeeds.
+type ResilientStore struct {
+	next    BlobStore
+	config  ResilientConfig
+	breaker *breaker
+}
+
+type ResilientConfig struct {
+	// Timeout bounds each attempt of a call that doesn't move a whole
+	// object, and how long opening a file may take before its body is read.
+	Timeout time.Duration
+	// TransferTimeout bounds each attempt of an upload or download.
+	TransferTimeout time.Duration
+	MaxRetries      int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	// FailureThreshold is how many failed calls in a row open the breaker,
+	// and Cooldown how long it stays open.
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+func NewResilientStore(next BlobStore, config ResilientConfig) *ResilientStore {
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.TransferTimeout <= 0 {
+		config.TransferTimeout = 5 * time.Minute
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = 100 * time.Millisecond
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = 2 * time.Second
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = 30 * time.Second
+	}
+
+	return &ResilientStore{
+		next:   next,
+		config: config,
+		breaker: &breaker{
+			threshold: config.FailureThreshold,
+			cooldown:  config.Cooldown,
+		},
+	}
+}
+
+// Unwrap returns the store calls are forwarded to.
+func (s *ResilientStore) Unwrap() BlobStore {
+	return s.next
+}
+
+func (s *ResilientStore) UploadFile(key string, data []byte, contentType string) error {
+	return s.UploadFileWithContext(context.Background(), key, data, contentType)
+}
+
+func (s *ResilientStore) UploadFileWithContext(ctx context.Context, key string, data []byte, contentType string) error {
+	return s.call(ctx, s.config.TransferTimeout, true, func(ctx context.Context) error {
+		return s.next.UploadFileWithContext(ctx, key, data, contentType)
+	})
+}
+
+func (s *ResilientStore) UploadFileWithMetadata(key string, data []byte, contentType string, metadata map[string]*string) error {
+	return s.UploadFileWithMetadataWithContext(context.Background(), key, data, contentType, metadata)
+}
+
+func (s *ResilientStore) UploadFileWithMetadataWithContext(ctx context.Context, key string, data []byte, contentType string, metadata map[string]*string) error {
+	return s.call(ctx, s.config.TransferTimeout, true, func(ctx context.Context) error {
+		return s.next.UploadFileWithMetadataWithContext(ctx, key, data, contentType, metadata)
+	})
+}
+
+func (s *ResilientStore) UploadStream(key string, body io.Reader, contentType string, metadata map[string]*string) error {
+	return s.UploadStreamWithContext(context.Background(), key, body, contentType, metadata)
+}
+
+// UploadStreamWithContext only retries bodies it can rewind.
+func (s *ResilientStore) UploadStreamWithContext(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]*string) error {
+	seeker, retry := body.(io.Seeker)
+	var start int64
+	if retry {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			retry = false
+		}
+	}
+
+	attempt := 0
+	return s.call(ctx, s.config.TransferTimeout, retry, func(ctx context.Context) error {
+		if attempt > 0 {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to rewind upload: %w", err)
+			}
+		}
+		attempt++
+		return s.next.UploadStreamWithContext(ctx, key, body, contentType, metadata)
+	})
+}
+
+func (s *ResilientStore) DownloadFile(key string) ([]byte, error) {
+	return s.DownloadFileWithContext(context.Background(), key)
+}
+
+func (s *ResilientStore) DownloadFileWithContext(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := s.call(ctx, s.config.TransferTimeout, true, func(ctx context.Context) error {
+		var err error
+		data, err = s.next.DownloadFileWithContext(ctx, key)
+		return err
+	})
+	return data, err
+}
+
+func (s *ResilientStore) OpenFile(key string) (io.ReadCloser, *FileInfo, error) {
+	return s.OpenFileWithContext(context.Background(), key)
+}
+
+func (s *ResilientStore) OpenFileWithContext(ctx context.Context, key string) (io.ReadCloser, *FileInfo, error) {
+	var body io.ReadCloser
+	var info *FileInfo
+	release, err := s.do(ctx, s.config.Timeout, true, func(ctx context.Context) error {
+		var err error
+		body, info, err = s.next.OpenFileWithContext(ctx, key)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &releaseOnClose{ReadCloser: body, release: release}, info, nil
+}
+
+func (s *ResilientStore) OpenFileRange(key string, offset, length int64) (io.ReadCloser, error) {
+	return s.OpenFileRangeWithContext(context.Background(), key, offset, length)
+}
+
+func (s *ResilientStore) OpenFileRangeWithContext(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	release, err := s.do(ctx, s.config.Timeout, true, func(ctx context.Context) error {
+		var err error
+		body, err = s.next.OpenFileRangeWithContext(ctx, key, offset, length)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &releaseOnClose{ReadCloser: body, release: release}, nil
+}
+
+func (s *ResilientStore) DeleteFile(key string) error {
+	return s.DeleteFileWithContext(context.Background(), key)
+}
+
+func (s *ResilientStore) DeleteFileWithContext(ctx context.Context, key string) error {
+	return s.call(ctx, s.config.Timeout, true, func(ctx context.Context) error {
+		return s.next.DeleteFileWithContext(ctx, key)
+	})
+}
+
+func (s *ResilientStore) DeleteFiles(keys []string) (map[string]error, error) {
+	return s.DeleteFilesWithContext(context.Background(), keys)
+}
+
+// DeleteFilesWithContext retries the whole batch when the request fails;
+// deletes are idempotent so keys removed by an earlier attempt are fine.
+// Keys the store refused are returned rather than retried.
+func (s *ResilientStore) DeleteFilesWithContext(ctx context.Context, keys []string) (map[string]error, error) {
+	var failed map[string]error
+	err := s.call(ctx, s.config.Timeout, true, func(ctx context.Context) error {
+		var err error
+		failed, err = s.next.DeleteFilesWithContext(ctx, keys)
+		return err
+	})
+	return failed, err
+}
+
+func (s *ResilientStore) CopyFile(srcKey, dstKey string) error {
+	return s.CopyFileWithContext(context.Background(), srcKey, dstKey)
+}
+
+func (s *ResilientStore) CopyFileWithContext(ctx context.Context, srcKey, dstKey string) error {
+	return s.call(ctx, s.config.Timeout, true, func(ctx context.Context) error {
+		return s.next.CopyFileWithContext(ctx, srcKey, dstKey)
+	})
+}
+
+func (s *ResilientStore) MoveFile(srcKey, dstKey string) error {
+	return s.MoveFileWithContext(context.Background(), srcKey, dstKey)
+}
+
+// MoveFileWithContext retries the copy and the delete separately, so a
+// retry never copies from a key an earlier attempt already removed.
+func (s *ResilientStore) MoveFileWithContext(ctx context.Context, srcKey, dstKey string) error {
+	if err := s.CopyFileWithContext(ctx, srcKey, dstKey); err != nil {
+		return err
+	}
+	return s.DeleteFileWithContext(ctx, srcKey)
+}
+
+func (s *ResilientStore) FileExists(key string) (bool, error) {
+	return s.FileExistsWithContext(context.Background(), key)
+}
+
+func (s *ResilientStore) FileExistsWithContext(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := s.call(ctx, s.config.Timeout, true, func(ctx context.Context) error {
+		var err error
+		exists, err = s.next.FileExistsWithContext(ctx, key)
+		return err
+	})
+	return exists, err
+}
+
+func (s *ResilientStore) GetFileInfo(key string) (*FileInfo, error) {
+	return s.GetFileInfoWithContext(context.Background(), key)
+}
+
+func (s *ResilientStore) GetFileInfoWithContext(ctx context.Context, key string) (*FileInfo, error) {
+	var info *FileInfo
+	err := s.call(ctx, s.config.Timeout, true, func(ctx context.Context) error {
+		var err error
+		info, err = s.next.GetFileInfoWithContext(ctx, key)
+		return err
+	})
+	return info, err
+}
+
+func (s *ResilientStore) ListFiles(prefix string) ([]string, error) {
+	return s.ListFilesWithContext(context.Background(), prefix)
+}
+
+// ListFilesWithContext pages through the whole prefix in one attempt, so it
+// gets the transfer timeout.
+func (s *ResilientStore) ListFilesWithContext(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.call(ctx, s.config.TransferTimeout, true, func(ctx context.Context) error {
+		var err error
+		keys, err = s.next.ListFilesWithContext(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+func (s *ResilientStore) GeneratePreSignedURL(key string, expiration int64) (string, error) {
+	return s.next.GeneratePreSignedURL(key, expiration)
+}
+
+func (s *ResilientStore) GeneratePreSignedPost(conditions PostConditions, expiration int64) (*PreSignedPost, error) {
+	return s.next.GeneratePreSignedPost(conditions, expiration)
+}
+
+func (s *ResilientStore) CreateMultipartUpload(key string, contentType string) (string, error) {
+	return s.CreateMultipartUploadWithContext(context.Background(), key, contentType)
+}
+
+// CreateMultipartUploadWithContext is not retried, since a lost response
+// would leave an upload behind that nothing ever completes or aborts.
+func (s *ResilientStore) CreateMultipartUploadWithContext(ctx context.Context, key string, contentType string) (string, error) {
+	var uploadID string
+	err := s.call(ctx, s.config.Timeout, false, func(ctx context.Context) error {
+		var err error
+		uploadID, err = s.next.CreateMultipartUploadWithContext(ctx, key, contentType)
+		return err
+	})
+	return uploadID, err
+}
+
+func (s *ResilientStore) GeneratePreSignedPartURL(key string, uploadID string, partNumber int64, expiration int64) (string, error) {
+	return s.next.GeneratePreSignedPartURL(key, uploadID, partNumber, expiration)
+}
+
+func (s *ResilientStore) CompleteMultipartUpload(key string, uploadID string, parts []CompletedPart) error {
+	return s.CompleteMultipartUploadWithContext(context.Background(), key, uploadID, parts)
+}
+
+// CompleteMultipartUploadWithContext can take a while on big uploads, since
+// the bucket assembles the parts before answering.
+func (s *ResilientStore) CompleteMultipartUploadWithContext(ctx context.Context, key string, uploadID string, parts []CompletedPart) error {
+	return s.call(ctx, s.config.TransferTimeout, true, func(ctx context.Context) error {
+		return s.next.CompleteMultipartUploadWithContext(ctx, key, uploadID, parts)
+	})
+}
+
+func (s *ResilientStore) AbortMultipartUpload(key string, uploadID string) error {
+	return s.AbortMultipartUploadWithContext(context.Background(), key, uploadID)
+}
+
+func (s *ResilientStore) AbortMultipartUploadWithContext(ctx context.Context, key string, uploadID string) error {
+	return s.call(ctx, s.config.Timeout, true, func(ctx context.Context) error {
+		return s.next.AbortMultipartUploadWithContext(ctx, key, uploadID)
+	})
+}
+
+func (s *ResilientStore) call(ctx context.Context, timeout time.Duration, retry bool, op func(ctx context.Context) error) error {
+	release, err := s.do(ctx, timeout, retry, op)
+	if release != nil {
+		release()
+	}
+	return err
+}
+
+// do runs op until it succeeds, fails with an error that isn't transient or
+// runs out of retries. Each attempt gets a context that is cancelled if op
+// hasn't returned within timeout. The context of the successful attempt is
+// only released by calling release, so a body returned by op can still be
+// read after the timeout would have passed.
+func (s *ResilientStore) do(ctx context.Context, timeout time.Duration, retry bool, op func(ctx context.Context) error) (release func(), err error) {
+	for attempt := 0; ; attempt++ {
+		if !s.breaker.allow() {
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+			}
+			return nil, ErrUnavailable
+		}
+
+		attemptCtx, cancel := context.WithCancel(ctx)
+		timer := time.AfterFunc(timeout, cancel)
+		err = op(attemptCtx)
+		timedOut := !timer.Stop()
+
+		switch {
+		case err == nil:
+			s.breaker.succeeded()
+			return cancel, nil
+		case ctx.Err() != nil:
+			// the caller gave up, which says nothing about the bucket
+			cancel()
+			s.breaker.abandoned()
+			return nil, err
+		case timedOut:
+			err = fmt.Errorf("storage call timed out after %s: %w", timeout, err)
+		case !isTransient(err):
+			cancel()
+			s.breaker.succeeded()
+			return nil, err
+		}
+		cancel()
+		s.breaker.failed()
+
+		if !retry || attempt >= s.config.MaxRetries {
+			return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+
+		wait := time.NewTimer(s.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return nil, err
+		case <-wait.C:
+		}
+	}
+}
+
+// backoff is a random wait of up to BaseDelay doubled for every attempt
+// made, capped at MaxDelay.
+func (s *ResilientStore) backoff(attempt int) time.Duration {
+	ceiling := s.config.MaxDelay
+	if attempt < 30 {
+		ceiling = min(s.config.BaseDelay<<attempt, s.config.MaxDelay)
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// isTransient reports whether err looks like the bucket or the network
+// having trouble rather than something wrong with the request.
+func isTransient(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		code := reqErr.StatusCode()
+		if code >= 500 || code == 429 {
+			return true
+		}
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case "RequestError", "RequestTimeout", "RequestTimeoutException", "SlowDown",
+			"Throttling", "ThrottlingException", "InternalError", "ServiceUnavailable":
+			return true
+		}
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// breaker counts failed calls in a row. Once there are threshold of them it
+// opens for cooldown, then lets a single call through to see whether the
+// bucket has recovered.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// succeeded, failed and abandoned record the outcome of a call allow let
+// through. A call the caller gave up on counts as neither.
+func (b *breaker) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if b.failures >= b.threshold {
+		log.Printf("storage recovered, closing circuit breaker")
+	}
+	b.failures = 0
+}
+
+func (b *breaker) failed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	b.failures++
+	if b.failures == b.threshold {
+		log.Printf("storage failed %d times in a row, opening circuit breaker for %s", b.failures, b.cooldown)
+	}
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+func (b *breaker) abandoned() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+var (
+	errTransient = awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), 500, "req")
+	errPermanent = awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), 403, "req")
+	// errHang makes a call block until its context is cancelled.
+	errHang = errors.New("hang")
+)
+
+// flakyStore fails its calls with errs, in order, and then passes them on.
+type flakyStore struct {
+	*MemoryStorage
+
+	mu    sync.Mutex
+	errs  []error
+	calls int
+}
+
+func newFlakyStore(errs ...error) *flakyStore {
+	return &flakyStore{MemoryStorage: NewMemoryStorage(), errs: errs}
+}
+
+func (f *flakyStore) fail(ctx context.Context) error {
+	f.mu.Lock()
+	f.calls++
+	var err error
+	if len(f.errs) > 0 {
+		err, f.errs = f.errs[0], f.errs[1:]
+	}
+	f.mu.Unlock()
+
+	if err == errHang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return err
+}
+
+func (f *flakyStore) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (f *flakyStore) FileExistsWithContext(ctx context.Context, key string) (bool, error) {
+	if err := f.fail(ctx); err != nil {
+		return false, err
+	}
+	return f.MemoryStorage.FileExistsWithContext(ctx, key)
+}
+
+// UploadStreamWithContext reads the whole body before failing, the way a
+// request that breaks off after sending does.
+func (f *flakyStore) UploadStreamWithContext(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]*string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if err := f.fail(ctx); err != nil {
+		return err
+	}
+	return f.MemoryStorage.UploadFileWithContext(ctx, key, data, contentType)
+}
+
+func testConfig() ResilientConfig {
+	return ResilientConfig{
+		Timeout:          20 * time.Millisecond,
+		MaxRetries:       2,
+		BaseDelay:        time.Millisecond,
+		MaxDelay:         time.Millisecond,
+		FailureThreshold: 100,
+		Cooldown:         time.Hour,
+	}
+}
+
+func TestResilientRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		errs        []error
+		calls       int
+		want        error
+		unavailable bool
+	}{
+		{"success", nil, 1, nil, false},
+		{"transient failure then success", []error{errTransient, errTransient}, 3, nil, false},
+		{"retries run out", []error{errTransient, errTransient, errTransient}, 3, errTransient, true},
+		{"permanent failure", []error{errPermanent}, 1, errPermanent, false},
+		{"timeout then success", []error{errHang}, 2, nil, false},
+		{"plain error", []error{io.ErrUnexpectedEOF}, 1, io.ErrUnexpectedEOF, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := newFlakyStore(tt.errs...)
+			s := NewResilientStore(next, testConfig())
+
+			_, err := s.FileExists("key")
+			if tt.want == nil && err != nil {
+				t.Fatalf("got error %v, want success", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			if unavailable := errors.Is(err, ErrUnavailable); unavailable != tt.unavailable {
+				t.Fatalf("got error %v, want unavailable=%v", err, tt.unavailable)
+			}
+			if calls := next.Calls(); calls != tt.calls {
+				t.Fatalf("made %d calls, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestResilientCallerCancel(t *testing.T) {
+	config := testConfig()
+	config.FailureThreshold = 1
+	next := newFlakyStore(errHang)
+	s := NewResilientStore(next, config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond, cancel)
+	if _, err := s.FileExistsWithContext(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want the caller's cancellation", err)
+	}
+
+	// a call the caller gave up on says nothing about the bucket
+	if _, err := s.FileExists("key"); err != nil {
+		t.Fatalf("got error %v after a cancelled call, want the breaker closed", err)
+	}
+	if calls := next.Calls(); calls != 2 {
+		t.Fatalf("made %d calls, want 2", calls)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	config := testConfig()
+	config.MaxRetries = 0
+	config.FailureThreshold = 2
+	config.Cooldown = 20 * time.Millisecond
+
+	// each step is one call, made against the breaker the steps before it left
+	steps := []struct {
+		name    string
+		errs    []error
+		wait    time.Duration
+		reached bool
+		fails   bool
+	}{
+		{"first failure", []error{errTransient}, 0, true, true},
+		{"permanent failure resets the count", []error{errPermanent}, 0, true, true},
+		{"failure after reset", []error{errTransient}, 0, true, true},
+		{"threshold reached", []error{errTransient}, 0, true, true},
+		{"open", nil, 0, false, true},
+		{"failed probe after cooldown", []error{errTransient}, config.Cooldown, true, true},
+		{"open again", nil, 0, false, true},
+		{"successful probe after cooldown", nil, config.Cooldown, true, false},
+		{"closed", nil, 0, true, false},
+	}
+
+	next := newFlakyStore()
+	s := NewResilientStore(next, config)
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			time.Sleep(step.wait)
+			next.mu.Lock()
+			next.errs = step.errs
+			next.mu.Unlock()
+
+			before := next.Calls()
+			_, err := s.FileExists("key")
+			if reached := next.Calls() > before; reached != step.reached {
+				t.Fatalf("reached the bucket: %v, want %v", reached, step.reached)
+			}
+			if fails := err != nil; fails != step.fails {
+				t.Fatalf("got error %v, want failure=%v", err, step.fails)
+			}
+			if !step.reached && !errors.Is(err, ErrUnavailable) {
+				t.Fatalf("got error %v from an open breaker, want ErrUnavailable", err)
+			}
+		})
+	}
+}
+
+func TestUploadStreamRewind(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  func() io.Reader
+		want  string
+		calls int
+		fails bool
+	}{
+		{
+			name:  "seekable body from its start",
+			body:  func() io.Reader { return bytes.NewReader([]byte("hello world")) },
+			want:  "hello world",
+			calls: 2,
+		},
+		{
+			name: "seekable body from the middle",
+			body: func() io.Reader {
+				r := bytes.NewReader([]byte("skip:hello world"))
+				r.Seek(5, io.SeekStart)
+				return r
+			},
+			want:  "hello world",
+			calls: 2,
+		},
+		{
+			name:  "body that can't be rewound",
+			body:  func() io.Reader { return io.MultiReader(strings.NewReader("hello world")) },
+			calls: 1,
+			fails: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := newFlakyStore(errTransient)
+			s := NewResilientStore(next, testConfig())
+
+			err := s.UploadStream("key", tt.body(), "text/plain", nil)
+			if calls := next.Calls(); calls != tt.calls {
+				t.Fatalf("made %d calls, want %d", calls, tt.calls)
+			}
+			if tt.fails {
+				if !errors.Is(err, ErrUnavailable) {
+					t.Fatalf("got error %v, want ErrUnavailable", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("upload failed: %v", err)
+			}
+
+			data, err := next.DownloadFile("key")
+			if err != nil {
+				t.Fatalf("failed to download: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Fatalf("stored %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
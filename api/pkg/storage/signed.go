@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// signedURLCacheSweep is how many entries the cache may hold before expired
+// ones are swept out.
+const signedURLCacheSweep = 10000
+
+// SignedURLCache hands out pre-signed download URLs and reuses them while
+// they still have at least half their lifetime left, so reloading a feed
+// doesn't re-sign every object and browsers get stable URLs to cache.
+type SignedURLCache struct {
+	blobs BlobStore
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]signedURL
+}
+
+type signedURL struct {
+	url     string
+	expires time.Time
+}
+
+func NewSignedURLCache(blobs BlobStore, ttl time.Duration) *SignedURLCache {
+	return &SignedURLCache{
+		blobs:   blobs,
+		ttl:     ttl,
+		entries: make(map[string]signedURL),
+	}
+}
+
+// URL returns a pre-signed URL for key along with the time it stops working.
+func (c *SignedURLCache) URL(key string) (string, time.Time, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && entry.expires.Sub(now) >= c.ttl/2 {
+		return entry.url, entry.expires, nil
+	}
+
+	url, err := c.blobs.GeneratePreSignedURL(key, int64(c.ttl/time.Second))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	entry = signedURL{url: url, expires: now.Add(c.ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= signedURLCacheSweep {
+		for k, e := range c.entries {
+			if !e.expires.After(now) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = entry
+
+	return entry.url, entry.expires, nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+type FileInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	ETag         string
+	Metadata     map[string]*string
+}
+
+type CompletedPart struct {
+	PartNumber int64  `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+// BlobStore is the bucket the API stores files in. Every method that talks to
+// the bucket has a WithContext variant; the plain ones use
+// context.Background().
+type BlobStore interface {
+	UploadFile(key string, data []byte, contentType string) error
+	UploadFileWithMetadata(key string, data []byte, contentType string, metadata map[string]*string) error
+	UploadStream(key string, body io.Reader, contentType string, metadata map[string]*string) error
+	DownloadFile(key string) ([]byte, error)
+	OpenFile(key string) (io.ReadCloser, *FileInfo, error)
+	OpenFileRange(key string, offset, length int64) (io.ReadCloser, error)
+	DeleteFile(key string) error
+	DeleteFiles(keys []string) (map[string]error, error)
+	CopyFile(srcKey, dstKey string) error
+	MoveFile(srcKey, dstKey string) error
+	FileExists(key string) (bool, error)
+	GetFileInfo(key string) (*FileInfo, error)
+	ListFiles(prefix string) ([]string, error)
+	GeneratePreSignedURL(key string, expiration int64) (string, error)
+	GeneratePreSignedPost(conditions PostConditions, expiration int64) (*PreSignedPost, error)
+
+	CreateMultipartUpload(key string, contentType string) (string, error)
+	GeneratePreSignedPartURL(key string, uploadID string, partNumber int64, expiration int64) (string, error)
+	CompleteMultipartUpload(key string, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(key string, uploadID string) error
+
+	UploadFileWithContext(ctx context.Context, key string, data []byte, contentType string) error
+	UploadFileWithMetadataWithContext(ctx context.Context, key string, data []byte, contentType string, metadata map[string]*string) error
+	UploadStreamWithContext(ctx context.Context, key string, body io.Reader, contentType string, metadata map[string]*string) error
+	DownloadFileWithContext(ctx context.Context, key string) ([]byte, error)
+	OpenFileWithContext(ctx context.Context, key string) (io.ReadCloser, *FileInfo, error)
+	OpenFileRangeWithContext(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	DeleteFileWithContext(ctx context.Context, key string) error
+	DeleteFilesWithContext(ctx context.Context, keys []string) (map[string]error, error)
+	CopyFileWithContext(ctx context.Context, srcKey, dstKey string) error
+	MoveFileWithContext(ctx context.Context, srcKey, dstKey string) error
+	FileExistsWithContext(ctx context.Context, key string) (bool, error)
+	GetFileInfoWithContext(ctx context.Context, key string) (*FileInfo, error)
+	ListFilesWithContext(ctx context.Context, prefix string) ([]string, error)
+	CreateMultipartUploadWithContext(ctx context.Context, key string, contentType string) (string, error)
+	CompleteMultipartUploadWithContext(ctx context.Context, key string, uploadID string, parts []CompletedPart) error
+	AbortMultipartUploadWithContext(ctx context.Context, key string, uploadID string) error
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+)
+
+// URLBuilder turns object keys into the public URLs clients load them from.
+// Only keys are persisted, so the CDN domain or path layout can change
+// without rewriting any rows.
+type URLBuilder struct {
+	base   string
+	prefix string
+}
+
+type URLConfig struct {
+	// BaseURL is the scheme and host objects are served from, e.g.
+	// https://cdn.ryo.cat.
+	BaseURL string
+	// PathPrefix is prepended to every key, for buckets mounted below the
+	// root of the CDN.
+	PathPrefix string
+}
+
+func NewURLBuilder(config URLConfig) *URLBuilder {
+	return &URLBuilder{
+		base:   strings.TrimRight(config.BaseURL, "/"),
+		prefix: strings.Trim(config.PathPrefix, "/"),
+	}
+}
+
+// URL returns the public URL for key. Values that are already absolute
+// URLs, such as profile pictures hosted elsewhere, are returned unchanged.
+func (b *URLBuilder) URL(key string) string {
+	if key == "" || isAbsoluteURL(key) {
+		return key
+	}
+
+	p := strings.TrimLeft(key, "/")
+	if b.prefix != "" {
+		p = b.prefix + "/" + p
+	}
+	return b.base + "/" + (&url.URL{Path: p}).EscapedPath()
+}
+
+// Key reverses URL. It reports false when rawURL does not point at an
+// object served by this builder.
+func (b *URLBuilder) Key(rawURL string) (string, bool) {
+	if !isAbsoluteURL(rawURL) {
+		key := strings.TrimLeft(rawURL, "/")
+		return key, key != ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	base, err := url.Parse(b.base)
+	if err != nil || !strings.EqualFold(u.Host, base.Host) {
+		return "", false
+	}
+
+	p := strings.TrimPrefix(u.Path, strings.TrimRight(base.Path, "/"))
+	p = strings.TrimLeft(p, "/")
+	if b.prefix != "" {
+		if !strings.HasPrefix(p, b.prefix+"/") {
+			return "", false
+		}
+		p = strings.TrimPrefix(p, b.prefix+"/")
+	}
+	return p, p != ""
+}
+
+func isAbsoluteURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// FeedQuery selects a page of posts, newest first. Posts that aren't public
+// are only included for their author.
+type FeedQuery struct {
+	// only posts by this user when set
+	UserID string
+	// the viewer, whose likes are reported; empty when signed out
+	CurrentUserID string
+	Limit         int
+	// After continues a feed from the last post of the previous page.
+	// Offset is only used without it, for the deprecated page parameter.
+	After  *Cursor
+	Offset int
+}
+
+// Cursor is the position of a post in a feed. Posts are ordered by creation
+// time and then by ID, so a cursor stays valid while new posts arrive.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+func CursorFor(post *Post) *Cursor {
+	return &Cursor{CreatedAt: post.CreatedAt, ID: post.ID}
+}
+
+// Encode returns the cursor as an opaque string for clients to send back.
+func (c *Cursor) Encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixNano(), 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a cursor made by Encode.
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{CreatedAt: time.Unix(0, n).UTC(), ID: id}, nil
+}
+
+// before reports whether a post created at createdAt with the given ID comes
+// after c in a feed.
+func (c *Cursor) before(createdAt time.Time, id string) bool {
+	if !createdAt.Equal(c.CreatedAt) {
+		return createdAt.Before(c.CreatedAt)
+	}
+	return id < c.ID
+}
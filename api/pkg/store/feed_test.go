@@ -0,0 +1,198 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor Cursor
+	}{
+		{"whole seconds", Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), ID: "post-1"}},
+		{"nanoseconds", Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC), ID: "post-2"}},
+		{"before the epoch", Cursor{CreatedAt: time.Date(1960, 1, 1, 0, 0, 0, 1, time.UTC), ID: "old"}},
+		{"colon in the ID", Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), ID: "a:b:c"}},
+		{"other time zone", Cursor{CreatedAt: time.Date(2024, 5, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), ID: "post-3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := tt.cursor.Encode()
+			if _, err := base64.RawURLEncoding.DecodeString(encoded); err != nil {
+				t.Fatalf("cursor %q isn't URL-safe base64: %v", encoded, err)
+			}
+
+			got, err := ParseCursor(encoded)
+			if err != nil {
+				t.Fatalf("failed to parse %q: %v", encoded, err)
+			}
+			if !got.CreatedAt.Equal(tt.cursor.CreatedAt) || got.ID != tt.cursor.ID {
+				t.Fatalf("got %+v, want %+v", got, tt.cursor)
+			}
+			if got.CreatedAt.Location() != time.UTC {
+				t.Fatalf("got time in %s, want UTC", got.CreatedAt.Location())
+			}
+		})
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("12:ab"))},
+		{"no separator", encode("1714564800000000000")},
+		{"no ID", encode("1714564800000000000:")},
+		{"time not a number", encode("yesterday:post-1")},
+		{"time out of range", encode("99999999999999999999:post-1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := ParseCursor(tt.input); !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("got %+v, %v, want ErrInvalidCursor", c, err)
+			}
+		})
+	}
+}
+
+func TestCursorBefore(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := &Cursor{CreatedAt: at, ID: "m"}
+
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		id        string
+		want      bool
+	}{
+		{"older", at.Add(-time.Second), "z", true},
+		{"newer", at.Add(time.Second), "a", false},
+		{"same time, lower ID", at, "a", true},
+		{"same time, higher ID", at, "z", false},
+		{"the cursor's own post", at, "m", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.before(tt.createdAt, tt.id); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestListFeedPaging walks whole feeds a page at a time, on both the SQL and
+// the in-memory store, and checks every post is seen exactly once and in
+// order, including posts created at the same instant.
+func TestListFeedPaging(t *testing.T) {
+	stores := map[string]func(t *testing.T) *Storage{
+		"sqlite": func(t *testing.T) *Storage { return openTestStorage(t) },
+		"memory": func(*testing.T) *Storage { return NewMemoryStorage() },
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+
+			var users []*User
+			for i := 0; i < 2; i++ {
+				user := NewUser(fmt.Sprintf("sub-%d", i), false, fmt.Sprintf("user%d", i), "User", fmt.Sprintf("user%d@example.com", i))
+				if err := s.Users.Create(user); err != nil {
+					t.Fatalf("failed to create user: %v", err)
+				}
+				users = append(users, user)
+			}
+
+			// three posts share each instant, so pages split ties
+			base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			var posts []Post
+			for i := 0; i < 12; i++ {
+				post := NewPost(users[i%2].ID, fmt.Sprintf("post %d", i))
+				post.CreatedAt = base.Add(time.Duration(i/3) * time.Minute)
+				post.UpdatedAt = post.CreatedAt
+				if i%4 == 3 {
+					post.Visibility = VisibilityPrivate
+				}
+				if err := s.Posts.CreatePost(post); err != nil {
+					t.Fatalf("failed to create post: %v", err)
+				}
+				posts = append(posts, *post)
+			}
+			sort.Slice(posts, func(i, j int) bool {
+				if !posts[i].CreatedAt.Equal(posts[j].CreatedAt) {
+					return posts[i].CreatedAt.After(posts[j].CreatedAt)
+				}
+				return posts[i].ID > posts[j].ID
+			})
+
+			tests := []struct {
+				name   string
+				query  FeedQuery
+				expect func(Post) bool
+			}{
+				{"signed out", FeedQuery{}, func(p Post) bool {
+					return p.Visibility == VisibilityPublic
+				}},
+				{"as an author", FeedQuery{CurrentUserID: users[1].ID}, func(p Post) bool {
+					return p.Visibility == VisibilityPublic || p.UserID == users[1].ID
+				}},
+				{"one user's posts", FeedQuery{UserID: users[0].ID, CurrentUserID: users[1].ID}, func(p Post) bool {
+					return p.UserID == users[0].ID && p.Visibility == VisibilityPublic
+				}},
+			}
+
+			for _, tt := range tests {
+				for _, limit := range []int{1, 2, 4, 100} {
+					t.Run(fmt.Sprintf("%s/limit %d", tt.name, limit), func(t *testing.T) {
+						var want []string
+						for _, p := range posts {
+							if tt.expect(p) {
+								want = append(want, p.ID)
+							}
+						}
+
+						var got []string
+						query := tt.query
+						query.Limit = limit
+						for pages := 0; ; pages++ {
+							if pages > len(posts) {
+								t.Fatal("paging never ended")
+							}
+							page, err := s.Posts.ListFeed(query)
+							if err != nil {
+								t.Fatalf("failed to list feed: %v", err)
+							}
+							for _, p := range page {
+								got = append(got, p.ID)
+							}
+							if len(page) < limit {
+								break
+							}
+							// round-trip the cursor the way a client does
+							query.After, err = ParseCursor(CursorFor(&page[len(page)-1]).Encode())
+							if err != nil {
+								t.Fatalf("failed to parse cursor: %v", err)
+							}
+						}
+
+						if fmt.Sprint(got) != fmt.Sprint(want) {
+							t.Fatalf("got posts %v, want %v", got, want)
+						}
+					})
+				}
+			}
+		})
+	}
+}
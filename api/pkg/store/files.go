@@ -0,0 +1,178 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Categories of stored files, used to break down storage usage.
+const (
+	FileCategoryUpload         = "file"
+	FileCategoryPostMedia      = "post_media"
+	FileCategoryProfilePicture = "profile_picture"
+)
+
+// File records who uploaded an object and how big it is. Rows for the
+// /files API are what authorization checks go by, and the rows of every
+// category add up to the user's storage usage.
+type File struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"userId"`
+	FileKey     string    `json:"fileKey"`
+	FileName    string    `json:"fileName"`
+	ContentType string    `json:"contentType"`
+	FileSize    int64     `json:"fileSize"`
+	Category    string    `json:"category"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// PendingUntil is set while the object may still be uploaded with a
+	// pre-signed policy; until then FileSize is the declared size.
+	PendingUntil *time.Time `json:"pendingUntil,omitempty"`
+}
+
+type FileStore struct {
+	db dbtx
+}
+
+func NewFile(userID, fileKey, fileName, contentType string, fileSize int64, category string) *File {
+	return &File{
+		UserID:      userID,
+		FileKey:     fileKey,
+		FileName:    fileName,
+		ContentType: contentType,
+		FileSize:    fileSize,
+		Category:    category,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+// Save records file, replacing the name, size, content type, category and
+// pending state of an existing row for the same key. The owner of an
+// existing row is never changed.
+func (s *FileStore) Save(file *File) error {
+	const q = `
+		INSERT INTO files (user_id, file_key, file_name, content_type, file_size, category, created_at, pending_until)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (file_key) DO UPDATE
+		SET file_name = excluded.file_name,
+		    content_type = excluded.content_type,
+		    file_size = excluded.file_size,
+		    category = excluded.category,
+		    pending_until = excluded.pending_until
+		RETURNING id, user_id;
+	`
+	return s.db.QueryRow(
+		q,
+		file.UserID,
+		file.FileKey,
+		file.FileName,
+		file.ContentType,
+		file.FileSize,
+		file.Category,
+		file.CreatedAt,
+		file.PendingUntil,
+	).Scan(&file.ID, &file.UserID)
+}
+
+func (s *FileStore) GetByKey(fileKey string) (*File, error) {
+	const q = `
+		SELECT id, user_id, file_key, file_name, content_type, file_size, category, created_at, pending_until
+		FROM files
+		WHERE file_key = ?
+	`
+	f, err := scanFile(s.db.QueryRow(q, fileKey))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ListPending returns the files whose pre-signed upload expired before
+// cutoff.
+func (s *FileStore) ListPending(cutoff time.Time) ([]File, error) {
+	const q = `
+		SELECT id, user_id, file_key, file_name, content_type, file_size, category, created_at, pending_until
+		FROM files
+		WHERE pending_until IS NOT NULL AND pending_until < ?
+	`
+	rows, err := s.db.Query(q, cutoff.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *f)
+	}
+	return files, rows.Err()
+}
+
+// SetUploaded records the size of the object a pending file turned out to
+// be and stops treating it as pending.
+func (s *FileStore) SetUploaded(fileKey string, size int64) error {
+	const q = `UPDATE files SET file_size = ?, pending_until = NULL WHERE file_key = ?`
+	_, err := s.db.Exec(q, size, fileKey)
+	return err
+}
+
+func scanFile(row interface{ Scan(...any) error }) (*File, error) {
+	f := new(File)
+	var pendingUntil sql.NullTime
+	err := row.Scan(
+		&f.ID,
+		&f.UserID,
+		&f.FileKey,
+		&f.FileName,
+		&f.ContentType,
+		&f.FileSize,
+		&f.Category,
+		&f.CreatedAt,
+		&pendingUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if pendingUntil.Valid {
+		f.PendingUntil = &pendingUntil.Time
+	}
+	return f, nil
+}
+
+func (s *FileStore) Delete(fileKey string) error {
+	const q = `DELETE FROM files WHERE file_key = ?`
+	_, err := s.db.Exec(q, fileKey)
+	return err
+}
+
+// Usage returns the bytes stored by userID, by category.
+func (s *FileStore) Usage(userID string) (map[string]int64, error) {
+	const q = `
+		SELECT category, COALESCE(SUM(file_size), 0)
+		FROM files
+		WHERE user_id = ?
+		GROUP BY category
+	`
+	rows, err := s.db.Query(q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[string]int64)
+	for rows.Next() {
+		var category string
+		var size int64
+		if err := rows.Scan(&category, &size); err != nil {
+			return nil, err
+		}
+		usage[category] = size
+	}
+	return usage, rows.Err()
+}
@@ -0,0 +1,308 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MediaObject is a stored post media file, shared by every post_media row
+// with the same content hash and visibility. RefCount counts those rows; an
+// object with no references is either still waiting to be attached to a post
+// or can be removed from the bucket. Private objects are the copies used by
+// posts that aren't public, kept under a prefix the CDN doesn't serve.
+type MediaObject struct {
+	FileKey     string    `json:"fileKey"`
+	ContentHash string    `json:"contentHash"`
+	Private     bool      `json:"private"`
+	MimeType    string    `json:"mimeType"`
+	FileSize    int64     `json:"fileSize"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	BlurHash    string    `json:"blurhash,omitempty"`
+	RefCount    int       `json:"refCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	VideoMetadata
+}
+
+// MediaVariant is a resized copy of an image, stored next to the original
+// under its own key.
+type MediaVariant struct {
+	SourceKey string `json:"-"`
+	FileKey   string `json:"fileKey"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	MimeType  string `json:"mimeType"`
+	FileSize  int64  `json:"fileSize"`
+}
+
+// VideoMetadata is read from a video's container headers when it is
+// uploaded.
+type VideoMetadata struct {
+	DurationMs int64  `json:"durationMs,omitempty"`
+	VideoCodec string `json:"videoCodec,omitempty"`
+	AudioCodec string `json:"audioCodec,omitempty"`
+	HasAudio   bool   `json:"hasAudio,omitempty"`
+}
+
+type MediaStore struct {
+	db dbtx
+}
+
+func NewMediaObject(fileKey, contentHash, mimeType string, fileSize int64) *MediaObject {
+	return &MediaObject{
+		FileKey:     fileKey,
+		ContentHash: contentHash,
+		MimeType:    mimeType,
+		FileSize:    fileSize,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+func (s *MediaStore) Create(obj *MediaObject) error {
+	const q = `
+		INSERT INTO media_objects (
+			file_key, content_hash, private, mime_type, file_size, width, height, blurhash,
+			duration_ms, video_codec, audio_codec, has_audio, ref_count, created_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := s.db.Exec(
+		q,
+		obj.FileKey,
+		obj.ContentHash,
+		obj.Private,
+		obj.MimeType,
+		obj.FileSize,
+		nullInt(obj.Width),
+		nullInt(obj.Height),
+		nullString(obj.BlurHash),
+		nullInt64(obj.DurationMs),
+		nullString(obj.VideoCodec),
+		nullString(obj.AudioCodec),
+		obj.HasAudio,
+		obj.RefCount,
+		obj.CreatedAt,
+	)
+	return err
+}
+
+// GetByHash returns the public or private object with contentHash. Media is
+// never shared across visibilities.
+func (s *MediaStore) GetByHash(contentHash string, private bool) (*MediaObject, error) {
+	const q = `
+		SELECT file_key, content_hash, private, mime_type, file_size,
+		       COALESCE(width, 0), COALESCE(height, 0), COALESCE(blurhash, ''),
+		       COALESCE(duration_ms, 0), COALESCE(video_codec, ''), COALESCE(audio_codec, ''), COALESCE(has_audio, 0), ref_count, created_at
+		FROM media_objects
+		WHERE content_hash = ? AND private = ?
+	`
+	return s.scanOne(s.db.QueryRow(q, contentHash, private))
+}
+
+func (s *MediaStore) GetByKey(fileKey string) (*MediaObject, error) {
+	const q = `
+		SELECT file_key, content_hash, private, mime_type, file_size,
+		       COALESCE(width, 0), COALESCE(height, 0), COALESCE(blurhash, ''),
+		       COALESCE(duration_ms, 0), COALESCE(video_codec, ''), COALESCE(audio_codec, ''), COALESCE(has_audio, 0), ref_count, created_at
+		FROM media_objects
+		WHERE file_key = ?
+	`
+	return s.scanOne(s.db.QueryRow(q, fileKey))
+}
+
+// Release drops one reference to fileKey and returns how many are left.
+// Once nothing references the object its row is removed, and the caller is
+// expected to delete the file itself. Keys that were never tracked report
+// zero remaining references.
+func (s *MediaStore) Release(fileKey string) (int, error) {
+	const decrement = `
+		UPDATE media_objects
+		SET ref_count = ref_count - 1
+		WHERE file_key = ? AND ref_count > 0
+		RETURNING ref_count
+	`
+	var remaining int
+	err := s.db.QueryRow(decrement, fileKey).Scan(&remaining)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if remaining > 0 {
+		return remaining, nil
+	}
+
+	const remove = `DELETE FROM media_objects WHERE file_key = ? AND ref_count = 0`
+	if _, err := s.db.Exec(remove, fileKey); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// DeleteUnreferenced removes the row for fileKey unless a post still uses it.
+func (s *MediaStore) DeleteUnreferenced(fileKey string) error {
+	const q = `DELETE FROM media_objects WHERE file_key = ? AND ref_count = 0`
+	_, err := s.db.Exec(q, fileKey)
+	return err
+}
+
+func (s *MediaStore) AddVariants(sourceKey string, variants []MediaVariant) error {
+	const q = `
+		INSERT INTO post_media_variants (source_key, file_key, width, height, mime_type, file_size)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	for _, v := range variants {
+		if _, err := s.db.Exec(q, sourceKey, v.FileKey, v.Width, v.Height, v.MimeType, v.FileSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MediaStore) GetVariants(sourceKey string) ([]MediaVariant, error) {
+	const q = `
+		SELECT source_key, file_key, width, height, mime_type, file_size
+		FROM post_media_variants
+		WHERE source_key = ?
+		ORDER BY width ASC
+	`
+	rows, err := s.db.Query(q, sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []MediaVariant
+	for rows.Next() {
+		var v MediaVariant
+		if err := rows.Scan(&v.SourceKey, &v.FileKey, &v.Width, &v.Height, &v.MimeType, &v.FileSize); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	return variants, rows.Err()
+}
+
+func (s *MediaStore) DeleteVariants(sourceKey string) error {
+	const q = `DELETE FROM post_media_variants WHERE source_key = ?`
+	_, err := s.db.Exec(q, sourceKey)
+	return err
+}
+
+// SetDimensions records the size and placeholder of an image on its media
+// object and on every post that uses it.
+func (s *MediaStore) SetDimensions(fileKey string, width, height int, blurHash string) error {
+	const objects = `UPDATE media_objects SET width = ?, height = ?, blurhash = ? WHERE file_key = ?`
+	if _, err := s.db.Exec(objects, width, height, nullString(blurHash), fileKey); err != nil {
+		return err
+	}
+
+	const posts = `UPDATE post_media SET width = ?, height = ?, blurhash = ? WHERE file_key = ?`
+	_, err := s.db.Exec(posts, width, height, nullString(blurHash), fileKey)
+	return err
+}
+
+// SetVideoMetadata records what was probed from a video on its media object
+// and on every post that uses it.
+func (s *MediaStore) SetVideoMetadata(fileKey string, width, height int, video VideoMetadata) error {
+	args := []any{
+		nullInt(width),
+		nullInt(height),
+		nullInt64(video.DurationMs),
+		nullString(video.VideoCodec),
+		nullString(video.AudioCodec),
+		video.HasAudio,
+		fileKey,
+	}
+
+	const objects = `
+		UPDATE media_objects
+		SET width = ?, height = ?, duration_ms = ?, video_codec = ?, audio_codec = ?, has_audio = ?
+		WHERE file_key = ?
+	`
+	if _, err := s.db.Exec(objects, args...); err != nil {
+		return err
+	}
+
+	const posts = `
+		UPDATE post_media
+		SET width = ?, height = ?, duration_ms = ?, video_codec = ?, audio_codec = ?, has_audio = ?
+		WHERE file_key = ?
+	`
+	_, err := s.db.Exec(posts, args...)
+	return err
+}
+
+// ListMissingDimensions returns the keys of post images that were stored
+// before dimensions were recorded.
+func (s *MediaStore) ListMissingDimensions() ([]string, error) {
+	const q = `
+		SELECT DISTINCT file_key
+		FROM post_media
+		WHERE media_type = 'image' AND width IS NULL
+	`
+	rows, err := s.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *MediaStore) scanOne(row *sql.Row) (*MediaObject, error) {
+	obj := &MediaObject{}
+	err := row.Scan(
+		&obj.FileKey,
+		&obj.ContentHash,
+		&obj.Private,
+		&obj.MimeType,
+		&obj.FileSize,
+		&obj.Width,
+		&obj.Height,
+		&obj.BlurHash,
+		&obj.DurationMs,
+		&obj.VideoCodec,
+		&obj.AudioCodec,
+		&obj.HasAudio,
+		&obj.RefCount,
+		&obj.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nullInt64 stores zero as NULL, for columns where zero means unknown.
+func nullInt64(v int64) *int64 {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
+
+// nullInt stores zero as NULL, for columns where zero means unknown.
+func nullInt(v int) *int {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
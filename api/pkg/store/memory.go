@@ -0,0 +1,894 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// NewMemoryStorage returns a Storage backed by plain maps instead of libsql.
+// It mirrors the behaviour of the SQL stores closely enough for handler
+// tests, including the nil-without-error result for missing rows.
+func NewMemoryStorage() *Storage {
+	db := &memoryDB{memoryTables: memoryTables{
+		users:        make(map[string]*User),
+		posts:        make(map[string]*Post),
+		media:        make(map[string]*PostMedia),
+		likes:        make(map[string]map[string]time.Time),
+		mediaObjects: make(map[string]*MediaObject),
+		variants:     make(map[string][]MediaVariant),
+		files:        make(map[string]*File),
+		uploads:      make(map[string]*UploadSession),
+		trash:        make(map[string]*TrashedObject),
+	}}
+
+	return newMemoryStorage(db)
+}
+
+func newMemoryStorage(db *memoryDB) *Storage {
+	return &Storage{
+		memory:  db,
+		Users:   &MemoryUserStore{db: db},
+		Posts:   &MemoryPostStore{db: db},
+		Media:   &MemoryMediaStore{db: db},
+		Files:   &MemoryFileStore{db: db},
+		Uploads: &MemoryUploadStore{db: db},
+		Trash:   &MemoryTrashStore{db: db},
+	}
+}
+
+type memoryDB struct {
+	mu sync.RWMutex
+	// held for the whole of a WithTx call
+	txMu sync.Mutex
+	memoryTables
+}
+
+type memoryTables struct {
+	users map[string]*User
+	posts map[string]*Post
+	media map[string]*PostMedia
+	// post ID -> user ID -> liked at
+	likes map[string]map[string]time.Time
+	// file key -> object
+	mediaObjects map[string]*MediaObject
+	// source file key -> resized copies
+	variants map[string][]MediaVariant
+	// file key -> /files upload
+	files map[string]*File
+	// session ID -> direct upload
+	uploads map[string]*UploadSession
+	// ID -> deleted object
+	trash map[string]*TrashedObject
+}
+
+func newMemoryID() string {
+	return uuid.Must(uuid.NewV4()).String()
+}
+
+// withTx runs fn against db and puts every table back the way it was if fn
+// fails. Transactions are serialized, but other callers can see their
+// writes before they finish, and a rollback also undoes anything written
+// meanwhile outside a transaction, which is fine for handler tests.
+func (db *memoryDB) withTx(fn func(*Storage) error) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	db.mu.RLock()
+	saved := db.memoryTables.clone()
+	db.mu.RUnlock()
+
+	tx := newMemoryStorage(db)
+	tx.inTx = true
+	if err := fn(tx); err != nil {
+		db.mu.Lock()
+		db.memoryTables = saved
+		db.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (t memoryTables) clone() memoryTables {
+	c := memoryTables{
+		users:        cloneRows(t.users),
+		posts:        cloneRows(t.posts),
+		media:        cloneRows(t.media),
+		likes:        make(map[string]map[string]time.Time, len(t.likes)),
+		mediaObjects: cloneRows(t.mediaObjects),
+		variants:     make(map[string][]MediaVariant, len(t.variants)),
+		files:        cloneRows(t.files),
+		uploads:      cloneRows(t.uploads),
+		trash:        cloneRows(t.trash),
+	}
+	for postID, likes := range t.likes {
+		c.likes[postID] = make(map[string]time.Time, len(likes))
+		for userID, at := range likes {
+			c.likes[postID][userID] = at
+		}
+	}
+	for key, variants := range t.variants {
+		c.variants[key] = append([]MediaVariant(nil), variants...)
+	}
+	return c
+}
+
+// cloneRows copies each row, since the stores update rows in place.
+func cloneRows[T any](rows map[string]*T) map[string]*T {
+	c := make(map[string]*T, len(rows))
+	for key, row := range rows {
+		copied := *row
+		c[key] = &copied
+	}
+	return c
+}
+
+type MemoryUserStore struct {
+	db *memoryDB
+}
+
+func (s *MemoryUserStore) Create(user *User) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, existing := range s.db.users {
+		if existing.Sub == user.Sub {
+			return fmt.Errorf("UNIQUE constraint failed: users.sub")
+		}
+		if existing.UserName == user.UserName {
+			return fmt.Errorf("UNIQUE constraint failed: users.username")
+		}
+	}
+
+	user.ID = newMemoryID()
+	stored := *user
+	s.db.users[user.ID] = &stored
+	return nil
+}
+
+func (s *MemoryUserStore) GetBySub(sub string) (*User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, u := range s.db.users {
+		if u.Sub == sub {
+			found := *u
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryUserStore) GetByID(userID string) (*User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	u, ok := s.db.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	found := *u
+	return &found, nil
+}
+
+func (s *MemoryUserStore) UsernameExists(username string) (bool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, u := range s.db.users {
+		if u.UserName == username {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryUserStore) UpdateUserName(userID, username string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for id, u := range s.db.users {
+		if id != userID && u.UserName == username {
+			return fmt.Errorf("UNIQUE constraint failed: users.username")
+		}
+	}
+	if u, ok := s.db.users[userID]; ok {
+		u.UserName = username
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateProfilePicture(userID string, profilePictureKey *string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if u, ok := s.db.users[userID]; ok {
+		if profilePictureKey == nil {
+			u.ProfilePictureKey = nil
+		} else {
+			key := *profilePictureKey
+			u.ProfilePictureKey = &key
+		}
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) SetStorageQuota(userID string, quota *int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if u, ok := s.db.users[userID]; ok {
+		if quota == nil {
+			u.StorageQuota = nil
+		} else {
+			q := *quota
+			u.StorageQuota = &q
+		}
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) ListProfilePictureKeys() ([]string, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var keys []string
+	for _, u := range s.db.users {
+		if u.ProfilePictureKey != nil {
+			keys = append(keys, *u.ProfilePictureKey)
+		}
+	}
+	return keys, nil
+}
+
+type MemoryPostStore struct {
+	db *memoryDB
+}
+
+func (s *MemoryPostStore) CreatePost(post *Post) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[post.UserID]; !ok {
+		return fmt.Errorf("FOREIGN KEY constraint failed")
+	}
+
+	post.ID = newMemoryID()
+	s.db.posts[post.ID] = &Post{
+		ID:         post.ID,
+		UserID:     post.UserID,
+		Body:       post.Body,
+		Visibility: post.Visibility,
+		CreatedAt:  post.CreatedAt,
+		UpdatedAt:  post.UpdatedAt,
+	}
+	return nil
+}
+
+func (s *MemoryPostStore) AddMediaToPost(postID string, media []PostMedia) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.posts[postID]; !ok {
+		return fmt.Errorf("FOREIGN KEY constraint failed")
+	}
+
+	for i := range media {
+		media[i].ID = newMemoryID()
+		stored := media[i]
+		stored.PostID = postID
+		s.db.media[stored.ID] = &stored
+
+		if obj, ok := s.db.mediaObjects[stored.FileKey]; ok {
+			obj.RefCount++
+		}
+	}
+	return nil
+}
+
+func (s *MemoryPostStore) GetPostByID(postID string) (*Post, error) {
+	return s.GetPostByIDWithUserContext(postID, "")
+}
+
+func (s *MemoryPostStore) GetPostByIDWithUserContext(postID, currentUserID string) (*Post, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	p, ok := s.db.posts[postID]
+	if !ok || p.DeletedAt != nil {
+		return nil, nil
+	}
+	post := s.hydrate(p, currentUserID)
+	return &post, nil
+}
+
+func (s *MemoryPostStore) ListFeed(q FeedQuery) ([]Post, error) {
+	offset := q.Offset
+	if q.After != nil {
+		offset = 0
+	}
+	return s.list(func(p *Post) bool {
+		if q.UserID != "" && p.UserID != q.UserID {
+			return false
+		}
+		if q.After != nil && !q.After.before(p.CreatedAt, p.ID) {
+			return false
+		}
+		return p.DeletedAt == nil && visibleInList(p, q.CurrentUserID)
+	}, q.Limit, offset, q.CurrentUserID), nil
+}
+
+func (s *MemoryPostStore) DeletePost(postID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if p, ok := s.db.posts[postID]; ok && p.DeletedAt == nil {
+		now := time.Now().UTC()
+		p.DeletedAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryPostStore) RestorePost(postID string) (bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	p, ok := s.db.posts[postID]
+	if !ok || p.DeletedAt == nil {
+		return false, nil
+	}
+	p.DeletedAt = nil
+	return true, nil
+}
+
+func (s *MemoryPostStore) ListDeletedPosts(limit, offset int) ([]Post, error) {
+	posts := s.deleted(func(*Post) bool { return true })
+	// most recently deleted first, like the SQL store
+	for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+		posts[i], posts[j] = posts[j], posts[i]
+	}
+	if offset >= len(posts) {
+		return nil, nil
+	}
+	posts = posts[offset:]
+	if limit < len(posts) {
+		posts = posts[:limit]
+	}
+	return posts, nil
+}
+
+func (s *MemoryPostStore) ListPostsDeletedBefore(cutoff time.Time) ([]Post, error) {
+	return s.deleted(func(p *Post) bool { return p.DeletedAt.Before(cutoff) }), nil
+}
+
+func (s *MemoryPostStore) PurgePost(postID string) (bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	p, ok := s.db.posts[postID]
+	if !ok || p.DeletedAt == nil {
+		return false, nil
+	}
+	delete(s.db.posts, postID)
+	delete(s.db.likes, postID)
+	for id, m := range s.db.media {
+		if m.PostID == postID {
+			delete(s.db.media, id)
+		}
+	}
+	return true, nil
+}
+
+// deleted returns the deleted posts matching keep, oldest deletion first.
+func (s *MemoryPostStore) deleted(keep func(*Post) bool) []Post {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var posts []Post
+	for _, p := range s.db.posts {
+		if p.DeletedAt != nil && keep(p) {
+			posts = append(posts, s.hydrate(p, ""))
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].DeletedAt.Before(*posts[j].DeletedAt)
+	})
+	return posts
+}
+
+func (s *MemoryPostStore) GetPostMediaByID(mediaID string) (*PostMedia, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	m, ok := s.db.media[mediaID]
+	if !ok {
+		return nil, nil
+	}
+	found := *m
+	return &found, nil
+}
+
+func (s *MemoryPostStore) ToggleLike(postID, userID string) (bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	likes, ok := s.db.likes[postID]
+	if !ok {
+		likes = make(map[string]time.Time)
+		s.db.likes[postID] = likes
+	}
+
+	if _, liked := likes[userID]; liked {
+		delete(likes, userID)
+		return false, nil
+	}
+	likes[userID] = time.Now().UTC()
+	return true, nil
+}
+
+func (s *MemoryPostStore) GetLikeCount(postID string) (int, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return len(s.db.likes[postID]), nil
+}
+
+func (s *MemoryPostStore) IsLikedByUser(postID, userID string) (bool, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	_, liked := s.db.likes[postID][userID]
+	return liked, nil
+}
+
+func (s *MemoryPostStore) ListMediaFileKeys() ([]string, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range s.db.media {
+		if seen[m.FileKey] {
+			continue
+		}
+		seen[m.FileKey] = true
+		keys = append(keys, m.FileKey)
+		for _, v := range s.db.variants[m.FileKey] {
+			keys = append(keys, v.FileKey)
+		}
+	}
+	return keys, nil
+}
+
+func visibleInList(p *Post, currentUserID string) bool {
+	return p.Visibility == VisibilityPublic || (currentUserID != "" && p.UserID == currentUserID)
+}
+
+func (s *MemoryPostStore) list(match func(*Post) bool, limit, offset int, currentUserID string) []Post {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var matched []*Post
+	for _, p := range s.db.posts {
+		if match(p) {
+			matched = append(matched, p)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	var posts []Post
+	for i := offset; i < len(matched) && len(posts) < limit; i++ {
+		posts = append(posts, s.hydrate(matched[i], currentUserID))
+	}
+	return posts
+}
+
+// hydrate must be called with the read lock held.
+func (s *MemoryPostStore) hydrate(p *Post, currentUserID string) Post {
+	post := *p
+
+	if u, ok := s.db.users[p.UserID]; ok {
+		user := *u
+		post.User = &user
+	}
+
+	post.Media = nil
+	for _, m := range s.db.media {
+		if m.PostID == p.ID {
+			found := *m
+			found.Variants = append([]MediaVariant(nil), s.db.variants[m.FileKey]...)
+			post.Media = append(post.Media, found)
+		}
+	}
+	sort.Slice(post.Media, func(i, j int) bool {
+		return post.Media[i].CreatedAt.Before(post.Media[j].CreatedAt)
+	})
+
+	post.LikeCount = len(s.db.likes[p.ID])
+	_, post.IsLikedByMe = s.db.likes[p.ID][currentUserID]
+
+	return post
+}
+
+type MemoryMediaStore struct {
+	db *memoryDB
+}
+
+func (s *MemoryMediaStore) Create(obj *MediaObject) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for key, existing := range s.db.mediaObjects {
+		if key == obj.FileKey {
+			return fmt.Errorf("UNIQUE constraint failed: media_objects.file_key")
+		}
+		if existing.ContentHash == obj.ContentHash && existing.Private == obj.Private {
+			return fmt.Errorf("UNIQUE constraint failed: media_objects.content_hash, media_objects.private")
+		}
+	}
+
+	stored := *obj
+	s.db.mediaObjects[obj.FileKey] = &stored
+	return nil
+}
+
+func (s *MemoryMediaStore) GetByHash(contentHash string, private bool) (*MediaObject, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, obj := range s.db.mediaObjects {
+		if obj.ContentHash == contentHash && obj.Private == private {
+			found := *obj
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryMediaStore) GetByKey(fileKey string) (*MediaObject, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	obj, ok := s.db.mediaObjects[fileKey]
+	if !ok {
+		return nil, nil
+	}
+	found := *obj
+	return &found, nil
+}
+
+func (s *MemoryMediaStore) Release(fileKey string) (int, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	obj, ok := s.db.mediaObjects[fileKey]
+	if !ok {
+		return 0, nil
+	}
+	if obj.RefCount > 0 {
+		obj.RefCount--
+	}
+	if obj.RefCount == 0 {
+		delete(s.db.mediaObjects, fileKey)
+	}
+	return obj.RefCount, nil
+}
+
+func (s *MemoryMediaStore) DeleteUnreferenced(fileKey string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if obj, ok := s.db.mediaObjects[fileKey]; ok && obj.RefCount == 0 {
+		delete(s.db.mediaObjects, fileKey)
+	}
+	return nil
+}
+
+func (s *MemoryMediaStore) AddVariants(sourceKey string, variants []MediaVariant) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, v := range variants {
+		v.SourceKey = sourceKey
+		s.db.variants[sourceKey] = append(s.db.variants[sourceKey], v)
+	}
+	sort.Slice(s.db.variants[sourceKey], func(i, j int) bool {
+		return s.db.variants[sourceKey][i].Width < s.db.variants[sourceKey][j].Width
+	})
+	return nil
+}
+
+func (s *MemoryMediaStore) GetVariants(sourceKey string) ([]MediaVariant, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return append([]MediaVariant(nil), s.db.variants[sourceKey]...), nil
+}
+
+func (s *MemoryMediaStore) DeleteVariants(sourceKey string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.variants, sourceKey)
+	return nil
+}
+
+func (s *MemoryMediaStore) SetDimensions(fileKey string, width, height int, blurHash string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if obj, ok := s.db.mediaObjects[fileKey]; ok {
+		obj.Width, obj.Height, obj.BlurHash = width, height, blurHash
+	}
+	for _, m := range s.db.media {
+		if m.FileKey == fileKey {
+			m.Width, m.Height, m.BlurHash = width, height, blurHash
+		}
+	}
+	return nil
+}
+
+func (s *MemoryMediaStore) SetVideoMetadata(fileKey string, width, height int, video VideoMetadata) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if obj, ok := s.db.mediaObjects[fileKey]; ok {
+		obj.Width, obj.Height, obj.VideoMetadata = width, height, video
+	}
+	for _, m := range s.db.media {
+		if m.FileKey == fileKey {
+			m.Width, m.Height, m.VideoMetadata = width, height, video
+		}
+	}
+	return nil
+}
+
+func (s *MemoryMediaStore) ListMissingDimensions() ([]string, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range s.db.media {
+		if m.MediaType == "image" && m.Width == 0 && !seen[m.FileKey] {
+			seen[m.FileKey] = true
+			keys = append(keys, m.FileKey)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+type MemoryFileStore struct {
+	db *memoryDB
+}
+
+func (s *MemoryFileStore) Save(file *File) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if existing, ok := s.db.files[file.FileKey]; ok {
+		existing.FileName = file.FileName
+		existing.ContentType = file.ContentType
+		existing.FileSize = file.FileSize
+		existing.Category = file.Category
+		existing.PendingUntil = file.PendingUntil
+		file.ID = existing.ID
+		file.UserID = existing.UserID
+		return nil
+	}
+
+	if _, ok := s.db.users[file.UserID]; !ok {
+		return fmt.Errorf("FOREIGN KEY constraint failed")
+	}
+
+	file.ID = newMemoryID()
+	stored := *file
+	s.db.files[file.FileKey] = &stored
+	return nil
+}
+
+func (s *MemoryFileStore) GetByKey(fileKey string) (*File, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	f, ok := s.db.files[fileKey]
+	if !ok {
+		return nil, nil
+	}
+	found := *f
+	return &found, nil
+}
+
+func (s *MemoryFileStore) Delete(fileKey string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.files, fileKey)
+	return nil
+}
+
+func (s *MemoryFileStore) Usage(userID string) (map[string]int64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	usage := make(map[string]int64)
+	for _, f := range s.db.files {
+		if f.UserID == userID {
+			usage[f.Category] += f.FileSize
+		}
+	}
+	return usage, nil
+}
+
+func (s *MemoryFileStore) ListPending(cutoff time.Time) ([]File, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var files []File
+	for _, f := range s.db.files {
+		if f.PendingUntil != nil && f.PendingUntil.Before(cutoff) {
+			files = append(files, *f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileKey < files[j].FileKey })
+	return files, nil
+}
+
+func (s *MemoryFileStore) SetUploaded(fileKey string, size int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if f, ok := s.db.files[fileKey]; ok {
+		f.FileSize = size
+		f.PendingUntil = nil
+	}
+	return nil
+}
+
+type MemoryUploadStore struct {
+	db *memoryDB
+}
+
+func (s *MemoryUploadStore) Create(session *UploadSession) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[session.UserID]; !ok {
+		return fmt.Errorf("FOREIGN KEY constraint failed")
+	}
+
+	session.ID = newMemoryID()
+	stored := *session
+	s.db.uploads[session.ID] = &stored
+	return nil
+}
+
+func (s *MemoryUploadStore) GetByID(id string) (*UploadSession, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	session, ok := s.db.uploads[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *session
+	return &found, nil
+}
+
+func (s *MemoryUploadStore) Finalize(id, fileKey string) (bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	session, ok := s.db.uploads[id]
+	if !ok || session.Status != UploadPending {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	session.Status = UploadFinalized
+	session.FileKey = fileKey
+	session.FinalizedAt = &now
+	return true, nil
+}
+
+func (s *MemoryUploadStore) DeleteExpired(cutoff time.Time) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var n int64
+	for id, session := range s.db.uploads {
+		if session.ExpiresAt.Before(cutoff) {
+			delete(s.db.uploads, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+type MemoryTrashStore struct {
+	db *memoryDB
+}
+
+func (s *MemoryTrashStore) Create(obj *TrashedObject) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, existing := range s.db.trash {
+		if existing.TrashKey == obj.TrashKey {
+			return fmt.Errorf("UNIQUE constraint failed: trashed_objects.trash_key")
+		}
+	}
+
+	obj.ID = newMemoryID()
+	stored := *obj
+	s.db.trash[obj.ID] = &stored
+	return nil
+}
+
+func (s *MemoryTrashStore) GetByID(id string) (*TrashedObject, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	obj, ok := s.db.trash[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *obj
+	return &found, nil
+}
+
+func (s *MemoryTrashStore) List(limit, offset int) ([]TrashedObject, error) {
+	objects := s.sorted(func(*TrashedObject) bool { return true })
+	// newest first, like the SQL store
+	for i, j := 0, len(objects)-1; i < j; i, j = i+1, j-1 {
+		objects[i], objects[j] = objects[j], objects[i]
+	}
+	if offset >= len(objects) {
+		return nil, nil
+	}
+	objects = objects[offset:]
+	if limit < len(objects) {
+		objects = objects[:limit]
+	}
+	return objects, nil
+}
+
+func (s *MemoryTrashStore) ListDeletedBefore(cutoff time.Time) ([]TrashedObject, error) {
+	return s.sorted(func(obj *TrashedObject) bool { return obj.DeletedAt.Before(cutoff) }), nil
+}
+
+func (s *MemoryTrashStore) Delete(id string) (bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.trash[id]; !ok {
+		return false, nil
+	}
+	delete(s.db.trash, id)
+	return true, nil
+}
+
+// sorted returns the objects matching keep, oldest deletion first.
+func (s *MemoryTrashStore) sorted(keep func(*TrashedObject) bool) []TrashedObject {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var objects []TrashedObject
+	for _, obj := range s.db.trash {
+		if keep(obj) {
+			objects = append(objects, *obj)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].DeletedAt.Before(objects[j].DeletedAt)
+	})
+	return objects
+}
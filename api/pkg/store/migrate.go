@@ -0,0 +1,403 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migration/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)-(.+)-(up|down)\.sql$`)
+
+var (
+	// ErrFutureSchema means the database has migrations applied that this
+	// binary doesn't know about, most likely by a newer release.
+	ErrFutureSchema = errors.New("database schema is newer than this build")
+	// ErrUntrackedSchema means the database has tables but no migration
+	// history, so the runner can't tell which migrations it already has.
+	ErrUntrackedSchema = errors.New("database has tables but no migration history")
+)
+
+// Migration is a numbered pair of SQL scripts from pkg/store/migration.
+// Checksum is taken over the up script, which is what was applied.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus is a migration and whether it has been applied. A
+// migration that is applied but not embedded in the binary has no Name.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	Modified  bool       `json:"modified,omitempty"`
+}
+
+// Migrator applies the migrations embedded in the binary and records them
+// in schema_migrations. Each migration runs in its own transaction together
+// with the row recording it, with foreign keys checked once it is done
+// rather than enforced while it runs.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "migration")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		data, err := fs.ReadFile(files, path.Join("migration", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %04d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			sum := sha256.Sum256(data)
+			m.Up = string(data)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d-%s needs both an up and a down script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %04d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Latest is the version the embedded migrations bring the schema to.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Check verifies that every applied migration is one of the embedded ones
+// and hasn't been edited since. It returns ErrFutureSchema if the database
+// is ahead of the binary and ErrUntrackedSchema if it has tables that were
+// never recorded.
+func (m *Migrator) Check() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		untracked, err := m.hasTables()
+		if err != nil {
+			return err
+		}
+		if untracked {
+			return fmt.Errorf("%w; run `migrate baseline <version>` with the last migration it has", ErrUntrackedSchema)
+		}
+		return nil
+	}
+
+	for version := range applied {
+		if version > m.Latest() {
+			return fmt.Errorf("%w: migration %04d is applied but the latest known is %04d", ErrFutureSchema, version, m.Latest())
+		}
+	}
+	for version := 1; version <= len(applied); version++ {
+		a, ok := applied[version]
+		if !ok {
+			return fmt.Errorf("migration %04d was skipped", version)
+		}
+		migration := m.migrations[version-1]
+		if a.checksum != migration.Checksum {
+			return fmt.Errorf("migration %04d-%s was changed after it was applied", version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// Up applies every pending migration in order and returns the ones it
+// applied. It stops at the first one that fails, leaving that one unapplied.
+func (m *Migrator) Up() ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations[len(applied):] {
+		err := m.inTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(migration.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(
+				`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+				migration.Version, migration.Name, migration.Checksum, time.Now().UTC(),
+			)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("failed to apply migration %04d-%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// the ones it reverted.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for version := len(applied); version > 0 && len(done) < steps; version-- {
+		migration := m.migrations[version-1]
+		err := m.inTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(migration.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, migration.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("failed to revert migration %04d-%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Baseline records migrations 1 to version as applied without running them,
+// for databases whose schema was set up by hand before migrations were
+// tracked.
+func (m *Migrator) Baseline(version int) error {
+	if version < 1 || version > m.Latest() {
+		return fmt.Errorf("version must be between 1 and %d", m.Latest())
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		return fmt.Errorf("database already has a migration history")
+	}
+
+	return m.inTx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		for _, migration := range m.migrations[:version] {
+			_, err := tx.Exec(
+				`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+				migration.Version, migration.Name, migration.Checksum, now,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to record migration %04d: %w", migration.Version, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Status lists every embedded migration and any applied ones that aren't.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if a, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &a.appliedAt
+			status.Modified = a.checksum != migration.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+	for version, a := range applied {
+		if version > m.Latest() {
+			statuses = append(statuses, MigrationStatus{Version: version, AppliedAt: &a.appliedAt})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+func (m *Migrator) applied() (map[int]appliedMigration, error) {
+	const create = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		  version     INTEGER    PRIMARY KEY,
+		  name        TEXT       NOT NULL,
+		  checksum    TEXT       NOT NULL,
+		  applied_at  TIMESTAMP  NOT NULL
+		)
+	`
+	if _, err := m.db.Exec(create); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	rows, err := m.db.Query(`SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// hasTables reports whether the database has any tables besides the
+// migration history.
+func (m *Migrator) hasTables() (bool, error) {
+	const q = `
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table'
+		  AND name NOT LIKE 'sqlite_%'
+		  AND name NOT LIKE 'libsql_%'
+		  AND name <> 'schema_migrations'
+	`
+	var n int
+	if err := m.db.QueryRow(q).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to list tables: %w", err)
+	}
+	return n > 0, nil
+}
+
+// inTx runs fn in a transaction with foreign key enforcement off, so a
+// migration that rebuilds a table doesn't cascade its DROP TABLE into the
+// tables referencing it. SQLite ignores the pragma inside a transaction, so
+// it is set on the connection first, and the keys are checked before the
+// commit instead.
+func (m *Migrator) inTx(fn func(*sql.Tx) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return fmt.Errorf("failed to disable foreign keys: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`); err != nil {
+			// the connection goes back to the pool, so it can't be kept
+			// without enforcement
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := checkForeignKeys(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkForeignKeys fails if any row references one that doesn't exist.
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	var violations []string
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return fmt.Errorf("failed to check foreign keys: %w", err)
+		}
+		violations = append(violations, fmt.Sprintf("%s row %d references a missing %s", table, rowID.Int64, parent))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("foreign key check failed: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
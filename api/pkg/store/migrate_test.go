@@ -0,0 +1,332 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// newTestMigrator opens an empty in-memory database and a migrator for it.
+func newTestMigrator(t *testing.T) (*Migrator, *sql.DB) {
+	t.Helper()
+
+	db, err := openSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	return m, db
+}
+
+// openTestStorage opens an in-memory SQLite database at the latest schema.
+func openTestStorage(tb testing.TB) *Storage {
+	tb.Helper()
+
+	s, err := NewUserStore(":memory:", nil)
+	if err != nil {
+		tb.Fatalf("failed to open database: %v", err)
+	}
+	tb.Cleanup(func() { s.db.Close() })
+
+	m, err := s.Migrator()
+	if err != nil {
+		tb.Fatalf("failed to load migrations: %v", err)
+	}
+	if _, err := m.Up(); err != nil {
+		tb.Fatalf("failed to migrate: %v", err)
+	}
+	return s
+}
+
+func mustExec(t *testing.T, db *sql.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatalf("failed to run %q: %v", query, err)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"complete", fstest.MapFS{
+			"migration/0001-a-up.sql":   file("CREATE TABLE a (id INTEGER);"),
+			"migration/0001-a-down.sql": file("DROP TABLE a;"),
+			"migration/0002-b-up.sql":   file("CREATE TABLE b (id INTEGER);"),
+			"migration/0002-b-down.sql": file("DROP TABLE b;"),
+		}, ""},
+		{"missing down script", fstest.MapFS{
+			"migration/0001-a-up.sql": file("CREATE TABLE a (id INTEGER);"),
+		}, "needs both an up and a down script"},
+		{"gap in versions", fstest.MapFS{
+			"migration/0001-a-up.sql":   file("CREATE TABLE a (id INTEGER);"),
+			"migration/0001-a-down.sql": file("DROP TABLE a;"),
+			"migration/0003-c-up.sql":   file("CREATE TABLE c (id INTEGER);"),
+			"migration/0003-c-down.sql": file("DROP TABLE c;"),
+		}, "migration 0002 is missing"},
+		{"two names for a version", fstest.MapFS{
+			"migration/0001-a-up.sql":   file("CREATE TABLE a (id INTEGER);"),
+			"migration/0001-b-down.sql": file("DROP TABLE a;"),
+		}, "has two names"},
+		{"stray file", fstest.MapFS{
+			"migration/README.md": file("notes"),
+		}, "unexpected migration file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("failed to load: %v", err)
+				}
+				if len(migrations) != 2 || migrations[0].Name != "a" || migrations[1].Version != 2 {
+					t.Fatalf("got migrations %+v, want a and b in order", migrations)
+				}
+				if migrations[0].Checksum == "" || migrations[0].Checksum == migrations[1].Checksum {
+					t.Fatalf("got checksums %q and %q, want distinct ones", migrations[0].Checksum, migrations[1].Checksum)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigratorCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, m *Migrator, db *sql.DB)
+		is    error
+		want  string
+	}{
+		{"empty database", func(t *testing.T, m *Migrator, db *sql.DB) {}, nil, ""},
+		{"up to date", func(t *testing.T, m *Migrator, db *sql.DB) {
+			if _, err := m.Up(); err != nil {
+				t.Fatalf("failed to migrate: %v", err)
+			}
+		}, nil, ""},
+		{"tables without history", func(t *testing.T, m *Migrator, db *sql.DB) {
+			mustExec(t, db, `CREATE TABLE users (id TEXT PRIMARY KEY)`)
+		}, ErrUntrackedSchema, ""},
+		{"newer than the build", func(t *testing.T, m *Migrator, db *sql.DB) {
+			if _, err := m.Up(); err != nil {
+				t.Fatalf("failed to migrate: %v", err)
+			}
+			mustExec(t, db, `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, 'future', '', CURRENT_TIMESTAMP)`, m.Latest()+1)
+		}, ErrFutureSchema, ""},
+		{"edited after it was applied", func(t *testing.T, m *Migrator, db *sql.DB) {
+			if _, err := m.Up(); err != nil {
+				t.Fatalf("failed to migrate: %v", err)
+			}
+			mustExec(t, db, `UPDATE schema_migrations SET checksum = 'edited' WHERE version = 3`)
+		}, nil, "migration 0003-posts was changed after it was applied"},
+		{"skipped", func(t *testing.T, m *Migrator, db *sql.DB) {
+			if _, err := m.Up(); err != nil {
+				t.Fatalf("failed to migrate: %v", err)
+			}
+			mustExec(t, db, `DELETE FROM schema_migrations WHERE version = 2`)
+		}, nil, "migration 0002 was skipped"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, db := newTestMigrator(t)
+			tt.setup(t, m, db)
+
+			err := m.Check()
+			switch {
+			case tt.is != nil:
+				if !errors.Is(err, tt.is) {
+					t.Fatalf("got error %v, want %v", err, tt.is)
+				}
+			case tt.want != "":
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.want)
+				}
+			case err != nil:
+				t.Fatalf("check failed: %v", err)
+			}
+
+			// a database that fails the check is never migrated further
+			if err != nil {
+				if _, upErr := m.Up(); upErr == nil {
+					t.Fatal("up ran against a database that failed the check")
+				}
+			}
+		})
+	}
+}
+
+func TestMigratorBaseline(t *testing.T) {
+	// the schema production databases had before migrations were tracked
+	legacy := func(t *testing.T, m *Migrator, db *sql.DB) {
+		for _, migration := range m.migrations[:5] {
+			mustExec(t, db, migration.Up)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, m *Migrator, db *sql.DB)
+		version func(m *Migrator) int
+		want    string
+	}{
+		{"legacy database", legacy, func(*Migrator) int { return 5 }, ""},
+		{"version zero", legacy, func(*Migrator) int { return 0 }, "version must be between 1 and"},
+		{"beyond the latest", legacy, func(m *Migrator) int { return m.Latest() + 1 }, "version must be between 1 and"},
+		{"already tracked", func(t *testing.T, m *Migrator, db *sql.DB) {
+			if _, err := m.Up(); err != nil {
+				t.Fatalf("failed to migrate: %v", err)
+			}
+		}, func(*Migrator) int { return 5 }, "already has a migration history"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, db := newTestMigrator(t)
+			tt.setup(t, m, db)
+
+			err := m.Baseline(tt.version(m))
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("baseline failed: %v", err)
+			}
+
+			// the migrations after the baseline apply cleanly on top of it
+			done, err := m.Up()
+			if err != nil {
+				t.Fatalf("failed to migrate after the baseline: %v", err)
+			}
+			if len(done) != m.Latest()-5 || done[0].Version != 6 {
+				t.Fatalf("applied %d migrations starting at %d, want %d starting at 6", len(done), done[0].Version, m.Latest()-5)
+			}
+			if err := m.Check(); err != nil {
+				t.Fatalf("check failed after migrating: %v", err)
+			}
+		})
+	}
+}
+
+func TestMigratorDown(t *testing.T) {
+	m, _ := newTestMigrator(t)
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	// every down script has to undo its up script, so the whole history
+	// can be walked back and applied again
+	done, err := m.Down(m.Latest())
+	if err != nil {
+		t.Fatalf("failed to revert: %v", err)
+	}
+	if len(done) != m.Latest() {
+		t.Fatalf("reverted %d migrations, want %d", len(done), m.Latest())
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("failed to migrate again: %v", err)
+	}
+	if err := m.Check(); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+}
+
+// TestMigratorKeepsReferencingRows reverts and re-applies 0005, which
+// rebuilds users, on a database with posts and likes. With foreign keys
+// enforced, dropping the old users table would cascade into both.
+func TestMigratorKeepsReferencingRows(t *testing.T) {
+	m, db := newTestMigrator(t)
+	// one connection, so the pragmas the runner sets are the ones the test sees
+	db.SetMaxOpenConns(1)
+
+	all := m.migrations
+	m.migrations = all[:5]
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	mustExec(t, db, `INSERT INTO users (id, sub, username, name) VALUES ('u1', 'sub-1', 'user', 'User')`)
+	mustExec(t, db, `INSERT INTO posts (id, user_id, body) VALUES ('p1', 'u1', 'hello')`)
+	mustExec(t, db, `INSERT INTO post_media (id, post_id, media_url, media_type, file_key) VALUES ('m1', 'p1', '', 'image', 'posts/media/a.png')`)
+	mustExec(t, db, `INSERT INTO post_likes (id, post_id, user_id) VALUES ('l1', 'p1', 'u1')`)
+
+	count := func(table string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatalf("failed to count %s: %v", table, err)
+		}
+		return n
+	}
+	expectRows := func(step string) {
+		t.Helper()
+		for _, table := range []string{"users", "posts", "post_media", "post_likes"} {
+			if n := count(table); n != 1 {
+				t.Fatalf("%s has %d rows after %s, want 1", table, n, step)
+			}
+		}
+	}
+
+	if _, err := m.Down(1); err != nil {
+		t.Fatalf("failed to revert: %v", err)
+	}
+	expectRows("reverting 0005")
+
+	m.migrations = all
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("failed to migrate again: %v", err)
+	}
+	expectRows("applying every migration")
+
+	// enforcement is back on once the migrations are done
+	if _, err := db.Exec(`INSERT INTO post_likes (id, post_id, user_id) VALUES ('l2', 'no-such-post', 'u1')`); err == nil {
+		t.Fatal("inserted a like for a missing post")
+	}
+}
+
+func TestMigratorForeignKeyCheck(t *testing.T) {
+	m, db := newTestMigrator(t)
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	mustExec(t, db, `INSERT INTO users (id, sub, username, name) VALUES ('u1', 'sub-1', 'user', 'User')`)
+	mustExec(t, db, `INSERT INTO posts (id, user_id, body) VALUES ('p1', 'u1', 'hello')`)
+
+	// a migration that leaves a post without its author is rolled back
+	m.migrations = append(m.migrations, Migration{
+		Version:  m.Latest() + 1,
+		Name:     "orphan-posts",
+		Up:       `DELETE FROM users;`,
+		Down:     `SELECT 1;`,
+		Checksum: "orphan-posts",
+	})
+	if _, err := m.Up(); err == nil || !strings.Contains(err.Error(), "foreign key check failed") {
+		t.Fatalf("got error %v, want a failed foreign key check", err)
+	}
+
+	var users int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&users); err != nil || users != 1 {
+		t.Fatalf("got %d users, %v after the failed migration, want 1", users, err)
+	}
+	if err := m.Check(); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+}
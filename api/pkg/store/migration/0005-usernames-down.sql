@@ -7,12 +7,13 @@ CREATE TABLE IF NOT EXISTS users_temp (
   name         TEXT,
   email        TEXT,
   is_admin     BOOLEAN      DEFAULT FALSE,
-  created_at   TIMESTAMPTZ  DEFAULT CURRENT_TIMESTAMP
+  created_at   TIMESTAMPTZ  DEFAULT CURRENT_TIMESTAMP,
+  profile_picture_url TEXT
 );
 
-INSERT INTO users_temp (id, sub, verified, name, email, is_admin, created_at)
-SELECT id, sub, verified, name, email, is_admin, created_at FROM users;
+INSERT INTO users_temp (id, sub, verified, name, email, is_admin, created_at, profile_picture_url)
+SELECT id, sub, verified, name, email, is_admin, created_at, profile_picture_url FROM users;
 
 DROP TABLE IF EXISTS users;
 
-ALTER TABLE users_temp RENAME TO users;
\ No newline at end of file
+ALTER TABLE users_temp RENAME TO users;
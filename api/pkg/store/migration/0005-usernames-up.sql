@@ -7,12 +7,12 @@ CREATE TABLE IF NOT EXISTS users_temp (
   email        TEXT,
   profile_picture_url TEXT,
   is_admin     BOOLEAN    DEFAULT FALSE,
-  created_at   TIMESTAMPTZ  DEFAULT CURRENT_TIMESTAMP
-  updated_at   TIMESTAMPTZ  DEFAULT CURRENT_TIMESTAMP,
+  created_at   TIMESTAMPTZ  DEFAULT CURRENT_TIMESTAMP,
+  updated_at   TIMESTAMPTZ  DEFAULT CURRENT_TIMESTAMP
 );
 
-INSERT INTO users_temp (id, sub, verified, name, email, is_admin, created_at)
-SELECT id, sub, verified, name, email, is_admin, created_at FROM users;
+INSERT INTO users_temp (id, sub, verified, name, email, profile_picture_url, is_admin, created_at)
+SELECT id, sub, verified, name, email, profile_picture_url, is_admin, created_at FROM users;
 DROP TABLE users;
 ALTER TABLE users_temp RENAME TO users;
 
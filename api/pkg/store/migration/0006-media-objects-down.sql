@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_post_media_content_hash;
+
+ALTER TABLE post_media DROP COLUMN content_hash;
+
+DROP TABLE IF EXISTS media_objects;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS media_objects (
+  file_key     TEXT       PRIMARY KEY,
+  content_hash TEXT       NOT NULL UNIQUE,
+  mime_type    TEXT,
+  file_size    INTEGER,
+  ref_count    INTEGER    NOT NULL DEFAULT 0,
+  created_at   TIMESTAMP  DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE post_media ADD COLUMN content_hash TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_post_media_content_hash ON post_media(content_hash);
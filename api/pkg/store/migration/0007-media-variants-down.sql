@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_post_media_variants_source_key;
+
+DROP TABLE IF EXISTS post_media_variants;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS post_media_variants (
+  id           TEXT       PRIMARY KEY    DEFAULT (uuid4()),
+  source_key   TEXT       NOT NULL,
+  file_key     TEXT       NOT NULL UNIQUE,
+  width        INTEGER    NOT NULL,
+  height       INTEGER    NOT NULL,
+  mime_type    TEXT,
+  file_size    INTEGER,
+  created_at   TIMESTAMP  DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_media_variants_source_key ON post_media_variants(source_key);
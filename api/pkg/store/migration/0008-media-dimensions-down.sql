@@ -0,0 +1,7 @@
+ALTER TABLE media_objects DROP COLUMN blurhash;
+ALTER TABLE media_objects DROP COLUMN height;
+ALTER TABLE media_objects DROP COLUMN width;
+
+ALTER TABLE post_media DROP COLUMN blurhash;
+ALTER TABLE post_media DROP COLUMN height;
+ALTER TABLE post_media DROP COLUMN width;
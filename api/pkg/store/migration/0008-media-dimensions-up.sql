@@ -0,0 +1,7 @@
+ALTER TABLE post_media ADD COLUMN width INTEGER;
+ALTER TABLE post_media ADD COLUMN height INTEGER;
+ALTER TABLE post_media ADD COLUMN blurhash TEXT;
+
+ALTER TABLE media_objects ADD COLUMN width INTEGER;
+ALTER TABLE media_objects ADD COLUMN height INTEGER;
+ALTER TABLE media_objects ADD COLUMN blurhash TEXT;
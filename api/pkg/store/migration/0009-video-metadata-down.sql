@@ -0,0 +1,9 @@
+ALTER TABLE media_objects DROP COLUMN has_audio;
+ALTER TABLE media_objects DROP COLUMN audio_codec;
+ALTER TABLE media_objects DROP COLUMN video_codec;
+ALTER TABLE media_objects DROP COLUMN duration_ms;
+
+ALTER TABLE post_media DROP COLUMN has_audio;
+ALTER TABLE post_media DROP COLUMN audio_codec;
+ALTER TABLE post_media DROP COLUMN video_codec;
+ALTER TABLE post_media DROP COLUMN duration_ms;
@@ -0,0 +1,9 @@
+ALTER TABLE post_media ADD COLUMN duration_ms INTEGER;
+ALTER TABLE post_media ADD COLUMN video_codec TEXT;
+ALTER TABLE post_media ADD COLUMN audio_codec TEXT;
+ALTER TABLE post_media ADD COLUMN has_audio BOOLEAN;
+
+ALTER TABLE media_objects ADD COLUMN duration_ms INTEGER;
+ALTER TABLE media_objects ADD COLUMN video_codec TEXT;
+ALTER TABLE media_objects ADD COLUMN audio_codec TEXT;
+ALTER TABLE media_objects ADD COLUMN has_audio BOOLEAN;
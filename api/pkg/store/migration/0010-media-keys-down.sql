@@ -0,0 +1,10 @@
+ALTER TABLE post_media ADD COLUMN media_url TEXT NOT NULL DEFAULT '';
+UPDATE post_media SET media_url = 'https://cdn.ryo.cat/' || file_key;
+
+ALTER TABLE users RENAME COLUMN profile_picture_key TO profile_picture_url;
+
+UPDATE users
+   SET profile_picture_url = 'https://cdn.ryo.cat/' || profile_picture_url
+ WHERE profile_picture_url IS NOT NULL
+   AND profile_picture_url NOT LIKE 'http://%'
+   AND profile_picture_url NOT LIKE 'https://%';
@@ -0,0 +1,8 @@
+-- URLs are built from object keys at response time, so only the keys are kept.
+UPDATE users
+   SET profile_picture_url = substr(profile_picture_url, length('https://cdn.ryo.cat/') + 1)
+ WHERE profile_picture_url LIKE 'https://cdn.ryo.cat/%';
+
+ALTER TABLE users RENAME COLUMN profile_picture_url TO profile_picture_key;
+
+ALTER TABLE post_media DROP COLUMN media_url;
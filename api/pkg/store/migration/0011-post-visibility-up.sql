@@ -0,0 +1,3 @@
+ALTER TABLE posts ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public';
+
+CREATE INDEX IF NOT EXISTS idx_posts_visibility_created_at ON posts(visibility, created_at DESC);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_files_user_id;
+
+DROP TABLE IF EXISTS files;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS files (
+  id           TEXT       PRIMARY KEY    DEFAULT (uuid4()),
+  user_id      TEXT       NOT NULL,
+  file_key     TEXT       NOT NULL UNIQUE,
+  file_name    TEXT,
+  content_type TEXT,
+  file_size    INTEGER    DEFAULT 0,
+  created_at   TIMESTAMP  DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_files_user_id ON files(user_id);
@@ -0,0 +1,4 @@
+DELETE FROM files WHERE category != 'file';
+
+ALTER TABLE users DROP COLUMN storage_quota;
+ALTER TABLE files DROP COLUMN category;
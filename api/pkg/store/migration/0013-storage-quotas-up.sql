@@ -0,0 +1,9 @@
+ALTER TABLE files ADD COLUMN category TEXT NOT NULL DEFAULT 'file';
+ALTER TABLE users ADD COLUMN storage_quota INTEGER;
+
+-- count media uploaded before usage was tracked against the post's author
+INSERT OR IGNORE INTO files (user_id, file_key, file_name, content_type, file_size, category, created_at)
+SELECT p.user_id, m.file_key, m.file_key, m.mime_type, COALESCE(m.file_size, 0), 'post_media', MIN(m.created_at)
+FROM post_media m
+JOIN posts p ON p.id = m.post_id
+GROUP BY m.file_key;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS upload_sessions (
+  id            TEXT       PRIMARY KEY    DEFAULT (uuid4()),
+  user_id       TEXT       NOT NULL,
+  file_key      TEXT       NOT NULL,
+  content_type  TEXT       NOT NULL,
+  file_size     INTEGER    NOT NULL,
+  status        TEXT       NOT NULL DEFAULT 'pending',
+  expires_at    TIMESTAMP  NOT NULL,
+  created_at    TIMESTAMP  DEFAULT CURRENT_TIMESTAMP,
+  finalized_at  TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires_at ON upload_sessions(expires_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_trashed_objects_deleted_at;
+
+DROP TABLE IF EXISTS trashed_objects;
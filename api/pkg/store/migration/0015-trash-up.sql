@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS trashed_objects (
+  id            TEXT       PRIMARY KEY    DEFAULT (uuid4()),
+  original_key  TEXT       NOT NULL,
+  trash_key     TEXT       NOT NULL UNIQUE,
+  owner_id      TEXT,
+  file_name     TEXT,
+  content_type  TEXT,
+  file_size     INTEGER    DEFAULT 0,
+  category      TEXT       NOT NULL,
+  deleted_by    TEXT,
+  deleted_at    TIMESTAMP  DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE SET NULL,
+  FOREIGN KEY (deleted_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_trashed_objects_deleted_at ON trashed_objects(deleted_at);
@@ -0,0 +1,30 @@
+-- Private copies lose their rows; their posts still reference the keys, and
+-- the objects are collected once those posts are deleted.
+CREATE TABLE media_objects_old (
+  file_key     TEXT       PRIMARY KEY,
+  content_hash TEXT       NOT NULL UNIQUE,
+  mime_type    TEXT,
+  file_size    INTEGER,
+  ref_count    INTEGER    NOT NULL DEFAULT 0,
+  created_at   TIMESTAMP  DEFAULT CURRENT_TIMESTAMP,
+  width        INTEGER,
+  height       INTEGER,
+  blurhash     TEXT,
+  duration_ms  INTEGER,
+  video_codec  TEXT,
+  audio_codec  TEXT,
+  has_audio    BOOLEAN
+);
+
+INSERT INTO media_objects_old (
+  file_key, content_hash, mime_type, file_size, ref_count, created_at,
+  width, height, blurhash, duration_ms, video_codec, audio_codec, has_audio
+)
+SELECT file_key, content_hash, mime_type, file_size, ref_count, created_at,
+       width, height, blurhash, duration_ms, video_codec, audio_codec, has_audio
+FROM media_objects
+WHERE private = 0;
+
+DROP TABLE media_objects;
+
+ALTER TABLE media_objects_old RENAME TO media_objects;
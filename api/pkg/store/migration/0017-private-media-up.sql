@@ -0,0 +1,32 @@
+-- Media of posts that aren't public is copied under private/, which the CDN
+-- doesn't serve, so the same content can have one public and one private
+-- object. SQLite can't drop the old UNIQUE constraint, so the table is rebuilt.
+CREATE TABLE media_objects_new (
+  file_key     TEXT       PRIMARY KEY,
+  content_hash TEXT       NOT NULL,
+  private      BOOLEAN    NOT NULL DEFAULT 0,
+  mime_type    TEXT,
+  file_size    INTEGER,
+  ref_count    INTEGER    NOT NULL DEFAULT 0,
+  created_at   TIMESTAMP  DEFAULT CURRENT_TIMESTAMP,
+  width        INTEGER,
+  height       INTEGER,
+  blurhash     TEXT,
+  duration_ms  INTEGER,
+  video_codec  TEXT,
+  audio_codec  TEXT,
+  has_audio    BOOLEAN,
+  UNIQUE (content_hash, private)
+);
+
+INSERT INTO media_objects_new (
+  file_key, content_hash, mime_type, file_size, ref_count, created_at,
+  width, height, blurhash, duration_ms, video_codec, audio_codec, has_audio
+)
+SELECT file_key, content_hash, mime_type, file_size, ref_count, created_at,
+       width, height, blurhash, duration_ms, video_codec, audio_codec, has_audio
+FROM media_objects;
+
+DROP TABLE media_objects;
+
+ALTER TABLE media_objects_new RENAME TO media_objects;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_files_pending_until;
+
+ALTER TABLE files DROP COLUMN pending_until;
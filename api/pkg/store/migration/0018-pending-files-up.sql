@@ -0,0 +1,6 @@
+-- Files recorded for a pre-signed upload count their declared size until the
+-- policy expires; then they take the size of the uploaded object, or are
+-- dropped if nothing was uploaded.
+ALTER TABLE files ADD COLUMN pending_until TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_files_pending_until ON files(pending_until);
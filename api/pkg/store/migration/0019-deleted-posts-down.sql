@@ -0,0 +1,18 @@
+-- Posts that were only hidden go for good, giving up their hold on their
+-- media so the collector can remove what nothing else uses.
+UPDATE media_objects SET ref_count = ref_count - (
+  SELECT COUNT(*)
+  FROM post_media m
+  JOIN posts p ON p.id = m.post_id
+  WHERE p.deleted_at IS NOT NULL AND m.file_key = media_objects.file_key
+);
+
+DELETE FROM post_media WHERE post_id IN (SELECT id FROM posts WHERE deleted_at IS NOT NULL);
+
+DELETE FROM post_likes WHERE post_id IN (SELECT id FROM posts WHERE deleted_at IS NOT NULL);
+
+DELETE FROM posts WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_posts_deleted_at;
+
+ALTER TABLE posts DROP COLUMN deleted_at;
@@ -0,0 +1,5 @@
+-- Deleted posts are hidden rather than removed, so an admin can restore them
+-- with their likes and media until they are purged.
+ALTER TABLE posts ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_posts_deleted_at ON posts(deleted_at);
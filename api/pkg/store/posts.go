@@ -2,46 +2,66 @@ package store
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 )
 
+// Post visibilities. Unlisted posts are left out of feeds but can be opened
+// by anyone with the link; private posts are only shown to their author.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
 type Post struct {
 	ID          string      `json:"id"`
 	UserID      string      `json:"userId"`
 	Body        string      `json:"body"`
+	Visibility  string      `json:"visibility"`
 	CreatedAt   time.Time   `json:"createdAt"`
 	UpdatedAt   time.Time   `json:"updatedAt"`
 	User        *User       `json:"user,omitempty"`
 	Media       []PostMedia `json:"media,omitempty"`
 	LikeCount   int         `json:"likeCount"`
 	IsLikedByMe bool        `json:"isLikedByMe"`
+	// DeletedAt is set while a deleted post waits to be purged. Such posts
+	// are left out of every lookup but the ones for deleted posts.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 type PostMedia struct {
-	ID        string    `json:"id"`
-	PostID    string    `json:"postId"`
-	MediaURL  string    `json:"mediaUrl"`
-	MediaType string    `json:"mediaType"`
-	FileKey   string    `json:"fileKey"`
-	FileSize  int64     `json:"fileSize"`
-	MimeType  string    `json:"mimeType"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID          string         `json:"id"`
+	PostID      string         `json:"postId"`
+	MediaType   string         `json:"mediaType"`
+	FileKey     string         `json:"fileKey"`
+	FileSize    int64          `json:"fileSize"`
+	MimeType    string         `json:"mimeType"`
+	ContentHash string         `json:"contentHash,omitempty"`
+	Width       int            `json:"width,omitempty"`
+	Height      int            `json:"height,omitempty"`
+	BlurHash    string         `json:"blurhash,omitempty"`
+	Variants    []MediaVariant `json:"variants,omitempty"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	VideoMetadata
 }
 
 type PostStore struct {
-	db *sql.DB
+	db dbtx
 }
 
 func (s *PostStore) CreatePost(post *Post) error {
 	const q = `
-		INSERT INTO posts (user_id, body, created_at, updated_at)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO posts (user_id, body, visibility, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
 		RETURNING id;
 	`
 	return s.db.QueryRow(
 		q,
 		post.UserID,
 		post.Body,
+		post.Visibility,
 		post.CreatedAt,
 		post.UpdatedAt,
 	).Scan(&post.ID)
@@ -53,30 +73,98 @@ func (s *PostStore) AddMediaToPost(postID string, media []PostMedia) error {
 	}
 
 	const q = `
-		INSERT INTO post_media (post_id, media_url, media_type, file_key, file_size, mime_type, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO post_media (
+			post_id, media_type, file_key, file_size, mime_type, content_hash,
+			width, height, blurhash, duration_ms, video_codec, audio_codec, has_audio, created_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		RETURNING id;
 	`
+	const retain = `UPDATE media_objects SET ref_count = ref_count + 1 WHERE file_key = ?`
 
 	for i, m := range media {
 		err := s.db.QueryRow(
 			q,
 			postID,
-			m.MediaURL,
 			m.MediaType,
 			m.FileKey,
 			m.FileSize,
 			m.MimeType,
+			nullString(m.ContentHash),
+			nullInt(m.Width),
+			nullInt(m.Height),
+			nullString(m.BlurHash),
+			nullInt64(m.DurationMs),
+			nullString(m.VideoCodec),
+			nullString(m.AudioCodec),
+			m.HasAudio,
 			m.CreatedAt,
 		).Scan(&media[i].ID)
 		if err != nil {
 			return err
 		}
+
+		if _, err := s.db.Exec(retain, m.FileKey); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// CreatePostWithMedia checks post and media and then creates the post with
+// all of its media in one transaction, so a failure never leaves a post
+// without the media it was created with. post.ID, and the ID and PostID of
+// each media item, are set on success.
+func (s *Storage) CreatePostWithMedia(post *Post, media []PostMedia) error {
+	if err := validatePost(post, media); err != nil {
+		return err
+	}
+
+	return s.WithTx(func(tx *Storage) error {
+		if err := tx.Posts.CreatePost(post); err != nil {
+			return fmt.Errorf("failed to create post: %w", err)
+		}
+		for i := range media {
+			media[i].PostID = post.ID
+		}
+		if err := tx.Posts.AddMediaToPost(post.ID, media); err != nil {
+			return fmt.Errorf("failed to add media to post: %w", err)
+		}
+		return nil
+	})
+}
+
+func validatePost(post *Post, media []PostMedia) error {
+	if post.UserID == "" {
+		return fmt.Errorf("post has no author")
+	}
+	if strings.TrimSpace(post.Body) == "" {
+		return fmt.Errorf("post body cannot be empty")
+	}
+	switch post.Visibility {
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+	default:
+		return fmt.Errorf("invalid visibility: %s", post.Visibility)
+	}
+
+	for i, m := range media {
+		if m.FileKey == "" {
+			return fmt.Errorf("media %d has no file key", i+1)
+		}
+		if m.MediaType != "image" && m.MediaType != "video" {
+			return fmt.Errorf("invalid media type for %s: %s", m.FileKey, m.MediaType)
+		}
+		if !strings.HasPrefix(m.MimeType, m.MediaType+"/") {
+			return fmt.Errorf("mime type %s does not match media type %s for %s", m.MimeType, m.MediaType, m.FileKey)
+		}
+		if m.FileSize <= 0 {
+			return fmt.Errorf("invalid file size for %s: %d", m.FileKey, m.FileSize)
+		}
+	}
+	return nil
+}
+
 func (s *PostStore) GetPostByID(postID string) (*Post, error) {
 	return s.getPostByIDWithUserContext(postID, "")
 }
@@ -93,66 +181,32 @@ func (s *PostStore) getPostByIDWithUserContext(postID, currentUserID string) (*P
 	return post, nil
 }
 
+// getPostByIDWithLikes is getPostByIDBasic plus the post's likes, counted
+// the same way as for a feed page so only that post's likes are read.
 func (s *PostStore) getPostByIDWithLikes(postID, currentUserID string) (*Post, error) {
-	const postQuery = `
-		SELECT p.id, p.user_id, p.body, p.created_at, p.updated_at,
-		       u.id, u.username, u.name, u.email, u.is_admin, u.profile_picture_url,
-		       COALESCE(like_counts.count, 0) as like_count,
-		       CASE WHEN user_likes.user_id IS NOT NULL THEN 1 ELSE 0 END as is_liked_by_me
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		LEFT JOIN (
-			SELECT post_id, COUNT(*) as count
-			FROM post_likes
-			GROUP BY post_id
-		) like_counts ON p.id = like_counts.post_id
-		LEFT JOIN post_likes user_likes ON p.id = user_likes.post_id AND user_likes.user_id = ?
-		WHERE p.id = ?
-	`
-
-	post := &Post{}
-	user := &User{}
-
-	err := s.db.QueryRow(postQuery, currentUserID, postID).Scan(
-		&post.ID,
-		&post.UserID,
-		&post.Body,
-		&post.CreatedAt,
-		&post.UpdatedAt,
-		&user.ID,
-		&user.UserName,
-		&user.Name,
-		&user.Email,
-		&user.IsAdmin,
-		&user.ProfilePictureURL,
-		&post.LikeCount,
-		&post.IsLikedByMe,
-	)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
+	post, err := s.getPostByIDBasic(postID)
+	if err != nil || post == nil {
+		return post, err
 	}
 
-	post.User = user
-
-	media, err := s.getMediaForPost(postID)
+	likes, err := s.getLikesForPosts([]string{postID}, currentUserID)
 	if err != nil {
 		return nil, err
 	}
-	post.Media = media
+	l := likes[postID]
+	post.LikeCount = l.count
+	post.IsLikedByMe = l.likedByMe
 
 	return post, nil
 }
 
 func (s *PostStore) getPostByIDBasic(postID string) (*Post, error) {
 	const postQuery = `
-		SELECT p.id, p.user_id, p.body, p.created_at, p.updated_at,
-		       u.id, u.username, u.name, u.email, u.is_admin, u.profile_picture_url
+		SELECT p.id, p.user_id, p.body, p.visibility, p.created_at, p.updated_at,
+		       u.id, u.username, u.name, u.email, u.is_admin, u.profile_picture_key
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
-		WHERE p.id = ?
+		WHERE p.id = ? AND p.deleted_at IS NULL
 	`
 
 	post := &Post{}
@@ -162,6 +216,7 @@ func (s *PostStore) getPostByIDBasic(postID string) (*Post, error) {
 		&post.ID,
 		&post.UserID,
 		&post.Body,
+		&post.Visibility,
 		&post.CreatedAt,
 		&post.UpdatedAt,
 		&user.ID,
@@ -169,7 +224,7 @@ func (s *PostStore) getPostByIDBasic(postID string) (*Post, error) {
 		&user.Name,
 		&user.Email,
 		&user.IsAdmin,
-		&user.ProfilePictureURL,
+		&user.ProfilePictureKey,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -191,41 +246,79 @@ func (s *PostStore) getPostByIDBasic(postID string) (*Post, error) {
 	return post, nil
 }
 
-func (s *PostStore) GetAllPosts(limit, offset int) ([]Post, error) {
-	return s.getAllPostsWithUserContext(limit, offset, "")
-}
+// ListFeed returns a page of posts, newest first. However long the page, it
+// takes one query for the posts and one each for their likes, media and
+// media variants.
+func (s *PostStore) ListFeed(q FeedQuery) ([]Post, error) {
+	posts, err := s.listFeedPosts(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return posts, nil
+	}
 
-func (s *PostStore) GetAllPostsWithUserContext(limit, offset int, currentUserID string) ([]Post, error) {
-	return s.getAllPostsWithUserContext(limit, offset, currentUserID)
-}
+	postIDs := make([]string, len(posts))
+	for i := range posts {
+		postIDs[i] = posts[i].ID
+	}
 
-func (s *PostStore) getAllPostsWithUserContext(limit, offset int, currentUserID string) ([]Post, error) {
-	posts, err := s.getAllPostsWithLikes(limit, offset, currentUserID)
+	likes, err := s.getLikesForPosts(postIDs, q.CurrentUserID)
+	if err != nil {
+		return nil, err
+	}
+	media, err := s.getMediaForPosts(postIDs)
 	if err != nil {
-		return s.getAllPostsBasic(limit, offset)
+		return nil, err
+	}
+
+	for i := range posts {
+		l := likes[posts[i].ID]
+		posts[i].LikeCount = l.count
+		posts[i].IsLikedByMe = l.likedByMe
+		posts[i].Media = media[posts[i].ID]
 	}
+
 	return posts, nil
 }
 
-func (s *PostStore) getAllPostsWithLikes(limit, offset int, currentUserID string) ([]Post, error) {
-	const q = `
-		SELECT p.id, p.user_id, p.body, p.created_at, p.updated_at,
-		       u.id, u.username, u.name, u.email, u.is_admin, u.profile_picture_url,
-		       COALESCE(like_counts.count, 0) as like_count,
-		       CASE WHEN user_likes.user_id IS NOT NULL THEN 1 ELSE 0 END as is_liked_by_me
+func (s *PostStore) listFeedPosts(q FeedQuery) ([]Post, error) {
+	where := []string{"p.deleted_at IS NULL", "(p.visibility = 'public' OR p.user_id = ?)"}
+	args := []any{q.CurrentUserID}
+	if q.UserID != "" {
+		where = append(where, "p.user_id = ?")
+		args = append(args, q.UserID)
+	}
+	if q.After != nil {
+		where = append(where, "(p.created_at < ? OR (p.created_at = ? AND p.id < ?))")
+		args = append(args, q.After.CreatedAt, q.After.CreatedAt, q.After.ID)
+	}
+
+	query := `
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT ?
+	`
+	args = append(args, q.Limit)
+	if q.After == nil && q.Offset > 0 {
+		query += " OFFSET ?"
+		args = append(args, q.Offset)
+	}
+
+	return s.queryPosts(query, args...)
+}
+
+// queryPosts selects the posts, with their authors, matching the WHERE and
+// ORDER BY clauses in tail.
+func (s *PostStore) queryPosts(tail string, args ...any) ([]Post, error) {
+	query := `
+		SELECT p.id, p.user_id, p.body, p.visibility, p.created_at, p.updated_at, p.deleted_at,
+		       u.id, u.username, u.name, u.email, u.is_admin, u.profile_picture_key
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
-		LEFT JOIN (
-			SELECT post_id, COUNT(*) as count
-			FROM post_likes
-			GROUP BY post_id
-		) like_counts ON p.id = like_counts.post_id
-		LEFT JOIN post_likes user_likes ON p.id = user_likes.post_id AND user_likes.user_id = ?
-		ORDER BY p.created_at DESC
-		LIMIT ? OFFSET ?
-	`
+	` + tail
 
-	rows, err := s.db.Query(q, currentUserID, limit, offset)
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -235,273 +328,285 @@ func (s *PostStore) getAllPostsWithLikes(limit, offset int, currentUserID string
 	for rows.Next() {
 		post := Post{}
 		user := User{}
+		var deletedAt sql.NullTime
 
 		err := rows.Scan(
 			&post.ID,
 			&post.UserID,
 			&post.Body,
+			&post.Visibility,
 			&post.CreatedAt,
 			&post.UpdatedAt,
+			&deletedAt,
 			&user.ID,
 			&user.UserName,
 			&user.Name,
 			&user.Email,
 			&user.IsAdmin,
-			&user.ProfilePictureURL,
-			&post.LikeCount,
-			&post.IsLikedByMe,
+			&user.ProfilePictureKey,
 		)
 		if err != nil {
 			return nil, err
 		}
-
-		post.User = &user
-
-		media, err := s.getMediaForPost(post.ID)
-		if err != nil {
-			return nil, err
+		if deletedAt.Valid {
+			post.DeletedAt = &deletedAt.Time
 		}
-		post.Media = media
 
+		post.User = &user
 		posts = append(posts, post)
 	}
 
-	return posts, nil
+	return posts, rows.Err()
 }
 
-func (s *PostStore) getAllPostsBasic(limit, offset int) ([]Post, error) {
-	const q = `
-		SELECT p.id, p.user_id, p.body, p.created_at, p.updated_at,
-		       u.id, u.username, u.name, u.email, u.is_admin, u.profile_picture_url
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		ORDER BY p.created_at DESC
-		LIMIT ? OFFSET ?
+type postLikes struct {
+	count     int
+	likedByMe bool
+}
+
+// getLikesForPosts counts the likes of each post and whether currentUserID
+// is among them, only looking at the likes of those posts.
+func (s *PostStore) getLikesForPosts(postIDs []string, currentUserID string) (map[string]postLikes, error) {
+	q := `
+		SELECT post_id, COUNT(*), MAX(CASE WHEN user_id = ? THEN 1 ELSE 0 END)
+		FROM post_likes
+		WHERE post_id IN (` + placeholders(len(postIDs)) + `)
+		GROUP BY post_id
 	`
 
-	rows, err := s.db.Query(q, limit, offset)
+	rows, err := s.db.Query(q, append([]any{currentUserID}, inArgs(postIDs)...)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var posts []Post
+	likes := make(map[string]postLikes, len(postIDs))
 	for rows.Next() {
-		post := Post{}
-		user := User{}
-
-		err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Body,
-			&post.CreatedAt,
-			&post.UpdatedAt,
-			&user.ID,
-			&user.UserName,
-			&user.Name,
-			&user.Email,
-			&user.IsAdmin,
-			&user.ProfilePictureURL,
-		)
-		if err != nil {
+		var postID string
+		var l postLikes
+		if err := rows.Scan(&postID, &l.count, &l.likedByMe); err != nil {
 			return nil, err
 		}
+		likes[postID] = l
+	}
 
-		post.User = &user
-		post.LikeCount = 0
-		post.IsLikedByMe = false
+	return likes, rows.Err()
+}
 
-		media, err := s.getMediaForPost(post.ID)
-		if err != nil {
-			return nil, err
-		}
-		post.Media = media
+// DeletePost hides a post until it is restored or purged. Its likes and
+// media are kept, and so go on counting towards the media's references.
+func (s *PostStore) DeletePost(postID string) error {
+	const q = `UPDATE posts SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`
+	_, err := s.db.Exec(q, time.Now().UTC(), postID)
+	return err
+}
 
-		posts = append(posts, post)
-	}
+// RestorePost brings back a deleted post. It reports false if the post
+// isn't deleted, or is gone.
+func (s *PostStore) RestorePost(postID string) (bool, error) {
+	const q = `UPDATE posts SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`
+	return s.affected(q, postID)
+}
 
-	return posts, nil
+// ListDeletedPosts returns deleted posts with their media, most recently
+// deleted first.
+func (s *PostStore) ListDeletedPosts(limit, offset int) ([]Post, error) {
+	const q = `WHERE p.deleted_at IS NOT NULL ORDER BY p.deleted_at DESC, p.id DESC LIMIT ? OFFSET ?`
+	return s.queryPostsWithMedia(q, limit, offset)
 }
 
-func (s *PostStore) GetPostsByUserID(userID string, limit, offset int) ([]Post, error) {
-	return s.getPostsByUserIDWithUserContext(userID, limit, offset, "")
+// ListPostsDeletedBefore returns the posts, with their media, deleted
+// before cutoff.
+func (s *PostStore) ListPostsDeletedBefore(cutoff time.Time) ([]Post, error) {
+	const q = `WHERE p.deleted_at < ? ORDER BY p.deleted_at`
+	return s.queryPostsWithMedia(q, cutoff.UTC())
 }
 
-func (s *PostStore) GetPostsByUserIDWithUserContext(userID string, limit, offset int, currentUserID string) ([]Post, error) {
-	return s.getPostsByUserIDWithUserContext(userID, limit, offset, currentUserID)
+// PurgePost removes a deleted post along with its likes and media rows. It
+// reports false if the post isn't deleted, so a purge racing a restore
+// leaves the restored post alone. The caller releases the post's media.
+func (s *PostStore) PurgePost(postID string) (bool, error) {
+	const q = `DELETE FROM posts WHERE id = ? AND deleted_at IS NOT NULL`
+	return s.affected(q, postID)
 }
 
-func (s *PostStore) getPostsByUserIDWithUserContext(userID string, limit, offset int, currentUserID string) ([]Post, error) {
-	posts, err := s.getPostsByUserIDWithLikes(userID, limit, offset, currentUserID)
+func (s *PostStore) affected(q string, args ...any) (bool, error) {
+	result, err := s.db.Exec(q, args...)
 	if err != nil {
-		return s.getPostsByUserIDBasic(userID, limit, offset)
+		return false, err
 	}
-	return posts, nil
+	n, err := result.RowsAffected()
+	return n > 0, err
 }
 
-func (s *PostStore) getPostsByUserIDWithLikes(userID string, limit, offset int, currentUserID string) ([]Post, error) {
-	const q = `
-		SELECT p.id, p.user_id, p.body, p.created_at, p.updated_at,
-		       u.id, u.username, u.name, u.email, u.is_admin, u.profile_picture_url,
-		       COALESCE(like_counts.count, 0) as like_count,
-		       CASE WHEN user_likes.user_id IS NOT NULL THEN 1 ELSE 0 END as is_liked_by_me
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		LEFT JOIN (
-			SELECT post_id, COUNT(*) as count
-			FROM post_likes
-			GROUP BY post_id
-		) like_counts ON p.id = like_counts.post_id
-		LEFT JOIN post_likes user_likes ON p.id = user_likes.post_id AND user_likes.user_id = ?
-		WHERE p.user_id = ?
-		ORDER BY p.created_at DESC
-		LIMIT ? OFFSET ?
-	`
+func (s *PostStore) queryPostsWithMedia(tail string, args ...any) ([]Post, error) {
+	posts, err := s.queryPosts(tail, args...)
+	if err != nil || len(posts) == 0 {
+		return posts, err
+	}
 
-	rows, err := s.db.Query(q, currentUserID, userID, limit, offset)
+	postIDs := make([]string, len(posts))
+	for i := range posts {
+		postIDs[i] = posts[i].ID
+	}
+	media, err := s.getMediaForPosts(postIDs)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var posts []Post
-	for rows.Next() {
-		post := Post{}
-		user := User{}
-
-		err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Body,
-			&post.CreatedAt,
-			&post.UpdatedAt,
-			&user.ID,
-			&user.UserName,
-			&user.Name,
-			&user.Email,
-			&user.IsAdmin,
-			&user.ProfilePictureURL,
-			&post.LikeCount,
-			&post.IsLikedByMe,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		post.User = &user
-
-		media, err := s.getMediaForPost(post.ID)
-		if err != nil {
-			return nil, err
-		}
-		post.Media = media
-
-		posts = append(posts, post)
+	for i := range posts {
+		posts[i].Media = media[posts[i].ID]
 	}
-
 	return posts, nil
 }
 
-func (s *PostStore) getPostsByUserIDBasic(userID string, limit, offset int) ([]Post, error) {
+// ListMediaFileKeys returns every file key referenced by post media,
+// including the resized variants of that media.
+func (s *PostStore) ListMediaFileKeys() ([]string, error) {
 	const q = `
-		SELECT p.id, p.user_id, p.body, p.created_at, p.updated_at,
-		       u.id, u.username, u.name, u.email, u.is_admin, u.profile_picture_url
-		FROM posts p
-		JOIN users u ON p.user_id = u.id
-		WHERE p.user_id = ?
-		ORDER BY p.created_at DESC
-		LIMIT ? OFFSET ?
+		SELECT file_key FROM post_media
+		UNION
+		SELECT v.file_key
+		FROM post_media_variants v
+		JOIN post_media m ON m.file_key = v.source_key
 	`
 
-	rows, err := s.db.Query(q, userID, limit, offset)
+	rows, err := s.db.Query(q)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var posts []Post
+	var keys []string
 	for rows.Next() {
-		post := Post{}
-		user := User{}
-
-		err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Body,
-			&post.CreatedAt,
-			&post.UpdatedAt,
-			&user.ID,
-			&user.UserName,
-			&user.Name,
-			&user.Email,
-			&user.IsAdmin,
-			&user.ProfilePictureURL,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		post.User = &user
-		post.LikeCount = 0
-		post.IsLikedByMe = false
-
-		media, err := s.getMediaForPost(post.ID)
-		if err != nil {
+		var key string
+		if err := rows.Scan(&key); err != nil {
 			return nil, err
 		}
-		post.Media = media
-
-		posts = append(posts, post)
+		keys = append(keys, key)
 	}
-
-	return posts, nil
+	return keys, rows.Err()
 }
 
-func (s *PostStore) DeletePost(postID string) error {
-	const q = `DELETE FROM posts WHERE id = ?`
-	_, err := s.db.Exec(q, postID)
-	return err
+func (s *PostStore) getMediaForPost(postID string) ([]PostMedia, error) {
+	media, err := s.getMediaForPosts([]string{postID})
+	if err != nil {
+		return nil, err
+	}
+	return media[postID], nil
 }
 
-func (s *PostStore) getMediaForPost(postID string) ([]PostMedia, error) {
-	const q = `
-		SELECT id, post_id, media_url, media_type, file_key, file_size, mime_type, created_at
+// getMediaForPosts returns the media of each post, with their variants, in
+// the order it was added.
+func (s *PostStore) getMediaForPosts(postIDs []string) (map[string][]PostMedia, error) {
+	q := `
+		SELECT id, post_id, media_type, file_key, file_size, mime_type,
+		       COALESCE(content_hash, ''), COALESCE(width, 0), COALESCE(height, 0), COALESCE(blurhash, ''),
+		       COALESCE(duration_ms, 0), COALESCE(video_codec, ''), COALESCE(audio_codec, ''), COALESCE(has_audio, 0), created_at
 		FROM post_media
-		WHERE post_id = ?
+		WHERE post_id IN (` + placeholders(len(postIDs)) + `)
 		ORDER BY created_at ASC
 	`
 
-	rows, err := s.db.Query(q, postID)
+	rows, err := s.db.Query(q, inArgs(postIDs)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var media []PostMedia
+	media := make(map[string][]PostMedia, len(postIDs))
+	found := false
 	for rows.Next() {
 		m := PostMedia{}
 		err := rows.Scan(
 			&m.ID,
 			&m.PostID,
-			&m.MediaURL,
 			&m.MediaType,
 			&m.FileKey,
 			&m.FileSize,
 			&m.MimeType,
+			&m.ContentHash,
+			&m.Width,
+			&m.Height,
+			&m.BlurHash,
+			&m.DurationMs,
+			&m.VideoCodec,
+			&m.AudioCodec,
+			&m.HasAudio,
 			&m.CreatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
-		media = append(media, m)
+		media[m.PostID] = append(media[m.PostID], m)
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return media, nil
+	}
+
+	variants, err := s.getVariantsForPosts(postIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, items := range media {
+		for i := range items {
+			items[i].Variants = variants[items[i].FileKey]
+		}
 	}
 
 	return media, nil
 }
 
+// getVariantsForPosts returns the resized copies of the posts' media, keyed
+// by the file key of the original.
+func (s *PostStore) getVariantsForPosts(postIDs []string) (map[string][]MediaVariant, error) {
+	q := `
+		SELECT v.source_key, v.file_key, v.width, v.height, v.mime_type, v.file_size
+		FROM post_media_variants v
+		WHERE v.source_key IN (SELECT file_key FROM post_media WHERE post_id IN (` + placeholders(len(postIDs)) + `))
+		ORDER BY v.width ASC
+	`
+
+	rows, err := s.db.Query(q, inArgs(postIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := make(map[string][]MediaVariant)
+	for rows.Next() {
+		var v MediaVariant
+		if err := rows.Scan(&v.SourceKey, &v.FileKey, &v.Width, &v.Height, &v.MimeType, &v.FileSize); err != nil {
+			return nil, err
+		}
+		variants[v.SourceKey] = append(variants[v.SourceKey], v)
+	}
+
+	return variants, rows.Err()
+}
+
+// placeholders returns n comma-separated parameters for an IN list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func inArgs(values []string) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
 func (s *PostStore) GetPostMediaByID(mediaID string) (*PostMedia, error) {
 	const q = `
-		SELECT id, post_id, media_url, media_type, file_key, file_size, mime_type, created_at
+		SELECT id, post_id, media_type, file_key, file_size, mime_type,
+		       COALESCE(content_hash, ''), COALESCE(width, 0), COALESCE(height, 0), COALESCE(blurhash, ''),
+		       COALESCE(duration_ms, 0), COALESCE(video_codec, ''), COALESCE(audio_codec, ''), COALESCE(has_audio, 0), created_at
 		FROM post_media
 		WHERE id = ?
 	`
@@ -510,11 +615,18 @@ func (s *PostStore) GetPostMediaByID(mediaID string) (*PostMedia, error) {
 	err := s.db.QueryRow(q, mediaID).Scan(
 		&media.ID,
 		&media.PostID,
-		&media.MediaURL,
 		&media.MediaType,
 		&media.FileKey,
 		&media.FileSize,
 		&media.MimeType,
+		&media.ContentHash,
+		&media.Width,
+		&media.Height,
+		&media.BlurHash,
+		&media.DurationMs,
+		&media.VideoCodec,
+		&media.AudioCodec,
+		&media.HasAudio,
 		&media.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -530,17 +642,17 @@ func (s *PostStore) GetPostMediaByID(mediaID string) (*PostMedia, error) {
 func NewPost(userID, body string) *Post {
 	now := time.Now().UTC()
 	return &Post{
-		UserID:    userID,
-		Body:      body,
-		CreatedAt: now,
-		UpdatedAt: now,
+		UserID:     userID,
+		Body:       body,
+		Visibility: VisibilityPublic,
+		CreatedAt:  now,
+		UpdatedAt:  now,
 	}
 }
 
-func NewPostMedia(postID, mediaURL, mediaType, fileKey, mimeType string, fileSize int64) *PostMedia {
+func NewPostMedia(postID, mediaType, fileKey, mimeType string, fileSize int64) *PostMedia {
 	return &PostMedia{
 		PostID:    postID,
-		MediaURL:  mediaURL,
 		MediaType: mediaType,
 		FileKey:   fileKey,
 		FileSize:  fileSize,
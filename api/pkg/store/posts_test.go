@@ -0,0 +1,268 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const (
+	benchUsers         = 20
+	benchPosts         = 2000
+	benchMediaPerPost  = 2
+	benchVariantsPer   = 3
+	benchLikesPerPost  = 10
+	benchFeedPageLimit = 100
+)
+
+// seedFeed fills an in-memory SQLite database with users, posts with media
+// and variants, and likes, and returns it with the ID of a user to view the
+// feed as.
+func seedFeed(b *testing.B) (*Storage, string) {
+	b.Helper()
+
+	s := openTestStorage(b)
+
+	userIDs := make([]string, benchUsers)
+	for i := range userIDs {
+		user := NewUser(fmt.Sprintf("sub-%d", i), true, fmt.Sprintf("user%d", i), "User", fmt.Sprintf("user%d@example.com", i))
+		if err := s.Users.Create(user); err != nil {
+			b.Fatalf("failed to create user: %v", err)
+		}
+		userIDs[i] = user.ID
+	}
+
+	base := time.Now().UTC().Add(-benchPosts * time.Minute)
+	err := s.WithTx(func(tx *Storage) error {
+		for i := 0; i < benchPosts; i++ {
+			post := NewPost(userIDs[i%benchUsers], fmt.Sprintf("post %d", i))
+			post.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+			post.UpdatedAt = post.CreatedAt
+
+			media := make([]PostMedia, benchMediaPerPost)
+			for j := range media {
+				key := fmt.Sprintf("posts/media/%d-%d.jpg", i, j)
+				if err := tx.Media.Create(&MediaObject{
+					FileKey:     key,
+					ContentHash: key,
+					MimeType:    "image/jpeg",
+					FileSize:    1024,
+					CreatedAt:   post.CreatedAt,
+				}); err != nil {
+					return err
+				}
+
+				variants := make([]MediaVariant, benchVariantsPer)
+				for k := range variants {
+					width := 320 << k
+					variants[k] = MediaVariant{
+						FileKey:  fmt.Sprintf("posts/media/%d-%d-%d.webp", i, j, width),
+						Width:    width,
+						Height:   width,
+						MimeType: "image/webp",
+						FileSize: 512,
+					}
+				}
+				if err := tx.Media.AddVariants(key, variants); err != nil {
+					return err
+				}
+
+				media[j] = *NewPostMedia("", "image", key, "image/jpeg", 1024)
+			}
+
+			if err := tx.CreatePostWithMedia(post, media); err != nil {
+				return err
+			}
+
+			for j := 0; j < benchLikesPerPost; j++ {
+				if _, err := tx.Posts.ToggleLike(post.ID, userIDs[(i+j)%benchUsers]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("failed to seed posts: %v", err)
+	}
+
+	return s, userIDs[0]
+}
+
+func BenchmarkListFeed(b *testing.B) {
+	s, viewerID := seedFeed(b)
+
+	// the cursor halfway down the feed, where an offset is expensive
+	middle, err := s.Posts.ListFeed(FeedQuery{CurrentUserID: viewerID, Limit: benchPosts / 2})
+	if err != nil {
+		b.Fatalf("failed to list posts: %v", err)
+	}
+	cursor := CursorFor(&middle[len(middle)-1])
+
+	cases := []struct {
+		name  string
+		query FeedQuery
+	}{
+		{"first page", FeedQuery{CurrentUserID: viewerID}},
+		{"signed out", FeedQuery{}},
+		{"by user", FeedQuery{CurrentUserID: viewerID, UserID: viewerID}},
+		{"after cursor", FeedQuery{CurrentUserID: viewerID, After: cursor}},
+		{"offset", FeedQuery{CurrentUserID: viewerID, Offset: benchPosts / 2}},
+	}
+
+	for _, c := range cases {
+		c.query.Limit = benchFeedPageLimit
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				posts, err := s.Posts.ListFeed(c.query)
+				if err != nil {
+					b.Fatalf("failed to list posts: %v", err)
+				}
+				if len(posts) == 0 {
+					b.Fatal("no posts listed")
+				}
+				if len(posts[0].Media) != benchMediaPerPost || len(posts[0].Media[0].Variants) != benchVariantsPer {
+					b.Fatalf("post has %d media, want %d with %d variants each", len(posts[0].Media), benchMediaPerPost, benchVariantsPer)
+				}
+			}
+		})
+	}
+}
+
+func TestGetPostByIDLikes(t *testing.T) {
+	s := openTestStorage(t)
+
+	users := make([]*User, 3)
+	for i := range users {
+		users[i] = NewUser(fmt.Sprintf("sub-%d", i), false, fmt.Sprintf("user%d", i), "User", fmt.Sprintf("user%d@example.com", i))
+		if err := s.Users.Create(users[i]); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	posts := make([]*Post, 3)
+	for i := range posts {
+		posts[i] = NewPost(users[0].ID, fmt.Sprintf("post %d", i))
+		if err := s.Posts.CreatePost(posts[i]); err != nil {
+			t.Fatalf("failed to create post: %v", err)
+		}
+	}
+
+	// likes on the other posts must not leak into a post's count
+	for _, like := range []struct{ post, user int }{{0, 0}, {0, 1}, {1, 1}} {
+		if _, err := s.Posts.ToggleLike(posts[like.post].ID, users[like.user].ID); err != nil {
+			t.Fatalf("failed to like: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		post   int
+		viewer string
+		count  int
+		liked  bool
+	}{
+		{"liked by the viewer", 0, users[0].ID, 2, true},
+		{"liked by others", 0, users[2].ID, 2, false},
+		{"signed out", 0, "", 2, false},
+		{"one like", 1, users[1].ID, 1, true},
+		{"no likes", 2, users[1].ID, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post, err := s.Posts.GetPostByIDWithUserContext(posts[tt.post].ID, tt.viewer)
+			if err != nil || post == nil {
+				t.Fatalf("failed to get post: %v", err)
+			}
+			if post.LikeCount != tt.count || post.IsLikedByMe != tt.liked {
+				t.Fatalf("got %d likes, liked=%v, want %d and %v", post.LikeCount, post.IsLikedByMe, tt.count, tt.liked)
+			}
+			if post.User == nil || post.User.ID != users[0].ID {
+				t.Fatalf("got author %+v, want %s", post.User, users[0].ID)
+			}
+		})
+	}
+
+	if post, err := s.Posts.GetPostByIDWithUserContext("no-such-post", users[0].ID); err != nil || post != nil {
+		t.Fatalf("got %+v, %v for a missing post, want nothing", post, err)
+	}
+}
+
+// TestDeletedPosts walks a post through delete, restore and purge on both
+// the SQL and the in-memory store.
+func TestDeletedPosts(t *testing.T) {
+	stores := map[string]func(t *testing.T) *Storage{
+		"sqlite": func(t *testing.T) *Storage { return openTestStorage(t) },
+		"memory": func(*testing.T) *Storage { return NewMemoryStorage() },
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+
+			user := NewUser("sub", false, "user", "User", "user@example.com")
+			if err := s.Users.Create(user); err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+			if err := s.Media.Create(&MediaObject{FileKey: "posts/media/a.png", ContentHash: "a", MimeType: "image/png", FileSize: 1}); err != nil {
+				t.Fatalf("failed to create media: %v", err)
+			}
+			post := NewPost(user.ID, "hello")
+			if err := s.CreatePostWithMedia(post, []PostMedia{*NewPostMedia("", "image", "posts/media/a.png", "image/png", 1)}); err != nil {
+				t.Fatalf("failed to create post: %v", err)
+			}
+			if _, err := s.Posts.ToggleLike(post.ID, user.ID); err != nil {
+				t.Fatalf("failed to like: %v", err)
+			}
+
+			if err := s.Posts.DeletePost(post.ID); err != nil {
+				t.Fatalf("failed to delete post: %v", err)
+			}
+			if got, err := s.Posts.GetPostByID(post.ID); err != nil || got != nil {
+				t.Fatalf("got %+v, %v for a deleted post, want nothing", got, err)
+			}
+			if feed, err := s.Posts.ListFeed(FeedQuery{CurrentUserID: user.ID, Limit: 10}); err != nil || len(feed) != 0 {
+				t.Fatalf("got feed %+v, %v, want no posts", feed, err)
+			}
+			deleted, err := s.Posts.ListDeletedPosts(10, 0)
+			if err != nil || len(deleted) != 1 || deleted[0].DeletedAt == nil || len(deleted[0].Media) != 1 {
+				t.Fatalf("got deleted posts %+v, %v, want the post with its media", deleted, err)
+			}
+			if expired, err := s.Posts.ListPostsDeletedBefore(time.Now().Add(-time.Hour)); err != nil || len(expired) != 0 {
+				t.Fatalf("got expired posts %+v, %v, want none", expired, err)
+			}
+
+			if restored, err := s.Posts.RestorePost(post.ID); err != nil || !restored {
+				t.Fatalf("restore reported %v, %v, want true", restored, err)
+			}
+			got, err := s.Posts.GetPostByID(post.ID)
+			if err != nil || got == nil || got.LikeCount != 1 || len(got.Media) != 1 {
+				t.Fatalf("got %+v, %v after the restore, want the post with its like and media", got, err)
+			}
+			if restored, err := s.Posts.RestorePost(post.ID); err != nil || restored {
+				t.Fatalf("second restore reported %v, %v, want false", restored, err)
+			}
+
+			// only deleted posts are purged
+			if purged, err := s.Posts.PurgePost(post.ID); err != nil || purged {
+				t.Fatalf("purging a live post reported %v, %v, want false", purged, err)
+			}
+			if err := s.Posts.DeletePost(post.ID); err != nil {
+				t.Fatalf("failed to delete post: %v", err)
+			}
+			expired, err := s.Posts.ListPostsDeletedBefore(time.Now().Add(time.Hour))
+			if err != nil || len(expired) != 1 {
+				t.Fatalf("got expired posts %+v, %v, want the post", expired, err)
+			}
+			if purged, err := s.Posts.PurgePost(post.ID); err != nil || !purged {
+				t.Fatalf("purge reported %v, %v, want true", purged, err)
+			}
+			if deleted, err := s.Posts.ListDeletedPosts(10, 0); err != nil || len(deleted) != 0 {
+				t.Fatalf("got deleted posts %+v, %v after the purge, want none", deleted, err)
+			}
+			if keys, err := s.Posts.ListMediaFileKeys(); err != nil || len(keys) != 0 {
+				t.Fatalf("got media keys %v, %v after the purge, want none", keys, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"modernc.org/sqlite"
+)
+
+func init() {
+	// the migrations use uuid4() for primary key defaults, which libsql
+	// provides and plain SQLite doesn't
+	sqlite.MustRegisterScalarFunction("uuid4", 0, func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		return id.String(), nil
+	})
+}
+
+// isSQLiteDSN reports whether dsn names a local SQLite database, either a
+// file: URI or :memory:, rather than a libsql server.
+func isSQLiteDSN(dsn string) bool {
+	return dsn == ":memory:" || strings.HasPrefix(dsn, "file:")
+}
+
+// openSQLite opens a local database with foreign keys enforced and times
+// stored in a format SQLite's own date functions understand.
+func openSQLite(dsn string) (*sql.DB, error) {
+	memory := dsn == ":memory:"
+	if memory {
+		// every connection to :memory: gets a database of its own, so name
+		// one and share it between the pool's connections instead
+		id, err := uuid.NewV4()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a new uuid: %w", err)
+		}
+		dsn = fmt.Sprintf("file:%s?mode=memory&cache=shared", id)
+	} else if path, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?"); !strings.Contains(dsn, "mode=memory") {
+		// so DB_URL=file:./data/ryo.db works on a fresh checkout
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	pragmas := "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+	if strings.Contains(dsn, "?") {
+		dsn += "&" + pragmas
+	} else {
+		dsn += "?" + pragmas
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	// a shared in-memory database is dropped with its last connection
+	if memory {
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+	}
+
+	return db, nil
+}
@@ -3,57 +3,185 @@ package store
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
 )
 
+// dbtx is what the SQL stores need from a connection, so the same store can
+// run against the database or inside a transaction.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 type Storage struct {
+	// nil for the in-memory stores and inside WithTx
+	db *sql.DB
+	// nil for the SQL stores
+	memory *memoryDB
+	inTx   bool
+
 	Users interface {
 		Create(*User) error
 		GetBySub(sub string) (*User, error)
 		GetByID(userID string) (*User, error)
 		UsernameExists(username string) (bool, error)
 		UpdateUserName(userID, userName string) error
-		UpdateProfilePicture(userID string, profilePictureURL *string) error
+		UpdateProfilePicture(userID string, profilePictureKey *string) error
+		ListProfilePictureKeys() ([]string, error)
+		SetStorageQuota(userID string, quota *int64) error
 	}
 	Posts interface {
 		CreatePost(*Post) error
 		AddMediaToPost(postID string, media []PostMedia) error
 		GetPostByID(postID string) (*Post, error)
 		GetPostByIDWithUserContext(postID, currentUserID string) (*Post, error)
-		GetAllPosts(limit, offset int) ([]Post, error)
-		GetAllPostsWithUserContext(limit, offset int, currentUserID string) ([]Post, error)
-		GetPostsByUserID(userID string, limit, offset int) ([]Post, error)
-		GetPostsByUserIDWithUserContext(userID string, limit, offset int, currentUserID string) ([]Post, error)
+		ListFeed(FeedQuery) ([]Post, error)
 		DeletePost(postID string) error
+		RestorePost(postID string) (bool, error)
+		ListDeletedPosts(limit, offset int) ([]Post, error)
+		ListPostsDeletedBefore(cutoff time.Time) ([]Post, error)
+		PurgePost(postID string) (bool, error)
 		GetPostMediaByID(mediaID string) (*PostMedia, error)
 		ToggleLike(postID, userID string) (bool, error)
 		GetLikeCount(postID string) (int, error)
 		IsLikedByUser(postID, userID string) (bool, error)
+		ListMediaFileKeys() ([]string, error)
+	}
+	Media interface {
+		Create(*MediaObject) error
+		GetByHash(contentHash string, private bool) (*MediaObject, error)
+		GetByKey(fileKey string) (*MediaObject, error)
+		Release(fileKey string) (int, error)
+		DeleteUnreferenced(fileKey string) error
+		AddVariants(sourceKey string, variants []MediaVariant) error
+		GetVariants(sourceKey string) ([]MediaVariant, error)
+		DeleteVariants(sourceKey string) error
+		SetDimensions(fileKey string, width, height int, blurHash string) error
+		SetVideoMetadata(fileKey string, width, height int, video VideoMetadata) error
+		ListMissingDimensions() ([]string, error)
+	}
+	Files interface {
+		Save(*File) error
+		GetByKey(fileKey string) (*File, error)
+		Delete(fileKey string) error
+		Usage(userID string) (map[string]int64, error)
+		ListPending(cutoff time.Time) ([]File, error)
+		SetUploaded(fileKey string, size int64) error
+	}
+	Uploads interface {
+		Create(*UploadSession) error
+		GetByID(id string) (*UploadSession, error)
+		Finalize(id, fileKey string) (bool, error)
+		DeleteExpired(cutoff time.Time) (int64, error)
+	}
+	Trash interface {
+		Create(*TrashedObject) error
+		GetByID(id string) (*TrashedObject, error)
+		List(limit, offset int) ([]TrashedObject, error)
+		ListDeletedBefore(cutoff time.Time) ([]TrashedObject, error)
+		Delete(id string) (bool, error)
 	}
 }
 
+// NewUserStore connects to the database at dbUrl. A file: URI or :memory:
+// opens a local SQLite database; anything else is a libsql server and token
+// its auth token. Either way the schema is left as it is; see Migrator.
 func NewUserStore(dbUrl string, token []byte) (*Storage, error) {
-	authToken := string(token)
-	url := fmt.Sprintf("%s?authToken=%s", dbUrl, authToken)
+	var db *sql.DB
+	var err error
+	if isSQLiteDSN(dbUrl) {
+		db, err = openSQLite(dbUrl)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		authToken := string(token)
+		url := fmt.Sprintf("%s?authToken=%s", dbUrl, authToken)
 
-	db, err := sql.Open("libsql", url)
-	if err != nil {
-		fmt.Printf("failed to open db %s: %s", url, err)
-		return nil, err
+		db, err = sql.Open("libsql", url)
+		if err != nil {
+			fmt.Printf("failed to open db %s: %s", url, err)
+			return nil, err
+		}
 	}
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
 
-	store := &Storage{
+	return newSQLStorage(db), nil
+}
+
+func newSQLStorage(db *sql.DB) *Storage {
+	s := sqlStores(db)
+	s.db = db
+	return s
+}
+
+func sqlStores(db dbtx) *Storage {
+	return &Storage{
 		Users: &UserStore{
 			db: db,
 		},
 		Posts: &PostStore{
 			db: db,
 		},
+		Media: &MediaStore{
+			db: db,
+		},
+		Files: &FileStore{
+			db: db,
+		},
+		Uploads: &UploadStore{
+			db: db,
+		},
+		Trash: &TrashStore{
+			db: db,
+		},
+	}
+}
+
+// WithTx runs fn with a Storage whose stores all work inside one
+// transaction, which is committed if fn returns nil and rolled back
+// otherwise. Calls made through s rather than the Storage passed to fn are
+// not part of it. Calling WithTx inside fn just runs the inner function in
+// the same transaction.
+func (s *Storage) WithTx(fn func(tx *Storage) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	if s.memory != nil {
+		return s.memory.withTx(fn)
+	}
+	if s.db == nil {
+		return fmt.Errorf("storage has no database to begin a transaction on")
 	}
 
-	return store, nil
+	sqlTx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	tx := sqlStores(sqlTx)
+	tx.inTx = true
+
+	if err := fn(tx); err != nil {
+		if rbErr := sqlTx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Migrator returns a migration runner for the database behind s.
+func (s *Storage) Migrator() (*Migrator, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("storage has no database to migrate")
+	}
+	return NewMigrator(s.db)
 }
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TrashedObject is a deleted object kept under the trash prefix until it is
+// restored or purged. OwnerID and the file fields come from the files row
+// the object had when it was deleted.
+type TrashedObject struct {
+	ID          string    `json:"id"`
+	OriginalKey string    `json:"originalKey"`
+	TrashKey    string    `json:"trashKey"`
+	OwnerID     *string   `json:"ownerId"`
+	FileName    string    `json:"fileName"`
+	ContentType string    `json:"contentType"`
+	FileSize    int64     `json:"fileSize"`
+	Category    string    `json:"category"`
+	DeletedBy   *string   `json:"deletedBy"`
+	DeletedAt   time.Time `json:"deletedAt"`
+}
+
+type TrashStore struct {
+	db dbtx
+}
+
+const trashedObjectColumns = `id, original_key, trash_key, owner_id, file_name, content_type, file_size, category, deleted_by, deleted_at`
+
+func (s *TrashStore) Create(obj *TrashedObject) error {
+	const q = `
+		INSERT INTO trashed_objects (original_key, trash_key, owner_id, file_name, content_type, file_size, category, deleted_by, deleted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id;
+	`
+	return s.db.QueryRow(
+		q,
+		obj.OriginalKey,
+		obj.TrashKey,
+		obj.OwnerID,
+		obj.FileName,
+		obj.ContentType,
+		obj.FileSize,
+		obj.Category,
+		obj.DeletedBy,
+		obj.DeletedAt,
+	).Scan(&obj.ID)
+}
+
+func (s *TrashStore) GetByID(id string) (*TrashedObject, error) {
+	q := `SELECT ` + trashedObjectColumns + ` FROM trashed_objects WHERE id = ?`
+	obj, err := scanTrashedObject(s.db.QueryRow(q, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return obj, err
+}
+
+// List returns trashed objects, most recently deleted first.
+func (s *TrashStore) List(limit, offset int) ([]TrashedObject, error) {
+	q := `SELECT ` + trashedObjectColumns + ` FROM trashed_objects ORDER BY deleted_at DESC LIMIT ? OFFSET ?`
+	return s.query(q, limit, offset)
+}
+
+// ListDeletedBefore returns the objects deleted before cutoff.
+func (s *TrashStore) ListDeletedBefore(cutoff time.Time) ([]TrashedObject, error) {
+	q := `SELECT ` + trashedObjectColumns + ` FROM trashed_objects WHERE deleted_at < ? ORDER BY deleted_at`
+	return s.query(q, cutoff.UTC())
+}
+
+// Delete removes the row for id. It reports false if there was none, so
+// a restore and a purge racing for the same object can tell which one won.
+func (s *TrashStore) Delete(id string) (bool, error) {
+	const q = `DELETE FROM trashed_objects WHERE id = ?`
+	result, err := s.db.Exec(q, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *TrashStore) query(q string, args ...interface{}) ([]TrashedObject, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []TrashedObject
+	for rows.Next() {
+		obj, err := scanTrashedObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, *obj)
+	}
+	return objects, rows.Err()
+}
+
+func scanTrashedObject(row interface{ Scan(...interface{}) error }) (*TrashedObject, error) {
+	obj := new(TrashedObject)
+	var ownerID, fileName, contentType, deletedBy sql.NullString
+	err := row.Scan(
+		&obj.ID,
+		&obj.OriginalKey,
+		&obj.TrashKey,
+		&ownerID,
+		&fileName,
+		&contentType,
+		&obj.FileSize,
+		&obj.Category,
+		&deletedBy,
+		&obj.DeletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if ownerID.Valid {
+		obj.OwnerID = &ownerID.String
+	}
+	if deletedBy.Valid {
+		obj.DeletedBy = &deletedBy.String
+	}
+	obj.FileName = fileName.String
+	obj.ContentType = contentType.String
+	return obj, nil
+}
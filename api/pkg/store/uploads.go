@@ -0,0 +1,119 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	UploadPending   = "pending"
+	UploadFinalized = "finalized"
+)
+
+// UploadSession tracks a post media file the client uploads straight to the
+// bucket. The object can't be attached to a post until the session has been
+// finalized, which is when the API checks what was actually uploaded.
+// FileKey is replaced by the key of an identical object if one existed.
+type UploadSession struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"userId"`
+	FileKey     string     `json:"fileKey"`
+	ContentType string     `json:"contentType"`
+	FileSize    int64      `json:"fileSize"`
+	Status      string     `json:"status"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	FinalizedAt *time.Time `json:"finalizedAt,omitempty"`
+}
+
+type UploadStore struct {
+	db dbtx
+}
+
+func NewUploadSession(userID, fileKey, contentType string, fileSize int64, expiresAt time.Time) *UploadSession {
+	return &UploadSession{
+		UserID:      userID,
+		FileKey:     fileKey,
+		ContentType: contentType,
+		FileSize:    fileSize,
+		Status:      UploadPending,
+		ExpiresAt:   expiresAt.UTC(),
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+func (s *UploadStore) Create(session *UploadSession) error {
+	const q = `
+		INSERT INTO upload_sessions (user_id, file_key, content_type, file_size, status, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id;
+	`
+	return s.db.QueryRow(
+		q,
+		session.UserID,
+		session.FileKey,
+		session.ContentType,
+		session.FileSize,
+		session.Status,
+		session.ExpiresAt,
+		session.CreatedAt,
+	).Scan(&session.ID)
+}
+
+func (s *UploadStore) GetByID(id string) (*UploadSession, error) {
+	const q = `
+		SELECT id, user_id, file_key, content_type, file_size, status, expires_at, created_at, finalized_at
+		FROM upload_sessions
+		WHERE id = ?
+	`
+	session := new(UploadSession)
+	var finalizedAt sql.NullTime
+	err := s.db.QueryRow(q, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.FileKey,
+		&session.ContentType,
+		&session.FileSize,
+		&session.Status,
+		&session.ExpiresAt,
+		&session.CreatedAt,
+		&finalizedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if finalizedAt.Valid {
+		session.FinalizedAt = &finalizedAt.Time
+	}
+	return session, nil
+}
+
+// Finalize marks a pending session as finalized with the key its media ended
+// up under. It reports false if the session was not pending.
+func (s *UploadStore) Finalize(id, fileKey string) (bool, error) {
+	const q = `
+		UPDATE upload_sessions
+		SET status = ?, file_key = ?, finalized_at = ?
+		WHERE id = ? AND status = ?
+	`
+	result, err := s.db.Exec(q, UploadFinalized, fileKey, time.Now().UTC(), id, UploadPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteExpired removes sessions that expired before cutoff and returns how
+// many were removed.
+func (s *UploadStore) DeleteExpired(cutoff time.Time) (int64, error) {
+	const q = `DELETE FROM upload_sessions WHERE expires_at < ?`
+	result, err := s.db.Exec(q, cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
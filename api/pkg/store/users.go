@@ -13,13 +13,14 @@ type User struct {
 	Name              string  `json:"name"`
 	Email             string  `json:"email"`
 	IsAdmin           bool    `json:"isAdmin"`
-	ProfilePictureURL *string `json:"profilePictureUrl,omitempty"`
+	ProfilePictureKey *string `json:"profilePictureKey,omitempty"`
+	StorageQuota      *int64  `json:"storageQuota,omitempty"`
 	CreatedAt         string  `json:"createdAt"`
 	UpdatedAt         string  `json:"updatedAt"`
 }
 
 type UserStore struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewUser(sub string, verified bool, username string, name string, email string) *User {
@@ -59,7 +60,7 @@ func (s *UserStore) Create(user *User) error {
 
 func (s *UserStore) GetBySub(sub string) (*User, error) {
 	const q = `
-    SELECT id, sub, verified, username, name, email, is_admin, profile_picture_url, created_at, updated_at
+    SELECT id, sub, verified, username, name, email, is_admin, profile_picture_key, storage_quota, created_at, updated_at
       FROM users
      WHERE sub = ?
     `
@@ -72,7 +73,8 @@ func (s *UserStore) GetBySub(sub string) (*User, error) {
 		&u.Name,
 		&u.Email,
 		&u.IsAdmin,
-		&u.ProfilePictureURL,
+		&u.ProfilePictureKey,
+		&u.StorageQuota,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -85,13 +87,25 @@ func (s *UserStore) GetBySub(sub string) (*User, error) {
 	return u, nil
 }
 
-func (s *UserStore) UpdateProfilePicture(userID string, profilePictureURL *string) error {
+func (s *UserStore) UpdateProfilePicture(userID string, profilePictureKey *string) error {
 	const q = `
 		UPDATE users
-		SET profile_picture_url = ?
+		SET profile_picture_key = ?
 		WHERE id = ?
 	`
-	_, err := s.db.Exec(q, profilePictureURL, userID)
+	_, err := s.db.Exec(q, profilePictureKey, userID)
+	return err
+}
+
+// SetStorageQuota overrides the default storage quota for a user. A nil
+// quota goes back to the default.
+func (s *UserStore) SetStorageQuota(userID string, quota *int64) error {
+	const q = `
+		UPDATE users
+		SET storage_quota = ?
+		WHERE id = ?
+	`
+	_, err := s.db.Exec(q, quota, userID)
 	return err
 }
 
@@ -107,7 +121,7 @@ func (s *UserStore) UpdateUserName(userID string, username string) error {
 
 func (s *UserStore) GetByID(userID string) (*User, error) {
 	const q = `
-		SELECT id, sub, verified, username, name, email, is_admin, profile_picture_url, created_at, updated_at
+		SELECT id, sub, verified, username, name, email, is_admin, profile_picture_key, storage_quota, created_at, updated_at
 		FROM users
 		WHERE id = ?
 	`
@@ -120,7 +134,8 @@ func (s *UserStore) GetByID(userID string) (*User, error) {
 		&u.Name,
 		&u.Email,
 		&u.IsAdmin,
-		&u.ProfilePictureURL,
+		&u.ProfilePictureKey,
+		&u.StorageQuota,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -145,3 +160,23 @@ func (s *UserStore) UsernameExists(username string) (bool, error) {
 	}
 	return true, nil
 }
+
+func (s *UserStore) ListProfilePictureKeys() ([]string, error) {
+	const q = `SELECT profile_picture_key FROM users WHERE profile_picture_key IS NOT NULL`
+
+	rows, err := s.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,374 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lucialv/ryo.cat/pkg/storage"
+	"github.com/lucialv/ryo.cat/pkg/store"
+)
+
+// Prefix is where deleted objects are kept. Each one is stored under
+// Prefix/<random id>/<original key>, so the same key can be in the trash
+// more than once.
+const Prefix = "trash/"
+
+var (
+	ErrNotFound = errors.New("trashed object not found")
+	ErrConflict = errors.New("an object already exists at the original key")
+)
+
+// Bin moves deleted objects into the trash instead of removing them, and
+// purges them once they have been there longer than Retention. Deleted posts
+// are kept for as long, and purged along with the media only they used.
+type Bin struct {
+	Store     *store.Storage
+	Blobs     storage.BlobStore
+	Retention time.Duration
+
+	// purges list every expired row, so runs never overlap
+	mu sync.Mutex
+}
+
+type PurgeReport struct {
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	Purged      int       `json:"purged"`
+	PostsPurged int       `json:"postsPurged"`
+	Failed      int       `json:"failed"`
+}
+
+func NewBin(store *store.Storage, blobs storage.BlobStore, retention time.Duration) *Bin {
+	return &Bin{
+		Store:     store,
+		Blobs:     blobs,
+		Retention: retention,
+	}
+}
+
+// Move puts the object at key in the trash and deletes it and its file
+// record. category is used when the object has no file record. It returns
+// storage.ErrNotFound if there is no such object.
+func (b *Bin) Move(ctx context.Context, key, category, deletedBy string) (*store.TrashedObject, error) {
+	obj, err := b.stage(ctx, key, category, deletedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.Blobs.DeleteFileWithContext(ctx, key); err != nil {
+		b.unstage(obj)
+		return nil, fmt.Errorf("failed to delete file: %w", err)
+	}
+	b.forget(key)
+
+	return obj, nil
+}
+
+// MoveAll is Move for many keys, deleting the originals in one batch. The
+// returned map holds the error for every key that was not moved.
+func (b *Bin) MoveAll(ctx context.Context, keys []string, category, deletedBy string) map[string]error {
+	failed := make(map[string]error)
+
+	staged := make(map[string]*store.TrashedObject, len(keys))
+	var originals []string
+	for _, key := range keys {
+		if _, ok := staged[key]; ok {
+			continue
+		}
+		obj, err := b.stage(ctx, key, category, deletedBy)
+		if err != nil {
+			failed[key] = err
+			continue
+		}
+		staged[key] = obj
+		originals = append(originals, key)
+	}
+	if len(originals) == 0 {
+		return failed
+	}
+
+	notDeleted, err := b.Blobs.DeleteFilesWithContext(ctx, originals)
+	for _, key := range originals {
+		keyErr := notDeleted[key]
+		if keyErr == nil && err != nil {
+			// the batch failed part way, so the key may or may not be gone
+			if exists, existsErr := b.Blobs.FileExistsWithContext(ctx, key); existsErr != nil || exists {
+				keyErr = err
+			}
+		}
+		if keyErr != nil {
+			b.unstage(staged[key])
+			failed[key] = fmt.Errorf("failed to delete file: %w", keyErr)
+			continue
+		}
+		b.forget(key)
+	}
+
+	return failed
+}
+
+// stage copies the object at key into the trash and records it there,
+// leaving the original in place.
+func (b *Bin) stage(ctx context.Context, key, category, deletedBy string) (*store.TrashedObject, error) {
+	info, err := b.Blobs.GetFileInfoWithContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a new uuid: %w", err)
+	}
+
+	obj := &store.TrashedObject{
+		OriginalKey: key,
+		TrashKey:    fmt.Sprintf("%s%s/%s", Prefix, id, key),
+		ContentType: info.ContentType,
+		FileSize:    info.Size,
+		Category:    category,
+		DeletedAt:   time.Now().UTC(),
+	}
+	if deletedBy != "" {
+		obj.DeletedBy = &deletedBy
+	}
+
+	file, err := b.Store.Files.GetByKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up file record: %w", err)
+	}
+	if file != nil {
+		obj.OwnerID = &file.UserID
+		obj.FileName = file.FileName
+		obj.Category = file.Category
+	}
+
+	if err := b.Blobs.CopyFileWithContext(ctx, key, obj.TrashKey); err != nil {
+		return nil, fmt.Errorf("failed to copy file to trash: %w", err)
+	}
+	if err := b.Store.Trash.Create(obj); err != nil {
+		b.deleteTrashCopy(obj.TrashKey)
+		return nil, fmt.Errorf("failed to record trashed file: %w", err)
+	}
+
+	return obj, nil
+}
+
+// unstage drops a staged object whose original could not be deleted, rather
+// than end up with it both live and in the trash.
+func (b *Bin) unstage(obj *store.TrashedObject) {
+	if _, err := b.Store.Trash.Delete(obj.ID); err != nil {
+		log.Printf("failed to delete trash record %s: %v", obj.ID, err)
+	}
+	b.deleteTrashCopy(obj.TrashKey)
+}
+
+func (b *Bin) forget(key string) {
+	if err := b.Store.Files.Delete(key); err != nil {
+		log.Printf("failed to delete file record %s: %v", key, err)
+	}
+}
+
+// Restore copies a trashed object back to its original key and removes it
+// from the trash. It returns ErrNotFound if id is not in the trash and
+// ErrConflict if something has been stored at the original key since. The
+// caller is responsible for recording the restored file.
+func (b *Bin) Restore(ctx context.Context, id string) (*store.TrashedObject, error) {
+	obj, err := b.Store.Trash.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trashed file: %w", err)
+	}
+	if obj == nil {
+		return nil, ErrNotFound
+	}
+
+	exists, err := b.Blobs.FileExistsWithContext(ctx, obj.OriginalKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check original key: %w", err)
+	}
+	if exists {
+		return nil, ErrConflict
+	}
+
+	if err := b.Blobs.CopyFileWithContext(ctx, obj.TrashKey, obj.OriginalKey); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// purged or restored by someone else since the lookup
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to copy file from trash: %w", err)
+	}
+
+	// whoever removes the row owns the restore; a racing request copied the
+	// same bytes to the same key and can just report the object gone
+	deleted, err := b.Store.Trash.Delete(obj.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete trash record: %w", err)
+	}
+	if !deleted {
+		return nil, ErrNotFound
+	}
+	b.deleteTrashCopy(obj.TrashKey)
+
+	return obj, nil
+}
+
+// List returns trashed objects, most recently deleted first.
+func (b *Bin) List(limit, offset int) ([]store.TrashedObject, error) {
+	objects, err := b.Store.Trash.List(limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	return objects, nil
+}
+
+// Purge permanently deletes every object that has been in the trash, and
+// every post that has been deleted, for longer than Retention.
+func (b *Bin) Purge() (*PurgeReport, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	report := &PurgeReport{StartedAt: time.Now().UTC()}
+	cutoff := report.StartedAt.Add(-b.Retention)
+
+	if err := b.purgePosts(cutoff, report); err != nil {
+		return nil, err
+	}
+
+	expired, err := b.Store.Trash.ListDeletedBefore(cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired trash: %w", err)
+	}
+
+	if len(expired) == 0 {
+		report.FinishedAt = time.Now().UTC()
+		return report, nil
+	}
+
+	keys := make([]string, len(expired))
+	for i, obj := range expired {
+		keys[i] = obj.TrashKey
+	}
+	failed, err := b.Blobs.DeleteFiles(keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge trash: %w", err)
+	}
+
+	for _, obj := range expired {
+		if err := failed[obj.TrashKey]; err != nil {
+			log.Printf("failed to purge %s: %v", obj.TrashKey, err)
+			report.Failed++
+			continue
+		}
+		if _, err := b.Store.Trash.Delete(obj.ID); err != nil {
+			log.Printf("failed to delete trash record %s: %v", obj.ID, err)
+			report.Failed++
+			continue
+		}
+		report.Purged++
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// Start purges the trash every interval until the returned stop function is
+// called.
+func (b *Bin) Start(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report, err := b.Purge()
+				if err != nil {
+					log.Printf("trash purge failed: %v", err)
+					continue
+				}
+				if report.Purged > 0 || report.PostsPurged > 0 || report.Failed > 0 {
+					log.Printf("trash purge: %d purged, %d posts purged, %d failed", report.Purged, report.PostsPurged, report.Failed)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// purgePosts removes the posts deleted before cutoff. Media can be shared
+// with other posts, so only the last reference removes the file.
+func (b *Bin) purgePosts(cutoff time.Time, report *PurgeReport) error {
+	posts, err := b.Store.Posts.ListPostsDeletedBefore(cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to list expired posts: %w", err)
+	}
+
+	for _, post := range posts {
+		purged, err := b.Store.Posts.PurgePost(post.ID)
+		if err != nil {
+			log.Printf("failed to purge post %s: %v", post.ID, err)
+			report.Failed++
+			continue
+		}
+		if !purged {
+			// restored since it was listed
+			continue
+		}
+		report.PostsPurged++
+
+		for _, media := range post.Media {
+			remaining, err := b.Store.Media.Release(media.FileKey)
+			if err != nil {
+				log.Printf("failed to release media file %s: %v", media.FileKey, err)
+				continue
+			}
+			if remaining == 0 {
+				b.deleteMedia(media.FileKey)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteMedia removes a media file nothing uses any more, along with its
+// resized variants. What fails here is left to the collector.
+func (b *Bin) deleteMedia(key string) {
+	keys := []string{key}
+	variants, err := b.Store.Media.GetVariants(key)
+	if err != nil {
+		log.Printf("failed to get variants of %s: %v", key, err)
+	}
+	for _, v := range variants {
+		keys = append(keys, v.FileKey)
+	}
+
+	failed, err := b.Blobs.DeleteFiles(keys)
+	if err != nil {
+		log.Printf("failed to delete media file %s: %v", key, err)
+		return
+	}
+	for k, err := range failed {
+		log.Printf("failed to delete media file %s: %v", k, err)
+	}
+	if len(variants) > 0 {
+		if err := b.Store.Media.DeleteVariants(key); err != nil {
+			log.Printf("failed to delete variant records of %s: %v", key, err)
+		}
+	}
+	b.forget(key)
+}
+
+func (b *Bin) deleteTrashCopy(key string) {
+	if err := b.Blobs.DeleteFile(key); err != nil {
+		log.Printf("failed to delete trash copy %s: %v", key, err)
+	}
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// SniffContentType detects the content type of r from its first 512 bytes,
+// which is all http.DetectContentType looks at. The returned reader replays
+// those bytes, so it yields the complete stream.
+func SniffContentType(r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	head = head[:n]
+
+	return http.DetectContentType(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
@@ -37,9 +37,11 @@ export interface CreatePostRequest {
 
 export interface PostsListResponse {
   posts: Post[];
-  page: number;
+  /** @deprecated only set for page-based requests; use nextCursor */
+  page?: number;
   limit: number;
   hasMore: boolean;
+  nextCursor?: string;
 }
 
 export interface UploadMediaResponse {
@@ -86,6 +88,25 @@ export const postsApi = {
     return response.json();
   },
 
+  getPostsAfter: async (
+    cursor?: string,
+    limit = 10,
+  ): Promise<PostsListResponse> => {
+    const params = new URLSearchParams({ limit: String(limit) });
+    if (cursor) params.set("cursor", cursor);
+
+    const response = await fetch(`${API_BASE_URL}/v1/posts?${params}`, {
+      credentials: "include",
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.Error || "Failed to fetch posts");
+    }
+
+    return response.json();
+  },
+
   getPost: async (postId: string): Promise<Post> => {
     const response = await fetch(`${API_BASE_URL}/v1/posts/${postId}`, {
       credentials: "include",
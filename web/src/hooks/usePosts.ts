@@ -9,8 +9,8 @@ import { postsApi, type CreatePostRequest, type Post } from "@/api/posts";
 
 type PostsPage = {
   posts: Post[];
-  page: number;
   hasMore: boolean;
+  nextCursor?: string;
 };
 
 export const postsKeys = {
@@ -34,10 +34,10 @@ export const usePosts = (page = 1, limit = 10) => {
 export const useInfinitePosts = (limit = 10) => {
   return useInfiniteQuery({
     queryKey: postsKeys.lists(),
-    queryFn: ({ pageParam = 1 }) => postsApi.getPosts(pageParam, limit),
-    initialPageParam: 1,
+    queryFn: ({ pageParam }) => postsApi.getPostsAfter(pageParam, limit),
+    initialPageParam: undefined as string | undefined,
     getNextPageParam: (lastPage) => {
-      return lastPage.hasMore ? lastPage.page + 1 : undefined;
+      return lastPage.hasMore ? lastPage.nextCursor : undefined;
     },
     staleTime: 1000 * 60 * 5, // 5 minutes :)
   });